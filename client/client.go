@@ -0,0 +1,549 @@
+// Package client provides typed Go client for the REST API
+// exposed by the URL shortener service.
+//
+// Copyright 2019 cranki. All rights reserved.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client exports API for managing links registered in the URL
+// shortener service. It is safe for concurrent use
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      string
+	retries    int
+	backoff    time.Duration
+}
+
+// Option configures Client created by New
+type Option func(client *Client)
+
+// WithHTTPClient sets the underlying HTTP client. By default
+// http.Client with 30 seconds timeout is used
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(client *Client) {
+		client.httpClient = httpClient
+	}
+}
+
+// WithToken sets the token sent as bearer authorization
+// with every request, e.g. the API key of the workspace
+func WithToken(token string) Option {
+	return func(client *Client) {
+		client.token = token
+	}
+}
+
+// WithRetries sets how many times a request failing with
+// server error (5xx), too many requests (429) or network error
+// is retried. Only the requests reading links and domains are
+// retried, since retrying Create, Extend or Delete may apply them
+// twice and resolving a link counts as a click. The delay before
+// the n-th retry is backoff * 2^n with jitter, unless the server
+// sends Retry-After header, which is capped at backoff *
+// 2^retries. Too many failed password attempts (429) are never
+// retried. By default requests are retried 3 times with 100ms
+// backoff
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(client *Client) {
+		client.retries = retries
+		client.backoff = backoff
+	}
+}
+
+// New creates and returns Client for the service running on
+// baseURL, e.g. "https://s.example.com". In case the service
+// is mounted under path prefix, it should be part of baseURL
+func New(baseURL string, options ...Option) *Client {
+	client := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		retries:    3,
+		backoff:    100 * time.Millisecond,
+	}
+
+	for _, option := range options {
+		option(client)
+	}
+
+	return client
+}
+
+// Link represents link registered in the service
+type Link struct {
+	ID         string    `json:"id"`
+	ShortURL   string    `json:"short_url"`
+	URL        string    `json:"url"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	ClickCount int64     `json:"click_count"`
+	Tags       []string  `json:"tags"`
+	Owner      string    `json:"owner"`
+	Protected  bool      `json:"protected"`
+
+	// MaxClicks and RemainingClicks are set only for links with
+	// maximum clicks
+	MaxClicks       int64  `json:"max_clicks,omitempty"`
+	RemainingClicks *int64 `json:"remaining_clicks,omitempty"`
+
+	// NotBefore is set only for links with scheduled activation
+	NotBefore *time.Time `json:"not_before,omitempty"`
+
+	// Targets is set only for links with platform targets
+	Targets map[string]string `json:"targets,omitempty"`
+
+	// Variants and StickyVariants are set only for links with
+	// variants
+	Variants       []Variant `json:"variants,omitempty"`
+	StickyVariants bool      `json:"sticky_variants,omitempty"`
+
+	// Query settings are set only when enabled
+	ForwardQuery  bool              `json:"forward_query,omitempty"`
+	QueryOverride bool              `json:"query_override,omitempty"`
+	UTM           map[string]string `json:"utm,omitempty"`
+
+	// RedirectStatus is set only for links with own redirect status
+	RedirectStatus int `json:"redirect_status,omitempty"`
+
+	// Preview is set only for links always showing the preview page
+	Preview bool `json:"preview,omitempty"`
+
+	// Check is set only for links whose destination has been
+	// checked
+	Check *Check `json:"check,omitempty"`
+}
+
+// Check represents the last health check of the destination of
+// a link. Status 0 means the destination could not be reached
+type Check struct {
+	Status    int       `json:"status"`
+	Healthy   bool      `json:"healthy"`
+	LatencyMS int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Variant represents weighted destination of a link. ClickCount
+// is set only in the links returned by the service
+type Variant struct {
+	Name       string `json:"name,omitempty"`
+	URL        string `json:"url"`
+	Weight     int    `json:"weight,omitempty"`
+	ClickCount int64  `json:"click_count,omitempty"`
+}
+
+// CreateRequest represents the parameters of a new link.
+// In case ID is empty, the service generates one. Targets maps
+// platforms (ios, android, desktop) to the urls they are
+// redirected to instead of URL. In case Variants are set, the
+// traffic is split across them by their weights. Variants
+// without name are named a, b, c... and variants without weight
+// get weight 1. In case ForwardQuery is set, the query of the
+// short link is merged into the target, which wins conflicting
+// parameters unless QueryOverride is set. UTM parameters are
+// appended unless the target or the query sets them.
+// RedirectStatus is 301, 302, 307 or 308, the default of the
+// service is used when it is 0. Preview makes the service show
+// the preview page with the destination before every redirect
+type CreateRequest struct {
+	ID        string     `json:"id,omitempty"`
+	URL       string     `json:"url"`
+	Tags      []string   `json:"tags,omitempty"`
+	Owner     string     `json:"owner,omitempty"`
+	Password  string     `json:"password,omitempty"`
+	MaxClicks int64      `json:"max_clicks,omitempty"`
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	Targets map[string]string `json:"targets,omitempty"`
+
+	Variants       []Variant `json:"variants,omitempty"`
+	StickyVariants bool      `json:"sticky_variants,omitempty"`
+
+	ForwardQuery  bool              `json:"forward_query,omitempty"`
+	QueryOverride bool              `json:"query_override,omitempty"`
+	UTM           map[string]string `json:"utm,omitempty"`
+
+	RedirectStatus int  `json:"redirect_status,omitempty"`
+	Preview        bool `json:"preview,omitempty"`
+}
+
+// ListOptions represents the filter and the page of links
+// returned by List. Zero values are omitted, in which case
+// the service defaults apply
+type ListOptions struct {
+	Owner  string
+	Tag    string
+	Offset int
+	Limit  int
+}
+
+// Stats represents the click statistics of a link
+type Stats struct {
+	ID         string    `json:"id"`
+	ClickCount int64     `json:"click_count"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+
+	// Variants break the click count down by variant and are
+	// set only for links with variants
+	Variants []Variant `json:"variants,omitempty"`
+}
+
+// Domain represents branded domain serving the links of its own
+// namespace. The links of the domain are managed by the Client
+// whose base url is on the domain
+type Domain struct {
+	Host      string    `json:"host"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// NotFoundPage is set only for domains with own page
+	NotFoundPage string `json:"not_found_page,omitempty"`
+}
+
+// DomainRequest represents the input for registering branded
+// domain. NotFoundPage is the HTML page sent to browsers for ids
+// which do not exist on the domain
+type DomainRequest struct {
+	Host         string `json:"host"`
+	NotFoundPage string `json:"not_found_page,omitempty"`
+}
+
+// Create registers new link. In case of invalid request
+// *ValidationError is returned, in case the id or the url is
+// already registered *ConflictError is returned and in case the
+// workspace has reached its quota *QuotaError is returned
+func (client *Client) Create(ctx context.Context, req CreateRequest) (link *Link, err error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return
+	}
+
+	link = &Link{}
+	err = client.do(ctx, "POST", "/api/v2/links", body, http.StatusCreated, link)
+	if err != nil {
+		link = nil
+	}
+
+	return
+}
+
+// Get returns the link registered under id. In case there
+// is no such link *NotFoundError is returned
+func (client *Client) Get(ctx context.Context, id string) (link *Link, err error) {
+	link = &Link{}
+	err = client.do(ctx, "GET", "/api/v2/links/"+url.PathEscape(id), nil, http.StatusOK, link)
+	if err != nil {
+		link = nil
+	}
+
+	return
+}
+
+// Resolve returns the URL to which the short link with the
+// provided id redirects. Resolving counts as a click. In case
+// there is no such link *NotFoundError is returned
+func (client *Client) Resolve(ctx context.Context, id string) (target string, err error) {
+	return client.ResolveWithPassword(ctx, id, "")
+}
+
+// ResolveWithPassword is like Resolve, but sends the password
+// of protected link. In case the password is missing or
+// incorrect *AccessError is returned
+func (client *Client) ResolveWithPassword(ctx context.Context, id string, password string) (target string, err error) {
+	header := http.Header{}
+	if password != "" {
+		header.Set("X-Link-Password", password)
+	}
+
+	resp, err := client.send(ctx, "GET", "/api/urls/"+url.PathEscape(id), nil, header, false)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		err = decodeError(resp)
+		return
+	}
+
+	target = resp.Header.Get("Location")
+
+	return
+}
+
+// Extend sets new expiration time of the link registered
+// under id and returns the updated link. In case expiresAt is
+// not in the future *ValidationError is returned and in case
+// there is no such link *NotFoundError is returned
+func (client *Client) Extend(ctx context.Context, id string, expiresAt time.Time) (link *Link, err error) {
+	body, err := json.Marshal(map[string]time.Time{"expires_at": expiresAt})
+	if err != nil {
+		return
+	}
+
+	link = &Link{}
+	err = client.do(ctx, "PATCH", "/api/v2/links/"+url.PathEscape(id), body, http.StatusOK, link)
+	if err != nil {
+		link = nil
+	}
+
+	return
+}
+
+// Delete removes the link registered under id. In case there
+// is no such link *NotFoundError is returned
+func (client *Client) Delete(ctx context.Context, id string) (err error) {
+	err = client.do(ctx, "DELETE", "/api/v2/links/"+url.PathEscape(id), nil, http.StatusNoContent, nil)
+	return
+}
+
+// List returns the links matching the provided options
+func (client *Client) List(ctx context.Context, options ListOptions) (links []Link, err error) {
+	query := url.Values{}
+	if options.Owner != "" {
+		query.Set("owner", options.Owner)
+	}
+	if options.Tag != "" {
+		query.Set("tag", options.Tag)
+	}
+	if options.Offset != 0 {
+		query.Set("offset", strconv.Itoa(options.Offset))
+	}
+	if options.Limit != 0 {
+		query.Set("limit", strconv.Itoa(options.Limit))
+	}
+
+	path := "/api/v2/links"
+	if len(query) != 0 {
+		path += "?" + query.Encode()
+	}
+
+	var list struct {
+		Links []Link `json:"links"`
+	}
+	err = client.do(ctx, "GET", path, nil, http.StatusOK, &list)
+	if err != nil {
+		return
+	}
+
+	links = list.Links
+
+	return
+}
+
+// Stats returns the click statistics of the link registered
+// under id. In case there is no such link *NotFoundError is
+// returned
+func (client *Client) Stats(ctx context.Context, id string) (stats *Stats, err error) {
+	stats = &Stats{}
+	err = client.do(ctx, "GET", "/api/v2/links/"+url.PathEscape(id)+"/stats", nil, http.StatusOK, stats)
+	if err != nil {
+		stats = nil
+	}
+
+	return
+}
+
+// CreateDomain registers new branded domain. In case of invalid
+// request *ValidationError is returned and in case the host is
+// already registered *ConflictError is returned
+func (client *Client) CreateDomain(ctx context.Context, req DomainRequest) (domain *Domain, err error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return
+	}
+
+	domain = &Domain{}
+	err = client.do(ctx, "POST", "/api/v2/domains", body, http.StatusCreated, domain)
+	if err != nil {
+		domain = nil
+	}
+
+	return
+}
+
+// GetDomain returns the branded domain of the host. In case there
+// is no such domain *NotFoundError is returned
+func (client *Client) GetDomain(ctx context.Context, host string) (domain *Domain, err error) {
+	domain = &Domain{}
+	err = client.do(ctx, "GET", "/api/v2/domains/"+url.PathEscape(host), nil, http.StatusOK, domain)
+	if err != nil {
+		domain = nil
+	}
+
+	return
+}
+
+// ListDomains returns all branded domains ordered by their hosts
+func (client *Client) ListDomains(ctx context.Context) (domains []Domain, err error) {
+	var list struct {
+		Domains []Domain `json:"domains"`
+	}
+
+	err = client.do(ctx, "GET", "/api/v2/domains", nil, http.StatusOK, &list)
+	if err != nil {
+		return
+	}
+
+	domains = list.Domains
+
+	return
+}
+
+// DeleteDomain removes the branded domain of the host, keeping
+// its links. In case there is no such domain *NotFoundError is
+// returned
+func (client *Client) DeleteDomain(ctx context.Context, host string) (err error) {
+	err = client.do(ctx, "DELETE", "/api/v2/domains/"+url.PathEscape(host), nil, http.StatusNoContent, nil)
+	return
+}
+
+func (client *Client) do(ctx context.Context, method string, path string, body []byte, status int, v interface{}) (err error) {
+	resp, err := client.send(ctx, method, path, body, nil, method == "GET")
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != status {
+		err = decodeError(resp)
+		return
+	}
+
+	if v == nil {
+		return
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+
+	return
+}
+
+// send sends the request, retrying it in case retry is set and
+// it fails with server error, too many requests or network error
+func (client *Client) send(ctx context.Context, method string, path string, body []byte, header http.Header, retry bool) (resp *http.Response, err error) {
+	for attempt := 0; ; attempt++ {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+
+		var req *http.Request
+		req, err = http.NewRequest(method, client.baseURL+path, reader)
+		if err != nil {
+			return
+		}
+		req = req.WithContext(ctx)
+
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if client.token != "" {
+			req.Header.Set("Authorization", "Bearer "+client.token)
+		}
+
+		resp, err = client.noRedirect().Do(req)
+
+		failed := err != nil || resp.StatusCode >= 500 || (resp.StatusCode == http.StatusTooManyRequests && !tooManyAttempts(resp))
+		if !retry || !failed || attempt >= client.retries || ctx.Err() != nil {
+			return
+		}
+
+		delay := client.delay(attempt, resp)
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			resp = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (client *Client) delay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			// Retry-After may point to the activation of the link
+			// days ahead, so it is capped at the backoff ceiling
+			delay := time.Duration(seconds) * time.Second
+			if ceiling := client.backoff << uint(client.retries); delay > ceiling {
+				delay = ceiling
+			}
+
+			return delay
+		}
+	}
+
+	delay := client.backoff << uint(attempt)
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// tooManyAttempts reports whether the response rejects the
+// request for too many failed password attempts. The body is
+// restored, so it can be decoded afterwards
+func tooManyAttempts(resp *http.Response) bool {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	var problem Problem
+	if json.Unmarshal(body, &problem) != nil {
+		return false
+	}
+
+	return problem.Code == CodeTooManyAttempts
+}
+
+// noRedirect returns copy of the HTTP client which does not
+// follow redirects, so Resolve can read the target
+func (client *Client) noRedirect() *http.Client {
+	httpClient := *client.httpClient
+	httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return &httpClient
+}
+
+func decodeError(resp *http.Response) error {
+	problem := &Problem{Status: resp.StatusCode}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(body, problem) != nil || problem.Code == "" {
+		problem.Title = http.StatusText(resp.StatusCode)
+		problem.Detail = strings.TrimSpace(string(body))
+	}
+
+	return newError(problem)
+}
@@ -0,0 +1,342 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/georgiv/url-shortener/client"
+	"github.com/georgiv/url-shortener/server/web"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+func newServer() *httptest.Server {
+	return httptest.NewServer(web.NewHandler(testdata.NewMemoryWorker(), web.Options{}, nil))
+}
+
+func TestCreateAndGet(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+
+	c := client.New(server.URL)
+	ctx := context.Background()
+
+	link, err := c.Create(ctx, client.CreateRequest{
+		ID:   "cranki",
+		URL:  "http://testurl.com",
+		Tags: []string{"docs"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if link.ShortURL != server.URL+"/api/urls/cranki" {
+		t.Errorf("Expected %v/api/urls/cranki, received %v", server.URL, link.ShortURL)
+	}
+
+	target, err := c.Resolve(ctx, "cranki")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if target != "http://testurl.com" {
+		t.Errorf("Expected http://testurl.com, received %v", target)
+	}
+
+	link, err = c.Get(ctx, "cranki")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if link.URL != "http://testurl.com" {
+		t.Errorf("Expected http://testurl.com, received %v", link.URL)
+	}
+
+	stats, err := c.Stats(ctx, "cranki")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stats.ClickCount != 1 {
+		t.Errorf("Expected 1, received %v", stats.ClickCount)
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+
+	c := client.New(server.URL)
+	ctx := context.Background()
+
+	for _, req := range []client.CreateRequest{
+		{ID: "cranki", URL: "http://testurl.com", Tags: []string{"docs"}},
+		{ID: "tester", URL: "http://anothertesturl.com"},
+	} {
+		_, err := c.Create(ctx, req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	links, err := c.List(ctx, client.ListOptions{Tag: "docs"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(links) != 1 || links[0].ID != "cranki" {
+		t.Errorf("Expected [cranki], received %v", links)
+	}
+
+	err = c.Delete(ctx, "cranki")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err = c.Delete(ctx, "cranki")
+	if _, ok := err.(*client.NotFoundError); !ok {
+		t.Errorf("Expected *client.NotFoundError, received %T", err)
+	}
+
+	links, err = c.List(ctx, client.ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(links) != 1 || links[0].ID != "tester" {
+		t.Errorf("Expected [tester], received %v", links)
+	}
+}
+
+func TestDomains(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+
+	c := client.New(server.URL)
+	ctx := context.Background()
+
+	domain, err := c.CreateDomain(ctx, client.DomainRequest{Host: "s.brand.com", NotFoundPage: "<h1>Not here</h1>"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if domain.Host != "s.brand.com" || domain.NotFoundPage != "<h1>Not here</h1>" {
+		t.Errorf("Unexpected domain: %+v", domain)
+	}
+
+	_, err = c.CreateDomain(ctx, client.DomainRequest{Host: "s.brand.com"})
+	if e, ok := err.(*client.ConflictError); !ok || e.Code != client.CodeDomainTaken {
+		t.Errorf("Expected *client.ConflictError with %v, received %v", client.CodeDomainTaken, err)
+	}
+
+	domains, err := c.ListDomains(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(domains) != 1 || domains[0].Host != "s.brand.com" {
+		t.Errorf("Expected [s.brand.com], received %v", domains)
+	}
+
+	err = c.DeleteDomain(ctx, "s.brand.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = c.GetDomain(ctx, "s.brand.com")
+	if _, ok := err.(*client.NotFoundError); !ok {
+		t.Errorf("Expected *client.NotFoundError, received %T", err)
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+
+	c := client.New(server.URL)
+	ctx := context.Background()
+
+	_, err := c.Create(ctx, client.CreateRequest{ID: "crank ", URL: "testurl"})
+	validationErr, ok := err.(*client.ValidationError)
+	if !ok {
+		t.Fatalf("Expected *client.ValidationError, received %T", err)
+	}
+	if validationErr.Code != client.CodeValidationFailed {
+		t.Errorf("Expected %v, received %v", client.CodeValidationFailed, validationErr.Code)
+	}
+	if len(validationErr.Fields()) != 2 {
+		t.Errorf("Expected 2 field errors, received %v", validationErr.Fields())
+	}
+
+	_, err = c.Create(ctx, client.CreateRequest{ID: "cranki", URL: "http://testurl.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = c.Create(ctx, client.CreateRequest{ID: "tester", URL: "http://testurl.com"})
+	conflictErr, ok := err.(*client.ConflictError)
+	if !ok {
+		t.Fatalf("Expected *client.ConflictError, received %T", err)
+	}
+	if conflictErr.Code != client.CodeURLTaken || conflictErr.ID != "cranki" {
+		t.Errorf("Expected %v for cranki, received %v for %v", client.CodeURLTaken, conflictErr.Code, conflictErr.ID)
+	}
+
+	_, err = c.Create(ctx, client.CreateRequest{URL: "http://localhost:8080/admin"})
+	policyErr, ok := err.(*client.PolicyError)
+	if !ok {
+		t.Fatalf("Expected *client.PolicyError, received %T", err)
+	}
+	if policyErr.Code != client.CodeURLBlocked || len(policyErr.Fields()) != 1 {
+		t.Errorf("Expected %v for url, received %v for %v", client.CodeURLBlocked, policyErr.Code, policyErr.Fields())
+	}
+
+	_, err = c.Resolve(ctx, "tester")
+	if _, ok := err.(*client.NotFoundError); !ok {
+		t.Errorf("Expected *client.NotFoundError, received %T", err)
+	}
+}
+
+func TestResolveWithPassword(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+
+	c := client.New(server.URL)
+	ctx := context.Background()
+
+	link, err := c.Create(ctx, client.CreateRequest{ID: "secret", URL: "http://testurl.com", Password: "s3cr3t"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !link.Protected {
+		t.Errorf("Expected protected link")
+	}
+
+	_, err = c.Resolve(ctx, "secret")
+	accessErr, ok := err.(*client.AccessError)
+	if !ok {
+		t.Fatalf("Expected *client.AccessError, received %T", err)
+	}
+	if accessErr.Code != client.CodePasswordRequired {
+		t.Errorf("Expected %v, received %v", client.CodePasswordRequired, accessErr.Code)
+	}
+
+	target, err := c.ResolveWithPassword(ctx, "secret", "s3cr3t")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if target != "http://testurl.com" {
+		t.Errorf("Expected http://testurl.com, received %v", target)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "http://testurl.com")
+	handler := web.NewHandler(dbWorker, web.Options{}, nil)
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Expected Bearer secret, received %v", r.Header.Get("Authorization"))
+		}
+
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	}))
+	defer server.Close()
+
+	c := client.New(server.URL, client.WithToken("secret"), client.WithRetries(2, time.Millisecond))
+
+	_, err := c.Get(context.Background(), "cranki")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 calls, received %v", calls)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+
+	c = client.New(server.URL, client.WithToken("secret"), client.WithRetries(1, time.Millisecond))
+
+	_, err = c.Get(context.Background(), "cranki")
+	clientErr, ok := err.(*client.Error)
+	if !ok {
+		t.Fatalf("Expected *client.Error, received %T", err)
+	}
+	if clientErr.Status != 429 {
+		t.Errorf("Expected 429, received %v", clientErr.Status)
+	}
+
+	for _, call := range []func() error{
+		func() error {
+			_, err := c.Create(context.Background(), client.CreateRequest{URL: "http://testurl.com"})
+			return err
+		},
+		func() error {
+			_, err := c.Resolve(context.Background(), "cranki")
+			return err
+		},
+	} {
+		atomic.StoreInt32(&calls, 0)
+
+		err := call()
+		clientErr, ok := err.(*client.Error)
+		if !ok {
+			t.Fatalf("Expected *client.Error, received %T", err)
+		}
+		if clientErr.Status != 503 {
+			t.Errorf("Expected 503, received %v", clientErr.Status)
+		}
+		if calls != 1 {
+			t.Errorf("Expected 1 call, received %v", calls)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+
+		if r.URL.Path == "/api/v2/links/locked" {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"status": 429, "code": "too_many_attempts"}`))
+			return
+		}
+
+		w.Header().Set("Retry-After", "86400")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := client.New(server.URL, client.WithRetries(2, time.Millisecond))
+
+	start := time.Now()
+	_, err := c.Get(context.Background(), "cranki")
+	if _, ok := err.(*client.Error); !ok {
+		t.Fatalf("Expected *client.Error, received %T", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 calls, received %v", calls)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected Retry-After capped at the backoff, waited %v", elapsed)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+
+	_, err = c.Get(context.Background(), "locked")
+	if _, ok := err.(*client.AccessError); !ok {
+		t.Fatalf("Expected *client.AccessError, received %T", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 call, received %v", calls)
+	}
+}
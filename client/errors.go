@@ -0,0 +1,163 @@
+package client
+
+import "fmt"
+
+// Machine-readable codes of the problems returned by the service
+const (
+	CodeInvalidJSON         = "invalid_json"
+	CodeBodyTooLarge        = "body_too_large"
+	CodeValidationFailed    = "validation_failed"
+	CodeInvalidURL          = "invalid_url"
+	CodeURLTooLong          = "url_too_long"
+	CodeAliasInvalid        = "alias_invalid"
+	CodeTagInvalid          = "tag_invalid"
+	CodeOwnerInvalid        = "owner_invalid"
+	CodePasswordInvalid     = "password_invalid"
+	CodeMaxClicksInvalid    = "max_clicks_invalid"
+	CodeNotBeforeInvalid    = "not_before_invalid"
+	CodeExpirationInvalid   = "expiration_invalid"
+	CodeTargetInvalid       = "target_invalid"
+	CodeVariantInvalid      = "variant_invalid"
+	CodeUTMInvalid          = "utm_invalid"
+	CodeRedirectInvalid     = "redirect_status_invalid"
+	CodeURLBlocked          = "url_blocked"
+	CodeSelfReference       = "url_self_reference"
+	CodeAliasTaken          = "alias_taken"
+	CodeURLTaken            = "url_taken"
+	CodeQuotaExceeded       = "quota_exceeded"
+	CodeAPIKeyRequired      = "api_key_required"
+	CodeAPIKeyInvalid       = "api_key_invalid"
+	CodeHostInvalid         = "host_invalid"
+	CodeNotFoundPageInvalid = "not_found_page_invalid"
+	CodeDomainTaken         = "domain_taken"
+	CodeForbidden           = "forbidden"
+	CodeInvalidParameter    = "invalid_parameter"
+	CodeNotFound            = "not_found"
+	CodePasswordRequired    = "password_required"
+	CodePasswordIncorrect   = "password_incorrect"
+	CodeTooManyAttempts     = "too_many_attempts"
+	CodeLinkExhausted       = "link_exhausted"
+	CodeLinkNotActive       = "link_not_active"
+	CodeLinkExpired         = "link_expired"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeInternal            = "internal_error"
+)
+
+// Problem represents RFC 7807 problem details returned by the
+// service. It is embedded in all errors returned by Client for
+// unsuccessful responses
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail"`
+	Instance string       `json:"instance"`
+	Code     string       `json:"code"`
+	ID       string       `json:"id"`
+	URL      string       `json:"url"`
+	Errors   []FieldError `json:"errors"`
+}
+
+// FieldError represents validation error of single field of
+// the request
+type FieldError struct {
+	Field  string `json:"field"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+func (problem *Problem) Error() string {
+	if problem.Detail == "" {
+		return fmt.Sprintf("url-shortener: %v %v", problem.Status, problem.Title)
+	}
+
+	return fmt.Sprintf("url-shortener: %v %v: %v", problem.Status, problem.Title, problem.Detail)
+}
+
+// ValidationError is returned when the service rejects the
+// request as invalid (400 and 413). Fields holds the
+// field-level errors
+type ValidationError struct {
+	*Problem
+}
+
+// Fields returns the field-level validation errors
+func (err *ValidationError) Fields() []FieldError {
+	return err.Errors
+}
+
+// ConflictError is returned when the id or the url is already
+// registered (409). ID and URL of the embedded Problem refer to
+// the existing link
+type ConflictError struct {
+	*Problem
+}
+
+// PolicyError is returned when the urls of the request are
+// valid, but rejected by the url policy of the service (422),
+// e.g. for their scheme, private host or denied domain, or as
+// they point to short links of the service. Fields holds the
+// rejected urls
+type PolicyError struct {
+	*Problem
+}
+
+// Fields returns the errors of the rejected urls
+func (err *PolicyError) Fields() []FieldError {
+	return err.Errors
+}
+
+// NotFoundError is returned when the requested link does not
+// exist (404)
+type NotFoundError struct {
+	*Problem
+}
+
+// AccessError is returned when the password of protected link
+// is missing (401) or incorrect (403), when there were too
+// many failed attempts (429) or when the link has not been
+// activated yet (link_not_active with the status configured
+// on the server). It is returned as well when the API key of
+// workspace is missing or invalid (401)
+type AccessError struct {
+	*Problem
+}
+
+// QuotaError is returned when the workspace has reached its
+// maximum number of links (quota_exceeded)
+type QuotaError struct {
+	*Problem
+}
+
+// GoneError is returned when the link exists, but cannot be
+// resolved anymore (410), i.e. it has reached its maximum
+// clicks or expired
+type GoneError struct {
+	*Problem
+}
+
+// Error is returned for all other unsuccessful responses
+type Error struct {
+	*Problem
+}
+
+func newError(problem *Problem) error {
+	switch {
+	case problem.Code == CodeQuotaExceeded:
+		return &QuotaError{problem}
+	case problem.Status == 400 || problem.Status == 413:
+		return &ValidationError{problem}
+	case problem.Status == 422:
+		return &PolicyError{problem}
+	case problem.Status == 409:
+		return &ConflictError{problem}
+	case problem.Status == 404:
+		return &NotFoundError{problem}
+	case problem.Status == 410:
+		return &GoneError{problem}
+	case problem.Status == 401 || problem.Status == 403 || problem.Code == CodeTooManyAttempts || problem.Code == CodeLinkNotActive:
+		return &AccessError{problem}
+	default:
+		return &Error{problem}
+	}
+}
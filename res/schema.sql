@@ -0,0 +1,139 @@
+-- Schema of the URL shortener database. Statements are
+-- idempotent for fresh installations. Existing installations
+-- should apply the ALTER statements at the bottom of the file.
+--
+-- Expired links are kept in the url table for the number of days
+-- set by expired_retention in res/db_config.json (7 by default),
+-- so they are reported as expired (410) rather than missing.
+-- Until then new links with the same id or url replace them.
+
+CREATE TABLE IF NOT EXISTS url (
+    workspace       VARCHAR(64)   NOT NULL DEFAULT '',
+    id              VARCHAR(64)   NOT NULL,
+    original_url    VARCHAR(2048) NOT NULL,
+    creation_time   BIGINT        NOT NULL,
+    expiration_time BIGINT        NOT NULL,
+    click_count     BIGINT        NOT NULL DEFAULT 0,
+    tags            VARCHAR(1024) NOT NULL DEFAULT '',
+    owner           VARCHAR(255)  NOT NULL DEFAULT '',
+    password_hash   VARCHAR(255)  NOT NULL DEFAULT '',
+    max_clicks      BIGINT        NOT NULL DEFAULT 0,
+    activation_time BIGINT        NOT NULL DEFAULT 0,
+    sticky_variants BOOLEAN       NOT NULL DEFAULT FALSE,
+    forward_query   BOOLEAN       NOT NULL DEFAULT FALSE,
+    query_override  BOOLEAN       NOT NULL DEFAULT FALSE,
+    utm_params      VARCHAR(1024) NOT NULL DEFAULT '',
+    redirect_status INT           NOT NULL DEFAULT 0,
+    preview         BOOLEAN       NOT NULL DEFAULT FALSE,
+    normalized_url  VARCHAR(2048) NOT NULL,
+    check_status    INT           NOT NULL DEFAULT 0,
+    check_latency   BIGINT        NOT NULL DEFAULT 0,
+    checked_at      BIGINT        NOT NULL DEFAULT 0,
+    PRIMARY KEY (workspace, id),
+    UNIQUE KEY url_original_url (workspace, original_url(700)),
+    UNIQUE KEY url_normalized_url (workspace, normalized_url(700))
+);
+
+-- Platform targets of the links. Platforms without target are
+-- redirected to url.original_url
+CREATE TABLE IF NOT EXISTS url_target (
+    workspace  VARCHAR(64)   NOT NULL DEFAULT '',
+    id         VARCHAR(64)   NOT NULL,
+    platform   VARCHAR(16)   NOT NULL,
+    target_url VARCHAR(2048) NOT NULL,
+    PRIMARY KEY (workspace, id, platform)
+);
+
+-- Weighted destinations which the traffic of the links is split
+-- across, in the order of position
+CREATE TABLE IF NOT EXISTS url_variant (
+    workspace   VARCHAR(64)   NOT NULL DEFAULT '',
+    id          VARCHAR(64)   NOT NULL,
+    name        VARCHAR(32)   NOT NULL,
+    position    INT           NOT NULL,
+    target_url  VARCHAR(2048) NOT NULL,
+    weight      INT           NOT NULL,
+    click_count BIGINT        NOT NULL DEFAULT 0,
+    PRIMARY KEY (workspace, id, name)
+);
+
+-- Branded domains serving the links of their own namespace,
+-- stored under the host in the workspace column of the links
+CREATE TABLE IF NOT EXISTS domain (
+    host           VARCHAR(64) NOT NULL,
+    not_found_page TEXT        NOT NULL,
+    creation_time  BIGINT      NOT NULL,
+    PRIMARY KEY (host)
+);
+
+-- Link metadata exposed by /api/v2
+-- ALTER TABLE url
+--     ADD COLUMN click_count BIGINT        NOT NULL DEFAULT 0,
+--     ADD COLUMN tags        VARCHAR(1024) NOT NULL DEFAULT '',
+--     ADD COLUMN owner       VARCHAR(255)  NOT NULL DEFAULT '';
+
+-- Password-protected links
+-- ALTER TABLE url
+--     ADD COLUMN password_hash VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Links with maximum clicks
+-- ALTER TABLE url
+--     ADD COLUMN max_clicks BIGINT NOT NULL DEFAULT 0;
+
+-- Scheduled activation of links
+-- ALTER TABLE url
+--     ADD COLUMN activation_time BIGINT NOT NULL DEFAULT 0;
+
+-- Sticky variants of links
+-- ALTER TABLE url
+--     ADD COLUMN sticky_variants BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Query forwarding and UTM parameters of links
+-- ALTER TABLE url
+--     ADD COLUMN forward_query  BOOLEAN       NOT NULL DEFAULT FALSE,
+--     ADD COLUMN query_override BOOLEAN       NOT NULL DEFAULT FALSE,
+--     ADD COLUMN utm_params     VARCHAR(1024) NOT NULL DEFAULT '';
+
+-- Redirect status of links
+-- ALTER TABLE url
+--     ADD COLUMN redirect_status INT NOT NULL DEFAULT 0;
+
+-- Forced preview page of links
+-- ALTER TABLE url
+--     ADD COLUMN preview BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Normalized urls used for detecting duplicates. The existing
+-- links keep their original url as normalized one
+-- ALTER TABLE url
+--     ADD COLUMN normalized_url VARCHAR(2048) NOT NULL DEFAULT '';
+-- UPDATE url SET normalized_url = original_url;
+-- ALTER TABLE url
+--     ADD UNIQUE KEY url_normalized_url (normalized_url(768));
+
+-- Health checks of the destinations of links
+-- ALTER TABLE url
+--     ADD COLUMN check_status  INT    NOT NULL DEFAULT 0,
+--     ADD COLUMN check_latency BIGINT NOT NULL DEFAULT 0,
+--     ADD COLUMN checked_at    BIGINT NOT NULL DEFAULT 0;
+
+-- Workspaces with separate namespaces of ids and urls. The
+-- existing links belong to the default workspace
+-- ALTER TABLE url
+--     ADD COLUMN workspace VARCHAR(64) NOT NULL DEFAULT '' FIRST,
+--     DROP PRIMARY KEY,
+--     ADD PRIMARY KEY (workspace, id),
+--     DROP KEY url_original_url,
+--     ADD UNIQUE KEY url_original_url (workspace, original_url(700)),
+--     DROP KEY url_normalized_url,
+--     ADD UNIQUE KEY url_normalized_url (workspace, normalized_url(700));
+-- ALTER TABLE url_target
+--     ADD COLUMN workspace VARCHAR(64) NOT NULL DEFAULT '' FIRST,
+--     DROP PRIMARY KEY,
+--     ADD PRIMARY KEY (workspace, id, platform);
+-- ALTER TABLE url_variant
+--     ADD COLUMN workspace VARCHAR(64) NOT NULL DEFAULT '' FIRST,
+--     DROP PRIMARY KEY,
+--     ADD PRIMARY KEY (workspace, id, name);
+
+-- Branded domains are stored in the domain table above, which
+-- existing installations create with its CREATE TABLE statement
@@ -0,0 +1,648 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/georgiv/url-shortener/client"
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/qr"
+	"github.com/georgiv/url-shortener/server/service"
+	"github.com/georgiv/url-shortener/server/workspace"
+)
+
+// stdout is the writer of the link commands output
+var stdout io.Writer = os.Stdout
+
+// LinkCommand represents commands for managing links
+// without accessing the database with SQL
+type LinkCommand struct {
+	Create LinkCreateCommand `command:"create" description:"Create link"`
+	Get    LinkGetCommand    `command:"get" description:"Show link"`
+	Delete LinkDeleteCommand `command:"delete" description:"Delete link"`
+	List   LinkListCommand   `command:"list" description:"List links"`
+	Extend LinkExtendCommand `command:"extend" description:"Extend link expiration"`
+}
+
+// LinkOptions represents the options shared by all link
+// commands. In case server is not specified, the database
+// configured in res/db_config.json is accessed directly,
+// applying the rules of the service options as the server does
+type LinkOptions struct {
+	Server string `long:"server" short:"s" default:"" description:"Base url of running server, e.g. http://localhost:8888"`
+	Token  string `long:"token" default:"" description:"Bearer token sent to the server, also the API key of the workspace"`
+
+	Workspace string `long:"workspace" default:"" description:"Workspace of the links defined in --workspaces, the default one when empty. Used only without --server"`
+
+	ServiceOptions
+
+	Output string `long:"output" short:"o" default:"table" choice:"table" choice:"json" description:"Output format"`
+}
+
+// QROptions represents the options of the link commands
+// writing QR code of the short url to file
+type QROptions struct {
+	QRFile   string `long:"qr" default:"" description:"Write QR code of the short url to the file, SVG for .svg files and PNG otherwise. Requires --server"`
+	QRSize   int    `long:"qr-size" default:"256" description:"Width and height of the QR code in pixels"`
+	QRLevel  string `long:"qr-level" default:"M" choice:"L" choice:"M" choice:"Q" choice:"H" description:"Error correction level of the QR code"`
+	QRMargin int    `long:"qr-margin" default:"4" description:"Width of the quiet zone around the QR code in modules"`
+}
+
+// LinkCreateCommand represents command for creating link
+type LinkCreateCommand struct {
+	LinkOptions
+	QROptions
+
+	ID         string   `long:"id" default:"" description:"Alias of the link, generated when empty"`
+	URL        string   `long:"url" required:"true" description:"Url to shorten"`
+	Tags       []string `long:"tag" description:"Tag of the link, can be repeated"`
+	Owner      string   `long:"owner" default:"" description:"Owner of the link"`
+	Password   string   `long:"password" default:"" description:"Password required for resolving the link"`
+	MaxClicks  int64    `long:"max-clicks" default:"0" description:"Number of redirects after which the link is exhausted, unlimited when 0"`
+	NotBefore  string   `long:"not-before" default:"" description:"RFC 3339 time when the link gets active"`
+	ExpiresAt  string   `long:"expires-at" default:"" description:"RFC 3339 time when the link expires, overrides --expiration"`
+	Expiration int      `long:"expiration" short:"e" default:"7" description:"Expiration time in days, used only without --server"`
+
+	Targets map[string]string `long:"target" key-value-delimiter:"=" description:"Url for platform (ios, android or desktop) as platform=url, can be repeated"`
+
+	Variants       map[string]string `long:"variant" key-value-delimiter:"=" description:"Weighted destination as name=url, can be repeated"`
+	Weights        map[string]int    `long:"weight" key-value-delimiter:"=" description:"Weight of variant as name=weight, 1 when not set"`
+	StickyVariants bool              `long:"sticky-variants" description:"Keep visitors on the variant they were first redirected to"`
+
+	ForwardQuery  bool              `long:"forward-query" description:"Merge the query of the short link into the url"`
+	QueryOverride bool              `long:"query-override" description:"Let the forwarded query override the parameters of the url"`
+	UTM           map[string]string `long:"utm" key-value-delimiter:"=" description:"Default UTM parameter as utm_source=value, can be repeated"`
+
+	RedirectStatus int  `long:"redirect-status" default:"0" choice:"0" choice:"301" choice:"302" choice:"307" choice:"308" description:"Status code of the redirect, the server default when 0"`
+	Preview        bool `long:"preview" description:"Always show the preview page with the destination before redirecting"`
+}
+
+// LinkGetCommand represents command for showing link
+type LinkGetCommand struct {
+	LinkOptions
+	QROptions
+}
+
+// LinkDeleteCommand represents command for deleting link
+type LinkDeleteCommand struct {
+	LinkOptions
+}
+
+// LinkListCommand represents command for listing links
+type LinkListCommand struct {
+	LinkOptions
+
+	Owner  string `long:"owner" default:"" description:"Show only links of the owner"`
+	Tag    string `long:"tag" default:"" description:"Show only links with the tag"`
+	Offset int    `long:"offset" default:"0" description:"Number of links to skip"`
+	Limit  int    `long:"limit" default:"50" description:"Maximum number of links to show"`
+}
+
+// LinkExtendCommand represents command for extending link
+// expiration
+type LinkExtendCommand struct {
+	LinkOptions
+
+	Days int `long:"days" short:"d" default:"7" description:"Days to add to the current expiration time"`
+}
+
+// Execute represents an action after calling the
+// link create command
+func (cmd *LinkCreateCommand) Execute(args []string) error {
+	req := service.Request{
+		ID:        cmd.ID,
+		URL:       cmd.URL,
+		Tags:      cmd.Tags,
+		Owner:     cmd.Owner,
+		Password:  cmd.Password,
+		MaxClicks: cmd.MaxClicks,
+		Targets:   cmd.Targets,
+
+		StickyVariants: cmd.StickyVariants,
+
+		ForwardQuery:  cmd.ForwardQuery,
+		QueryOverride: cmd.QueryOverride,
+		UTM:           cmd.UTM,
+
+		RedirectStatus: cmd.RedirectStatus,
+		Preview:        cmd.Preview,
+	}
+
+	var err error
+
+	req.Variants, err = variants(cmd.Variants, cmd.Weights)
+	if err != nil {
+		return err
+	}
+
+	req.NotBefore, err = parseTime("not-before", cmd.NotBefore)
+	if err != nil {
+		return err
+	}
+
+	req.ExpiresAt, err = parseTime("expires-at", cmd.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	backend, err := cmd.open(cmd.Expiration)
+	if err != nil {
+		return err
+	}
+	defer backend.close()
+
+	link, err := backend.create(req)
+	if err != nil {
+		return fmt.Errorf("Error while creating link: %v", err)
+	}
+
+	err = cmd.print(link)
+	if err != nil {
+		return err
+	}
+
+	return cmd.writeQR(link)
+}
+
+// Execute represents an action after calling the
+// link get command
+func (cmd *LinkGetCommand) Execute(args []string) error {
+	id, err := singleID(args)
+	if err != nil {
+		return err
+	}
+
+	backend, err := cmd.open(0)
+	if err != nil {
+		return err
+	}
+	defer backend.close()
+
+	link, err := backend.get(id)
+	if err != nil {
+		return fmt.Errorf("Error while retrieving link %v: %v", id, err)
+	}
+
+	err = cmd.print(link)
+	if err != nil {
+		return err
+	}
+
+	return cmd.writeQR(link)
+}
+
+// Execute represents an action after calling the
+// link delete command
+func (cmd *LinkDeleteCommand) Execute(args []string) error {
+	id, err := singleID(args)
+	if err != nil {
+		return err
+	}
+
+	backend, err := cmd.open(0)
+	if err != nil {
+		return err
+	}
+	defer backend.close()
+
+	err = backend.delete(id)
+	if err != nil {
+		return fmt.Errorf("Error while deleting link %v: %v", id, err)
+	}
+
+	fmt.Fprintf(stdout, "Link %v deleted\n", id)
+
+	return nil
+}
+
+// Execute represents an action after calling the
+// link list command
+func (cmd *LinkListCommand) Execute(args []string) error {
+	backend, err := cmd.open(0)
+	if err != nil {
+		return err
+	}
+	defer backend.close()
+
+	links, err := backend.list(db.ListFilter{
+		Owner:  cmd.Owner,
+		Tag:    cmd.Tag,
+		Offset: cmd.Offset,
+		Limit:  cmd.Limit,
+	})
+	if err != nil {
+		return fmt.Errorf("Error while listing links: %v", err)
+	}
+
+	return cmd.print(links...)
+}
+
+// Execute represents an action after calling the
+// link extend command
+func (cmd *LinkExtendCommand) Execute(args []string) error {
+	id, err := singleID(args)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Days <= 0 {
+		return fmt.Errorf("Days should be positive integer, received %v", cmd.Days)
+	}
+
+	backend, err := cmd.open(0)
+	if err != nil {
+		return err
+	}
+	defer backend.close()
+
+	link, err := backend.get(id)
+	if err != nil {
+		return fmt.Errorf("Error while retrieving link %v: %v", id, err)
+	}
+
+	link, err = backend.extend(id, link.ExpiresAt.Add(time.Duration(cmd.Days)*24*time.Hour))
+	if err != nil {
+		return fmt.Errorf("Error while extending link %v: %v", id, err)
+	}
+
+	return cmd.print(link)
+}
+
+func (options *LinkOptions) open(expiration int) (backend linkBackend, err error) {
+	if options.Server != "" {
+		backend = &httpBackend{
+			client: client.New(options.Server, client.WithToken(options.Token)),
+		}
+		return
+	}
+
+	if expiration <= 0 {
+		expiration = 7
+	}
+
+	dbWorker, err := newWorker(expiration)
+	if err != nil {
+		err = fmt.Errorf("Error while connecting to the database: %v", err)
+		return
+	}
+
+	linkService, _, workspaces, err := options.newService(dbWorker)
+	if err != nil {
+		dbWorker.Shutdown()
+		return
+	}
+
+	ws := &workspace.Workspace{}
+	if options.Workspace != "" {
+		ws = findWorkspace(workspaces, options.Workspace)
+		if ws == nil {
+			dbWorker.Shutdown()
+			err = fmt.Errorf("Workspace %v is not defined in --workspaces", options.Workspace)
+			return
+		}
+	}
+
+	backend = &dbBackend{
+		dbWorker:    dbWorker,
+		linkService: linkService.Workspace(ws),
+	}
+
+	return
+}
+
+// findWorkspace returns the workspace with the id, nil in case
+// there is no such
+func findWorkspace(workspaces *workspace.Registry, id string) *workspace.Workspace {
+	for _, ws := range workspaces.Workspaces() {
+		if ws.ID == id {
+			return ws
+		}
+	}
+
+	return nil
+}
+
+func (options *LinkOptions) print(links ...*client.Link) error {
+	if options.Output == "json" {
+		var v interface{} = links
+		if len(links) == 1 {
+			v = links[0]
+		}
+
+		out, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(stdout, string(out))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tURL\tCREATED\tEXPIRES\tCLICKS\tTAGS\tOWNER")
+	for _, link := range links {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			link.ID,
+			link.URL,
+			link.CreatedAt.Local().Format(time.RFC3339),
+			link.ExpiresAt.Local().Format(time.RFC3339),
+			link.ClickCount,
+			strings.Join(link.Tags, ","),
+			link.Owner)
+	}
+
+	return w.Flush()
+}
+
+// writeQR writes QR code of the short url of the link to the
+// file given by --qr, in case it is set. The short url is known
+// only to the server, so links accessed directly in the database
+// have no QR code
+func (options *QROptions) writeQR(link *client.Link) error {
+	if options.QRFile == "" {
+		return nil
+	}
+
+	if link.ShortURL == "" {
+		return errors.New("Error while writing QR code: the short url is known only with --server")
+	}
+
+	image, err := qr.Encode(link.ShortURL, qr.Options{
+		Format: qr.FormatOf(options.QRFile),
+		Size:   options.QRSize,
+		Level:  qr.Level(options.QRLevel),
+		Margin: options.QRMargin,
+	})
+	if err != nil {
+		return fmt.Errorf("Error while writing QR code: %v", err)
+	}
+
+	err = ioutil.WriteFile(options.QRFile, image, 0644)
+	if err != nil {
+		return fmt.Errorf("Error while writing QR code: %v", err)
+	}
+
+	return nil
+}
+
+// parseTime parses the RFC 3339 value of the flag. Empty value
+// results in zero time
+func parseTime(flag string, value string) (t time.Time, err error) {
+	if value == "" {
+		return
+	}
+
+	t, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		err = fmt.Errorf("Invalid --%v: %v. It should be RFC 3339 time, e.g. 2019-08-01T10:00:00Z", flag, value)
+	}
+
+	return
+}
+
+// variants builds the variants from the --variant and --weight
+// flags ordered by name, as the order of the flags is not kept
+func variants(urls map[string]string, weights map[string]int) (variants []db.Variant, err error) {
+	for name := range weights {
+		if _, ok := urls[name]; !ok {
+			err = fmt.Errorf("Invalid --weight: %v. There is no such --variant", name)
+			return
+		}
+	}
+
+	names := make([]string, 0, len(urls))
+	for name := range urls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		variants = append(variants, db.Variant{Name: name, URL: urls[name], Weight: weights[name]})
+	}
+
+	return
+}
+
+func singleID(args []string) (id string, err error) {
+	if len(args) != 1 {
+		err = errors.New("Exactly one link id should be specified")
+		return
+	}
+
+	id = args[0]
+
+	return
+}
+
+// linkBackend abstracts the access to the links, either
+// directly through db.Worker or through running server
+type linkBackend interface {
+	create(req service.Request) (*client.Link, error)
+	get(id string) (*client.Link, error)
+	delete(id string) error
+	list(filter db.ListFilter) ([]*client.Link, error)
+	extend(id string, expiresAt time.Time) (*client.Link, error)
+	close()
+}
+
+type dbBackend struct {
+	dbWorker    db.Worker
+	linkService *service.Service
+}
+
+func (backend *dbBackend) create(req service.Request) (*client.Link, error) {
+	link, err := backend.linkService.Create(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return toView(link), nil
+}
+
+func (backend *dbBackend) get(id string) (*client.Link, error) {
+	link, err := backend.linkService.DB().Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if link == nil {
+		return nil, fmt.Errorf("ID %v does not exist", id)
+	}
+
+	return toView(link), nil
+}
+
+func (backend *dbBackend) delete(id string) error {
+	deleted, err := backend.linkService.DB().Delete(id)
+	if err != nil {
+		return err
+	}
+
+	if !deleted {
+		return fmt.Errorf("ID %v does not exist", id)
+	}
+
+	return nil
+}
+
+func (backend *dbBackend) list(filter db.ListFilter) ([]*client.Link, error) {
+	links, err := backend.linkService.List(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]*client.Link, 0, len(links))
+	for _, link := range links {
+		views = append(views, toView(link))
+	}
+
+	return views, nil
+}
+
+func (backend *dbBackend) extend(id string, expiresAt time.Time) (*client.Link, error) {
+	link, err := backend.linkService.Extend(id, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if link == nil {
+		return nil, fmt.Errorf("ID %v does not exist", id)
+	}
+
+	return toView(link), nil
+}
+
+func (backend *dbBackend) close() {
+	backend.dbWorker.Shutdown()
+}
+
+type httpBackend struct {
+	client *client.Client
+}
+
+func (backend *httpBackend) create(req service.Request) (*client.Link, error) {
+	create := client.CreateRequest{
+		ID:        req.ID,
+		URL:       req.URL,
+		Tags:      req.Tags,
+		Owner:     req.Owner,
+		Password:  req.Password,
+		MaxClicks: req.MaxClicks,
+		Targets:   req.Targets,
+
+		StickyVariants: req.StickyVariants,
+
+		ForwardQuery:  req.ForwardQuery,
+		QueryOverride: req.QueryOverride,
+		UTM:           req.UTM,
+
+		RedirectStatus: req.RedirectStatus,
+		Preview:        req.Preview,
+	}
+	for _, v := range req.Variants {
+		create.Variants = append(create.Variants, client.Variant{Name: v.Name, URL: v.URL, Weight: v.Weight})
+	}
+	if !req.NotBefore.IsZero() {
+		create.NotBefore = &req.NotBefore
+	}
+	if !req.ExpiresAt.IsZero() {
+		create.ExpiresAt = &req.ExpiresAt
+	}
+
+	return backend.client.Create(context.Background(), create)
+}
+
+func (backend *httpBackend) get(id string) (*client.Link, error) {
+	return backend.client.Get(context.Background(), id)
+}
+
+func (backend *httpBackend) delete(id string) error {
+	return backend.client.Delete(context.Background(), id)
+}
+
+func (backend *httpBackend) list(filter db.ListFilter) ([]*client.Link, error) {
+	links, err := backend.client.List(context.Background(), client.ListOptions{
+		Owner:  filter.Owner,
+		Tag:    filter.Tag,
+		Offset: filter.Offset,
+		Limit:  filter.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]*client.Link, 0, len(links))
+	for i := range links {
+		views = append(views, &links[i])
+	}
+
+	return views, nil
+}
+
+func (backend *httpBackend) extend(id string, expiresAt time.Time) (*client.Link, error) {
+	return backend.client.Extend(context.Background(), id, expiresAt)
+}
+
+func (backend *httpBackend) close() {
+}
+
+func toView(link *db.Link) *client.Link {
+	view := &client.Link{
+		ID:         link.ID,
+		URL:        link.URL,
+		CreatedAt:  link.CreatedAt.UTC(),
+		ExpiresAt:  link.ExpiresAt.UTC(),
+		ClickCount: link.ClickCount,
+		Tags:       link.Tags,
+		Owner:      link.Owner,
+		Protected:  link.PasswordHash != "",
+		MaxClicks:  link.MaxClicks,
+		Targets:    link.Targets,
+
+		StickyVariants: link.StickyVariants,
+
+		ForwardQuery:  link.ForwardQuery,
+		QueryOverride: link.QueryOverride,
+		UTM:           link.UTM,
+
+		RedirectStatus: link.RedirectStatus,
+		Preview:        link.Preview,
+	}
+
+	for _, v := range link.Variants {
+		view.Variants = append(view.Variants, client.Variant{Name: v.Name, URL: v.URL, Weight: v.Weight, ClickCount: v.ClickCount})
+	}
+
+	if link.MaxClicks > 0 {
+		remaining := link.MaxClicks - link.ClickCount
+		if remaining < 0 {
+			remaining = 0
+		}
+		view.RemainingClicks = &remaining
+	}
+
+	if !link.NotBefore.IsZero() {
+		notBefore := link.NotBefore.UTC()
+		view.NotBefore = &notBefore
+	}
+
+	if !link.LastCheck.CheckedAt.IsZero() {
+		view.Check = &client.Check{
+			Status:    link.LastCheck.Status,
+			Healthy:   link.LastCheck.Healthy(),
+			LatencyMS: link.LastCheck.Latency.Milliseconds(),
+			CheckedAt: link.LastCheck.CheckedAt.UTC(),
+		}
+	}
+
+	return view
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/georgiv/url-shortener/client"
+	"github.com/georgiv/url-shortener/server/qr"
+	"github.com/georgiv/url-shortener/server/web"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+func capture(t *testing.T) *bytes.Buffer {
+	var out bytes.Buffer
+	stdout = &out
+	t.Cleanup(func() {
+		stdout = os.Stdout
+	})
+
+	return &out
+}
+
+func TestLinkCommands(t *testing.T) {
+	server := httptest.NewServer(web.NewHandler(testdata.NewMemoryWorker(), web.Options{}, nil))
+	defer server.Close()
+
+	options := LinkOptions{Server: server.URL, Output: "json"}
+
+	out := capture(t)
+	create := &LinkCreateCommand{LinkOptions: options, ID: "cranki", URL: "http://testurl.com", Tags: []string{"docs"},
+		Targets: map[string]string{"ios": "https://apps.apple.com/app/id1"}}
+	err := create.Execute(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var link client.Link
+	err = json.Unmarshal(out.Bytes(), &link)
+	if err != nil {
+		t.Fatalf("Invalid JSON output %q: %v", out.String(), err)
+	}
+
+	if link.ID != "cranki" || link.URL != "http://testurl.com" || link.Targets["ios"] != "https://apps.apple.com/app/id1" {
+		t.Errorf("Unexpected link: %+v", link)
+	}
+
+	out.Reset()
+	extend := &LinkExtendCommand{LinkOptions: options, Days: 3}
+	err = extend.Execute([]string{"cranki"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var extended client.Link
+	json.Unmarshal(out.Bytes(), &extended)
+	if got := extended.ExpiresAt.Sub(link.ExpiresAt).Hours(); got != 72 {
+		t.Errorf("Expected expiration extended by 72h, received: %vh", got)
+	}
+
+	out.Reset()
+	list := &LinkListCommand{LinkOptions: LinkOptions{Server: server.URL, Output: "table"}, Limit: 50}
+	err = list.Execute(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID") || !strings.HasPrefix(lines[1], "cranki") {
+		t.Errorf("Unexpected table output: %q", out.String())
+	}
+
+	out.Reset()
+	file := filepath.Join(t.TempDir(), "cranki.svg")
+	get := &LinkGetCommand{LinkOptions: options, QROptions: QROptions{QRFile: file, QRSize: 300, QRLevel: "H", QRMargin: 4}}
+	err = get.Execute([]string{"cranki"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected, _ := qr.Encode(link.ShortURL, qr.Options{Format: qr.FormatSVG, Size: 300, Level: qr.LevelHigh, Margin: 4})
+	written, err := ioutil.ReadFile(file)
+	if err != nil || !bytes.Equal(written, expected) {
+		t.Errorf("Expected SVG QR code of %v, received %q, %v", link.ShortURL, written, err)
+	}
+
+	out.Reset()
+	del := &LinkDeleteCommand{LinkOptions: options}
+	err = del.Execute([]string{"cranki"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	get = &LinkGetCommand{LinkOptions: options}
+	err = get.Execute([]string{"cranki"})
+	if err == nil {
+		t.Errorf("Expected error for deleted link")
+	}
+}
+
+func TestLinkCreateInvalid(t *testing.T) {
+	server := httptest.NewServer(web.NewHandler(testdata.NewMemoryWorker(), web.Options{}, nil))
+	defer server.Close()
+
+	capture(t)
+	create := &LinkCreateCommand{LinkOptions: LinkOptions{Server: server.URL}, URL: "testurl"}
+	err := create.Execute(nil)
+	if err == nil || !strings.Contains(err.Error(), "Invalid url") {
+		t.Errorf("Expected invalid url error, received: %v", err)
+	}
+
+	create = &LinkCreateCommand{LinkOptions: LinkOptions{Server: server.URL}, URL: "http://testurl.com",
+		Variants: map[string]string{"a": "http://testurl.com/a"}, Weights: map[string]int{"b": 2}}
+	err = create.Execute(nil)
+	if err == nil || !strings.Contains(err.Error(), "--weight") {
+		t.Errorf("Expected invalid weight error, received: %v", err)
+	}
+}
+
+func TestLinkCreateDatabase(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	useWorker(t, openWorker{dbWorker})
+
+	file := filepath.Join(t.TempDir(), "workspaces.json")
+	err := ioutil.WriteFile(file, []byte(`[{"id": "team", "hosts": ["go.team.com"], "max_links": 1}]`), 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	options := LinkOptions{
+		Output: "json",
+		ServiceOptions: ServiceOptions{
+			PublicURL:     "https://s.example.com",
+			DeniedDomains: []string{"evil.com"},
+			Workspaces:    file,
+		},
+	}
+
+	capture(t)
+	for url, expected := range map[string]string{
+		"http://evil.com/":            "evil.com",
+		"https://s.example.com/abcde": "service itself",
+	} {
+		create := &LinkCreateCommand{LinkOptions: options, URL: url}
+		err = create.Execute(nil)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error for %v containing %q, received: %v", url, expected, err)
+		}
+	}
+
+	unknown := options
+	unknown.Workspace = "other"
+	create := &LinkCreateCommand{LinkOptions: unknown, URL: "http://testurl.com"}
+	err = create.Execute(nil)
+	if err == nil || !strings.Contains(err.Error(), "--workspaces") {
+		t.Errorf("Expected unknown workspace error, received: %v", err)
+	}
+
+	team := options
+	team.Workspace = "team"
+	create = &LinkCreateCommand{LinkOptions: team, URL: "http://testurl.com"}
+	err = create.Execute(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	create = &LinkCreateCommand{LinkOptions: team, URL: "http://testurl.com/other"}
+	err = create.Execute(nil)
+	if err == nil || !strings.Contains(err.Error(), "maximum of 1 links") {
+		t.Errorf("Expected quota error, received: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/health"
+	"github.com/georgiv/url-shortener/server/policy"
+)
+
+// newWorker opens the database accessed by the link check
+// command and the link commands without server
+var newWorker = db.NewWorker
+
+// LinkCheckCommand represents command for checking the
+// destinations of the links in the database and recording
+// their status
+type LinkCheckCommand struct {
+	Concurrency  int           `long:"concurrency" default:"4" description:"Number of destinations checked at the same time"`
+	HostDelay    time.Duration `long:"host-delay" default:"1s" description:"Minimum interval between requests to the same host"`
+	Timeout      time.Duration `long:"timeout" default:"10s" description:"Timeout of single request to a destination"`
+	Stale        time.Duration `long:"stale" default:"0s" description:"Check only links not checked within the duration, all links when 0"`
+	AllowPrivate bool          `long:"allow-private" description:"Check destinations on private, loopback and link-local hosts"`
+	Workspace    string        `long:"workspace" default:"" description:"Workspace of the checked links, the default one when empty"`
+	Output       string        `long:"output" short:"o" default:"table" choice:"table" choice:"json" description:"Output format"`
+}
+
+// checkView is the representation of a check in the output of
+// the link check command
+type checkView struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Status    int       `json:"status"`
+	Healthy   bool      `json:"healthy"`
+	LatencyMS int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Execute represents an action after calling the
+// linkcheck command. In case ids are provided, only their
+// links are checked
+func (cmd *LinkCheckCommand) Execute(args []string) error {
+	urlPolicy, err := policy.New(policy.Options{AllowPrivate: cmd.AllowPrivate})
+	if err != nil {
+		return err
+	}
+
+	dbWorker, err := newWorker(7)
+	if err != nil {
+		return fmt.Errorf("Error while connecting to the database: %v", err)
+	}
+	defer dbWorker.Shutdown()
+
+	checked := dbWorker
+	if cmd.Workspace != "" {
+		checked = dbWorker.Workspace(cmd.Workspace, 0)
+	}
+
+	links, err := checkedLinks(checked, args)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Stale > 0 {
+		links = health.Stale(links, time.Now().Add(-cmd.Stale))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	checker := health.New(health.Options{
+		Concurrency: cmd.Concurrency,
+		HostDelay:   cmd.HostDelay,
+		Timeout:     cmd.Timeout,
+		Policy:      urlPolicy,
+	})
+
+	var views []checkView
+	err = checker.CheckLinks(ctx, checked, links, func(result health.Result) {
+		view := checkView{
+			ID:        result.ID,
+			URL:       result.URL,
+			Status:    result.Status,
+			Healthy:   result.Healthy(),
+			LatencyMS: result.Latency.Milliseconds(),
+			CheckedAt: result.CheckedAt.UTC(),
+		}
+		if result.Err != nil {
+			view.Error = result.Err.Error()
+		}
+
+		views = append(views, view)
+	})
+	if err != nil {
+		return fmt.Errorf("Error while checking links: %v", err)
+	}
+
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].ID < views[j].ID
+	})
+
+	return cmd.print(views)
+}
+
+// checkedLinks returns the links with the ids, or all links
+// which have not expired in case no ids are provided
+func checkedLinks(dbWorker db.Worker, ids []string) ([]*db.Link, error) {
+	if len(ids) == 0 {
+		links, err := dbWorker.List(db.ListFilter{})
+		if err != nil {
+			return nil, fmt.Errorf("Error while listing links: %v", err)
+		}
+
+		return links, nil
+	}
+
+	links := make([]*db.Link, 0, len(ids))
+	for _, id := range ids {
+		link, err := dbWorker.Get(id)
+		if err != nil {
+			return nil, fmt.Errorf("Error while retrieving link %v: %v", id, err)
+		}
+
+		if link == nil {
+			return nil, fmt.Errorf("ID %v does not exist", id)
+		}
+
+		links = append(links, link)
+	}
+
+	return links, nil
+}
+
+func (cmd *LinkCheckCommand) print(views []checkView) error {
+	if cmd.Output == "json" {
+		if views == nil {
+			views = []checkView{}
+		}
+
+		out, err := json.MarshalIndent(views, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(stdout, string(out))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tURL\tSTATUS\tLATENCY\tERROR")
+	for _, view := range views {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%vms\t%v\n",
+			view.ID,
+			view.URL,
+			view.Status,
+			view.LatencyMS,
+			view.Error)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+// openWorker keeps the memory worker open after the command, so
+// the recorded checks can be inspected
+type openWorker struct {
+	*testdata.MemoryWorker
+}
+
+func (openWorker) Shutdown() {}
+
+func useWorker(t *testing.T, dbWorker db.Worker) {
+	newWorker = func(int) (db.Worker, error) {
+		return dbWorker, nil
+	}
+	t.Cleanup(func() {
+		newWorker = db.NewWorker
+	})
+}
+
+func TestLinkCheckCommand(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", server.URL+"/ok")
+	dbWorker.Register("missng", server.URL+"/missing")
+	useWorker(t, openWorker{dbWorker})
+
+	out := capture(t)
+	check := &LinkCheckCommand{AllowPrivate: true, HostDelay: time.Millisecond, Output: "json"}
+	err := check.Execute(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var views []checkView
+	err = json.Unmarshal(out.Bytes(), &views)
+	if err != nil {
+		t.Fatalf("Invalid JSON output %q: %v", out.String(), err)
+	}
+
+	if len(views) != 2 || views[0].ID != "cranki" || !views[0].Healthy || views[1].Status != 404 || views[1].Healthy {
+		t.Errorf("Unexpected checks: %+v", views)
+	}
+
+	link, _ := dbWorker.Get("missng")
+	if link.LastCheck.Status != 404 {
+		t.Errorf("Expected recorded check, received %+v", link.LastCheck)
+	}
+
+	// recently checked links are skipped
+	out.Reset()
+	check = &LinkCheckCommand{AllowPrivate: true, HostDelay: time.Millisecond, Stale: time.Hour, Output: "table"}
+	err = check.Execute(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if strings.Contains(out.String(), "cranki") {
+		t.Errorf("Expected no checks, received %v", out.String())
+	}
+
+	// private destinations are rejected by default
+	out.Reset()
+	check = &LinkCheckCommand{HostDelay: time.Millisecond, Output: "table"}
+	err = check.Execute([]string{"cranki"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "private") {
+		t.Errorf("Expected rejected destination, received %v", out.String())
+	}
+
+	err = check.Execute([]string{"unknwn"})
+	if err == nil {
+		t.Errorf("Expected error for missing link")
+	}
+}
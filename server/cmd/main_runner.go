@@ -2,5 +2,8 @@ package cmd
 
 // MainCommand represents all supported commands
 type MainCommand struct {
-	Start StartCommand `command:"start" description:"Start server on predefined host and port"`
+	Start     StartCommand     `command:"start" description:"Start server on predefined host and port"`
+	Link      LinkCommand      `command:"link" description:"Manage links directly in the database or through running server"`
+	LinkCheck LinkCheckCommand `command:"linkcheck" description:"Check destinations of links in the database and record their status"`
+	Workspace WorkspaceCommand `command:"workspace" description:"Manage the API keys of workspaces"`
 }
@@ -1,11 +1,125 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/health"
+	"github.com/georgiv/url-shortener/server/policy"
+	"github.com/georgiv/url-shortener/server/rpc"
+	"github.com/georgiv/url-shortener/server/service"
 	"github.com/georgiv/url-shortener/server/web"
+	"github.com/georgiv/url-shortener/server/workspace"
 )
 
+// ServiceOptions represents the options of the rules applied to
+// the links. They are shared by the start command and the link
+// commands accessing the database directly, so links created
+// either way pass the same validation
+type ServiceOptions struct {
+	MaxURLLength int    `long:"max-url-length" default:"2048" description:"Maximum length of the registered url"`
+	PublicURL    string `long:"public-url" default:"" description:"Scheme and host of the absolute short urls, derived from the request when empty"`
+
+	AllowedSchemes  []string      `long:"allowed-scheme" default:"http" default:"https" description:"Scheme of the urls which may be shortened, can be repeated"`
+	AllowedDomains  []string      `long:"allowed-domain" description:"Domain pattern of the urls which may be shortened, e.g. *.example.com, can be repeated. All domains are allowed when not set"`
+	DeniedDomains   []string      `long:"denied-domain" description:"Domain pattern of the urls which may not be shortened, can be repeated"`
+	AllowPrivate    bool          `long:"allow-private" description:"Allow urls of private, loopback and link-local hosts"`
+	BlocklistFile   string        `long:"blocklist-file" default:"" description:"File with denied domain patterns, one per line, reloaded when it changes"`
+	BlocklistReload time.Duration `long:"blocklist-reload" default:"10s" description:"Interval of checking the blocklist file for changes"`
+
+	OwnHosts      []string `long:"own-host" description:"Host serving the short links besides the one of --public-url, can be repeated"`
+	SelfLinks     string   `long:"self-links" default:"reject" choice:"reject" choice:"resolve" description:"Whether urls of own short links are rejected or resolved to their final destination"`
+	MaxChainDepth int      `long:"max-chain-depth" default:"5" description:"Maximum number of own short links followed while resolving"`
+
+	SortQuery bool `long:"sort-query" description:"Sort query parameters of the normalized urls, so urls differing only in their order are duplicates"`
+
+	Workspaces string        `long:"workspaces" default:"" description:"JSON file with the workspaces sharing the server, all links belong to the default workspace when empty"`
+	DomainTTL  time.Duration `long:"domain-ttl" default:"30s" description:"Time for which the branded domains are cached"`
+}
+
+// newService creates the link service applying the rules of the
+// options on top of the DB worker, along with the url policy and
+// the workspaces it was created with
+func (options *ServiceOptions) newService(dbWorker db.Worker) (linkService *service.Service, urlPolicy *policy.Policy, workspaces *workspace.Registry, err error) {
+	urlPolicy, err = policy.New(policy.Options{
+		Schemes:        options.AllowedSchemes,
+		AllowedDomains: options.AllowedDomains,
+		DeniedDomains:  options.DeniedDomains,
+		AllowPrivate:   options.AllowPrivate,
+		BlocklistFile:  options.BlocklistFile,
+		ReloadInterval: options.BlocklistReload,
+	})
+	if err != nil {
+		return
+	}
+
+	if options.Workspaces != "" {
+		workspaces, err = workspace.Load(options.Workspaces)
+		if err != nil {
+			return
+		}
+	}
+
+	ownHosts := options.OwnHosts
+	if options.PublicURL != "" {
+		ownHosts = append(ownHosts, options.PublicURL)
+	}
+
+	linkService = service.New(dbWorker, service.Options{
+		MaxURLLength:  options.MaxURLLength,
+		Policy:        urlPolicy,
+		OwnHosts:      ownHosts,
+		SelfLinks:     options.SelfLinks,
+		MaxChainDepth: options.MaxChainDepth,
+		SortQuery:     options.SortQuery,
+		Workspaces:    workspaces,
+		DomainTTL:     options.DomainTTL,
+	})
+
+	return
+}
+
+// explicitOrigins reports whether the origins list at least one
+// origin besides any origin (*)
+func explicitOrigins(origins []string) bool {
+	for _, origin := range origins {
+		if origin != "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkedWorkers returns function listing the DB workers of all
+// link namespaces checked in the background: the default one,
+// the ones of the workspaces and the ones of the branded domains.
+// The domains are listed on every call, as they change at runtime
+func checkedWorkers(dbWorker db.Worker, workspaces *workspace.Registry, linkService *service.Service) func() ([]db.Worker, error) {
+	return func() ([]db.Worker, error) {
+		domains, err := linkService.Domains()
+		if err != nil {
+			return nil, err
+		}
+
+		dbWorkers := []db.Worker{dbWorker}
+		for _, ws := range workspaces.Workspaces() {
+			dbWorkers = append(dbWorkers, dbWorker.Workspace(ws.ID, ws.Expiration))
+		}
+		for _, domain := range domains {
+			dbWorkers = append(dbWorkers, dbWorker.Workspace(domain.Host, 0))
+		}
+
+		return dbWorkers, nil
+	}
+}
+
 // StartCommand represents command for starting
 // the URL shortener service along with all supported
 // options
@@ -13,17 +127,163 @@ type StartCommand struct {
 	Host       string `long:"bindhost" short:"b" default:"" description:"Host where to bind the server"`
 	Port       int    `long:"port" short:"p" default:"8888" description:"Listening port of the server"`
 	Expiration int    `long:"expiration" short:"e" default:"7" description:"Expiration time for short urls in days"`
+	GRPCPort   int    `long:"grpc-port" default:"0" description:"Listening port of the gRPC server, disabled when 0"`
+
+	TLSCert      string `long:"tls-cert" default:"" description:"Path to PEM encoded TLS certificate, reloaded on SIGHUP"`
+	TLSKey       string `long:"tls-key" default:"" description:"Path to PEM encoded TLS private key, reloaded on SIGHUP"`
+	RedirectPort int    `long:"redirect-port" default:"0" description:"Port of plain HTTP listener redirecting to HTTPS, disabled when 0"`
+	HSTSMaxAge   int    `long:"hsts-max-age" default:"31536000" description:"Max age in seconds of the HSTS header sent over TLS, disabled when 0"`
+
+	ReadTimeout       time.Duration `long:"read-timeout" default:"10s" description:"Maximum duration for reading the entire request"`
+	ReadHeaderTimeout time.Duration `long:"read-header-timeout" default:"5s" description:"Maximum duration for reading the request headers"`
+	WriteTimeout      time.Duration `long:"write-timeout" default:"10s" description:"Maximum duration before timing out writes of the response"`
+	IdleTimeout       time.Duration `long:"idle-timeout" default:"60s" description:"Maximum duration to wait for the next request on keep-alive connections"`
+	MaxBodySize       int64         `long:"max-body-size" default:"65536" description:"Maximum size in bytes of the request body"`
+
+	CORSOrigins     []string `long:"cors-origin" default:"*" description:"Origin allowed to access the API, can be repeated. Any origin (*) cannot send credentials"`
+	CORSMethods     []string `long:"cors-method" description:"Method announced in preflight responses, can be repeated. Defaults to the methods of the matching route"`
+	CORSHeaders     []string `long:"cors-header" default:"Accept" default:"Content-Type" default:"Authorization" default:"X-Link-Password" description:"Request header allowed in cross-origin requests, can be repeated"`
+	CORSCredentials bool     `long:"cors-credentials" description:"Allow credentials in cross-origin requests"`
+	CORSMaxAge      int      `long:"cors-max-age" default:"600" description:"Max age in seconds of cached preflight responses, disabled when 0"`
+
+	ServiceOptions
+
+	SwaggerUI string `long:"swagger-ui" default:"" description:"Directory with the assets of the swagger-ui-dist package, Swagger UI page is served at /api/docs when set"`
+
+	NotActiveStatus int    `long:"not-active-status" default:"403" description:"Status code sent for links which have not been activated yet"`
+	NotActiveURL    string `long:"not-active-url" default:"" description:"Page to which the links which have not been activated yet redirect, the status code is sent when empty"`
+
+	RedirectStatus int           `long:"redirect-status" default:"308" choice:"301" choice:"302" choice:"307" choice:"308" description:"Status code of the redirects of links without own status"`
+	RedirectMaxAge time.Duration `long:"redirect-max-age" default:"24h" description:"Maximum age of cached permanent redirects"`
+
+	TrustedDomains []string `long:"trusted-domain" description:"Domain to which browsers are redirected without the preview page, can be repeated. All domains are trusted when not set"`
+	PreviewKey     string   `long:"preview-key" default:"" description:"Key signing the continue button of the preview page, shared by the instances serving the same links. Random key is generated when not set"`
+
+	CheckInterval    time.Duration `long:"check-interval" default:"0s" description:"Interval of checking the destinations of links in the background, disabled when 0"`
+	CheckConcurrency int           `long:"check-concurrency" default:"4" description:"Number of destinations checked at the same time"`
+	CheckHostDelay   time.Duration `long:"check-host-delay" default:"1s" description:"Minimum interval between checks of destinations on the same host"`
+	CheckTimeout     time.Duration `long:"check-timeout" default:"10s" description:"Timeout of single request to a destination"`
 }
 
 // Execute represents an action after calling the
 // start command
 func (cmd *StartCommand) Execute(args []string) error {
-	s, err := web.NewServer(cmd.Host, cmd.Port, cmd.Expiration)
+	if (cmd.TLSCert == "") != (cmd.TLSKey == "") {
+		return fmt.Errorf("Error while starting web server: both --tls-cert and --tls-key should be specified")
+	}
+
+	if cmd.CORSCredentials && !explicitOrigins(cmd.CORSOrigins) {
+		return fmt.Errorf("Error while starting web server: --cors-credentials requires explicit --cors-origin, any origin (*) is not allowed to send credentials")
+	}
+
+	expiration := cmd.Expiration
+	if expiration <= 0 {
+		expiration = 7
+	}
+
+	dbWorker, err := db.NewWorker(expiration)
 	if err != nil {
 		return fmt.Errorf("Error while starting web server: %v", err)
 	}
+	defer dbWorker.Shutdown()
+
+	// shared by all frontends, so they enforce the same limits
+	linkService, urlPolicy, workspaces, err := cmd.newService(dbWorker)
+	if err != nil {
+		return fmt.Errorf("Error while starting web server: %v", err)
+	}
+
+	options := web.Options{
+		MaxBodySize:  cmd.MaxBodySize,
+		MaxURLLength: cmd.MaxURLLength,
+		CORS: web.CORSOptions{
+			AllowedOrigins:   cmd.CORSOrigins,
+			AllowedMethods:   cmd.CORSMethods,
+			AllowedHeaders:   cmd.CORSHeaders,
+			AllowCredentials: cmd.CORSCredentials,
+			MaxAge:           cmd.CORSMaxAge,
+		},
+		PublicURL: cmd.PublicURL,
+		SwaggerUI: cmd.SwaggerUI,
+
+		LinkService:     linkService,
+		NotActiveStatus: cmd.NotActiveStatus,
+		NotActiveURL:    cmd.NotActiveURL,
+		RedirectStatus:  cmd.RedirectStatus,
+		RedirectMaxAge:  cmd.RedirectMaxAge,
+		TrustedDomains:  cmd.TrustedDomains,
+		PreviewKey:      cmd.PreviewKey,
+
+		Workspaces: workspaces,
+	}
+
+	handler := web.NewHandler(dbWorker, options, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	s.Handle()
+	go func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+
+		signalType := <-ch
+		signal.Stop(ch)
+
+		log.Printf("Received signal: %v", signalType)
+
+		cancel()
+	}()
+
+	// destinations are checked by the same policy they were
+	// accepted by
+	if cmd.CheckInterval > 0 {
+		checker := health.New(health.Options{
+			Concurrency: cmd.CheckConcurrency,
+			HostDelay:   cmd.CheckHostDelay,
+			Timeout:     cmd.CheckTimeout,
+			Policy:      urlPolicy,
+		})
+
+		go checker.Watch(ctx, checkedWorkers(dbWorker, workspaces, linkService), cmd.CheckInterval)
+	}
+
+	runOptions := web.RunOptions{
+		Host:         cmd.Host,
+		Port:         cmd.Port,
+		TLSCert:      cmd.TLSCert,
+		TLSKey:       cmd.TLSKey,
+		RedirectPort: cmd.RedirectPort,
+		HSTSMaxAge:   cmd.HSTSMaxAge,
+
+		ReadTimeout:       cmd.ReadTimeout,
+		ReadHeaderTimeout: cmd.ReadHeaderTimeout,
+		WriteTimeout:      cmd.WriteTimeout,
+		IdleTimeout:       cmd.IdleTimeout,
+	}
+
+	errs := make(chan error, 2)
+	running := 1
+
+	go func() {
+		errs <- web.Run(ctx, handler, runOptions, nil)
+	}()
+
+	if cmd.GRPCPort > 0 {
+		grpcServer := rpc.NewServer(dbWorker, rpc.Options{LinkService: linkService, Workspaces: workspaces}, nil)
+		running++
+
+		go func() {
+			errs <- rpc.Run(ctx, grpcServer, cmd.Host, cmd.GRPCPort, nil)
+		}()
+	}
+
+	// the first failure stops the other listener as well
+	for ; running > 0; running-- {
+		if runErr := <-errs; runErr != nil && err == nil {
+			err = fmt.Errorf("Error while processing requests: %v", runErr)
+			cancel()
+		}
+	}
 
-	return nil
+	return err
 }
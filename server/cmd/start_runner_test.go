@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/service"
+	"github.com/georgiv/url-shortener/server/workspace"
+	"github.com/georgiv/url-shortener/testdata"
+	"github.com/jessevdk/go-flags"
+)
+
+func TestCheckedWorkers(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	workspaces, err := workspace.New([]workspace.Workspace{{ID: "team", Hosts: []string{"go.team.com"}}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	linkService := service.New(dbWorker, service.Options{Workspaces: workspaces})
+	workers := checkedWorkers(dbWorker, workspaces, linkService)
+
+	create := func(s *service.Service, id string) {
+		_, err := s.Create(service.Request{ID: id, URL: "http://testurl.com/" + id})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	createDomain := func(host string) *service.Service {
+		_, err := linkService.CreateDomain(service.DomainRequest{Host: host})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		scoped, _, err := linkService.Host(host)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		return scoped
+	}
+
+	checked := func() string {
+		dbWorkers, err := workers()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		var ids []string
+		for _, w := range dbWorkers {
+			links, err := w.List(db.ListFilter{})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			for _, link := range links {
+				ids = append(ids, link.ID)
+			}
+		}
+		sort.Strings(ids)
+
+		return strings.Join(ids, " ")
+	}
+
+	create(linkService, "deflt1")
+	create(linkService.Workspace(workspaces.Workspaces()[0]), "team01")
+	create(createDomain("go.example.com"), "brand1")
+
+	if ids := checked(); ids != "brand1 deflt1 team01" {
+		t.Errorf("Expected brand1 deflt1 team01, received %v", ids)
+	}
+
+	// domains registered while running are checked on the next tick
+	create(createDomain("links.example.org"), "brand2")
+
+	if ids := checked(); ids != "brand1 brand2 deflt1 team01" {
+		t.Errorf("Expected brand1 brand2 deflt1 team01, received %v", ids)
+	}
+}
+
+func TestStartCORSCredentials(t *testing.T) {
+	cmd := &StartCommand{CORSOrigins: []string{"*"}, CORSCredentials: true}
+
+	err := cmd.Execute(nil)
+	if err == nil || !strings.Contains(err.Error(), "--cors-origin") {
+		t.Errorf("Expected error for credentials of any origin, received: %v", err)
+	}
+}
+
+func TestStartCORSHeaders(t *testing.T) {
+	var cmd StartCommand
+	_, err := flags.ParseArgs(&cmd, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// browsers send the API key and the password of protected
+	// links only when the preflight allows them
+	if headers := strings.Join(cmd.CORSHeaders, ", "); headers != "Accept, Content-Type, Authorization, X-Link-Password" {
+		t.Errorf("Expected Accept, Content-Type, Authorization, X-Link-Password, received %v", headers)
+	}
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/georgiv/url-shortener/server/workspace"
+)
+
+// WorkspaceCommand represents commands for preparing the
+// workspaces file
+type WorkspaceCommand struct {
+	Key WorkspaceKeyCommand `command:"key" description:"Generate API key along with its hash for the workspaces file"`
+}
+
+// WorkspaceKeyCommand represents command for generating API key
+// of a workspace
+type WorkspaceKeyCommand struct{}
+
+// Execute represents an action after calling the
+// workspace key command. The key is handed to the clients of the
+// workspace, while only its hash is stored in the api_keys of
+// the workspace
+func (cmd *WorkspaceKeyCommand) Execute(args []string) error {
+	key, err := workspace.GenerateKey()
+	if err != nil {
+		return fmt.Errorf("Error while generating API key: %v", err)
+	}
+
+	fmt.Fprintf(stdout, "key:  %v\nhash: %v\n", key, workspace.HashKey(key))
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Domain represents branded host served by the deployment. Its
+// links form separate namespace, stored as the workspace named
+// after the host
+type Domain struct {
+	Host string
+
+	// NotFoundPage is the HTML page sent to browsers for ids
+	// which do not exist on the domain. Empty value means the
+	// default not found problem is sent
+	NotFoundPage string
+
+	CreatedAt time.Time
+}
+
+func (worker *db) CreateDomain(domain *Domain) (err error) {
+	createdAt := time.Now()
+
+	_, err = worker.con.Exec("INSERT INTO domain (host, not_found_page, creation_time) VALUES (?, ?, ?)",
+		domain.Host,
+		domain.NotFoundPage,
+		createdAt.Unix())
+	if err != nil {
+		return
+	}
+
+	domain.CreatedAt = time.Unix(createdAt.Unix(), 0)
+
+	return
+}
+
+func (worker *db) GetDomain(host string) (domain *Domain, err error) {
+	rows, err := worker.con.Query("SELECT host, not_found_page, creation_time FROM domain WHERE host = ?", host)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		domain, err = scanDomain(rows)
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+func (worker *db) ListDomains() (domains []*Domain, err error) {
+	rows, err := worker.con.Query("SELECT host, not_found_page, creation_time FROM domain ORDER BY host")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var domain *Domain
+		domain, err = scanDomain(rows)
+		if err != nil {
+			return
+		}
+
+		domains = append(domains, domain)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+func (worker *db) DeleteDomain(host string) (deleted bool, err error) {
+	result, err := worker.con.Exec("DELETE FROM domain WHERE host = ?", host)
+	if err != nil {
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+
+	deleted = affected != 0
+
+	return
+}
+
+func scanDomain(rows *sql.Rows) (domain *Domain, err error) {
+	var createdAt int64
+
+	found := &Domain{}
+	err = rows.Scan(&found.Host, &found.NotFoundPage, &createdAt)
+	if err != nil {
+		return
+	}
+
+	found.CreatedAt = time.Unix(createdAt, 0)
+	domain = found
+
+	return
+}
@@ -13,7 +13,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -31,13 +33,16 @@ type Worker interface {
 	//     and "url_to_id" (accepts url and returns url)
 	//   - param: id or url. In case stmtID is "id_to_url" then
 	//     param should be id. In case stmtID is "url_to_id" then
-	//     param should be url.
+	//     param should be normalized url, which is matched against
+	//     the normalized urls of the links.
 	// Returns id and url in case a result is found. In case of
 	// no match, empty strings are returned along with nil value
 	// for an error. In case of an error, it is returned along
 	// with empty strings for id and url.
 	// If the result is entry which has already expired the return
-	// values are empty strings.
+	// values are empty strings, as its id and url are free. The
+	// entry itself is deleted only after the retention period,
+	// as Get does.
 	Find(stmtID string, param string) (id string, url string, err error)
 
 	// Inserts new URL alias based on provided id and url. This
@@ -46,6 +51,91 @@ type Worker interface {
 	// and error.
 	Register(id string, url string) (err error)
 
+	// Selects the link registered under the provided id along
+	// with its metadata. Links which have not been activated yet
+	// or have expired are returned as well, so their state can be
+	// reported. Links expired for longer than the retention period
+	// are deleted instead. In case of no match or deleted link,
+	// nil is returned along with nil value for an error.
+	Get(id string) (link *Link, err error)
+
+	// Inserts new link based on the provided id, url, tags, owner,
+	// password hash, maximum clicks, activation window, platform
+	// targets and variants. The link, its targets and variants are
+	// inserted in single transaction. In case
+	// ExpiresAt is zero, the default expiration period is counted
+	// from the activation. On success CreatedAt and ExpiresAt of
+	// the link are populated. In case NormalizedURL is empty, URL
+	// is stored as normalized url. Expired links kept for the
+	// retention period which have the same id, url or normalized
+	// url are replaced. As with Register, no preliminary checks
+	// for existing id or url are made.
+	Create(link *Link) (err error)
+
+	// Increments the click count of the link registered under
+	// the provided id unless it has already reached the maximum
+	// clicks. The check and the increment are single atomic
+	// operation, so concurrent clicks cannot exceed the maximum.
+	// Returns false in case the link is exhausted or there is no
+	// such link.
+	Click(id string) (clicked bool, err error)
+
+	// Increments the click count of the variant with the provided
+	// name of the link registered under the provided id. Returns
+	// false in case there is no such variant.
+	ClickVariant(id string, name string) (clicked bool, err error)
+
+	// Deletes the link registered under the provided id along
+	// with its targets and variants. Returns false in case there
+	// is no such link.
+	Delete(id string) (deleted bool, err error)
+
+	// Sets new expiration time of the link registered under the
+	// provided id. Returns false in case there is no such link.
+	Extend(id string, expiresAt time.Time) (extended bool, err error)
+
+	// Records the result of the health check of the destination
+	// of the link registered under the provided id. Returns false
+	// in case there is no such link.
+	RecordCheck(id string, check Check) (recorded bool, err error)
+
+	// Selects the links which have not expired yet and match the
+	// provided filter, ordered from the newest to the oldest.
+	List(filter ListFilter) (links []*Link, err error)
+
+	// Counts the links which have not expired yet.
+	Count() (count int64, err error)
+
+	// Returns worker whose queries are scoped to the workspace
+	// with the provided id, so its links form separate namespace
+	// of ids and urls. The links created through it without
+	// ExpiresAt expire after the provided period in days, the
+	// period of this worker is used in case it is 0. The returned
+	// worker shares the DB pool and its Shutdown does nothing.
+	// The worker returned by NewWorker manages the default
+	// workspace with empty id.
+	Workspace(id string, expiration int) Worker
+
+	// Inserts new branded domain. Domains are shared by all
+	// workspaces. As with Register, no preliminary checks for
+	// existing host are made. On success CreatedAt of the domain
+	// is populated.
+	CreateDomain(domain *Domain) (err error)
+
+	// Selects the domain with the provided lowercase host. In
+	// case of no match, nil is returned along with nil value for
+	// an error.
+	GetDomain(host string) (domain *Domain, err error)
+
+	// Selects all domains ordered by their hosts.
+	ListDomains() (domains []*Domain, err error)
+
+	// Deletes the domain with the provided host. The links of its
+	// namespace are kept until they expire, so they are served
+	// again once the domain is registered again. Returns false in
+	// case there is no such domain.
+	DeleteDomain(host string) (deleted bool, err error)
+
 	// Closes the DB pool and all statements and perform all
 	// necessary cleanups of resources. In case of an error,
 	// it is only logged properly, but not returned.
@@ -108,22 +198,45 @@ func NewWorker(expiration int) (worker Worker, err error) {
 
 	expirationSec := expiration * 24 * 60 * 60
 
-	dbWorker := &db{con: con, expiration: expirationSec}
+	retention := DefaultExpiredRetention
+	if config.ExpiredRetention != nil && *config.ExpiredRetention >= 0 {
+		retention = *config.ExpiredRetention
+	}
+
+	dbWorker := &db{con: con, expiration: expirationSec, retention: int64(retention) * 24 * 60 * 60}
 
 	dbWorker.statements = make(map[string]*sql.Stmt)
 
-	urlByIDStmt, err := dbWorker.prepareStmt("SELECT id, original_url, expiration_time FROM url WHERE id LIKE ?")
+	urlByIDStmt, err := dbWorker.prepareStmt("SELECT id, original_url, expiration_time FROM url WHERE workspace = ? AND id LIKE ?")
 	if err != nil {
 		return
 	}
 	dbWorker.statements["id_to_url"] = urlByIDStmt
 
-	idByURLstmt, err := dbWorker.prepareStmt("SELECT id, original_url, expiration_time FROM url WHERE original_url LIKE ?")
+	idByURLstmt, err := dbWorker.prepareStmt("SELECT id, original_url, expiration_time FROM url WHERE workspace = ? AND normalized_url = ?")
 	if err != nil {
 		return
 	}
 	dbWorker.statements["url_to_id"] = idByURLstmt
 
+	linkByIDStmt, err := dbWorker.prepareStmt("SELECT " + linkColumns + " FROM url WHERE workspace = ? AND id = ?")
+	if err != nil {
+		return
+	}
+	dbWorker.statements["link_by_id"] = linkByIDStmt
+
+	targetsByIDStmt, err := dbWorker.prepareStmt("SELECT id, platform, target_url FROM url_target WHERE workspace = ? AND id = ?")
+	if err != nil {
+		return
+	}
+	dbWorker.statements["targets_by_id"] = targetsByIDStmt
+
+	variantsByIDStmt, err := dbWorker.prepareStmt("SELECT id, name, target_url, weight, click_count FROM url_variant WHERE workspace = ? AND id = ? ORDER BY position")
+	if err != nil {
+		return
+	}
+	dbWorker.statements["variants_by_id"] = variantsByIDStmt
+
 	cleaner := time.NewTicker(time.Duration(expirationSec) * time.Second)
 	// cleaner = time.NewTicker(5 * time.Second)
 	cleanerHandle := make(chan struct{})
@@ -151,11 +264,155 @@ func NewWorker(expiration int) (worker Worker, err error) {
 	return
 }
 
+// Link represents single URL alias along with its metadata
+type Link struct {
+	ID         string
+	URL        string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	ClickCount int64
+	Tags       []string
+	Owner      string
+
+	// PasswordHash is the bcrypt hash of the password protecting
+	// the link. Empty value means the link is not protected
+	PasswordHash string
+
+	// MaxClicks is the number of clicks after which the link is
+	// exhausted. 0 means unlimited clicks
+	MaxClicks int64
+
+	// NotBefore is the time when the link gets active. Zero value
+	// means the link is active since its creation
+	NotBefore time.Time
+
+	// Targets maps platforms to the urls they are redirected to
+	// instead of URL, which remains the default target. Nil or
+	// empty map means all platforms are redirected to URL
+	Targets map[string]string
+
+	// Variants are the destinations which the traffic of URL is
+	// split across by their weights. URL remains the fallback in
+	// case the link has no variants
+	Variants []Variant
+
+	// StickyVariants means visitors should keep the variant they
+	// were first redirected to
+	StickyVariants bool
+
+	// ForwardQuery means the query of the short link is merged
+	// into the query of the target. In case both set the same
+	// parameter, the target wins unless QueryOverride is set
+	ForwardQuery  bool
+	QueryOverride bool
+
+	// UTM are the default UTM parameters appended to the target
+	UTM map[string]string
+
+	// RedirectStatus is the status code the link redirects with.
+	// 0 means the default of the frontend
+	RedirectStatus int
+
+	// Preview means visitors are always shown the preview page
+	// with the destination before they are redirected
+	Preview bool
+
+	// NormalizedURL is the canonical form of URL used for
+	// detecting duplicates, while URL is kept for the redirect
+	NormalizedURL string
+
+	// LastCheck is the result of the last health check of URL.
+	// Zero CheckedAt means the link has not been checked yet
+	LastCheck Check
+}
+
+// Check represents the result of a health check of the
+// destination of a link
+type Check struct {
+	// Status is the status code of the destination. 0 means it
+	// could not be reached
+	Status    int
+	Latency   time.Duration
+	CheckedAt time.Time
+}
+
+// Healthy reports whether the destination responded with
+// successful or redirect status
+func (check Check) Healthy() bool {
+	return check.Status >= 200 && check.Status < 400
+}
+
+// Variant represents single weighted destination of a link
+type Variant struct {
+	Name       string
+	URL        string
+	Weight     int
+	ClickCount int64
+}
+
+// State represents the state of a link relative to its
+// activation window
+type State int
+
+// Possible states of a link
+const (
+	// StateActive means the link is within its window
+	StateActive State = iota
+
+	// StatePending means the window has not opened yet
+	StatePending
+
+	// StateExpired means the window has already closed
+	StateExpired
+)
+
+// DefaultExpiredRetention is the period in days for which
+// expired links are kept, so they can be reported as expired
+// rather than missing, in case expired_retention is not set in
+// the database config
+const DefaultExpiredRetention = 7
+
+// State returns the state of the link at the provided time
+func (link *Link) State(now time.Time) State {
+	var activationTime int64
+	if !link.NotBefore.IsZero() {
+		activationTime = link.NotBefore.Unix()
+	}
+
+	return windowState(activationTime, link.ExpiresAt.Unix(), now.Unix())
+}
+
+// Exhausted reports whether the link has reached its maximum
+// clicks
+func (link *Link) Exhausted() bool {
+	return link.MaxClicks > 0 && link.ClickCount >= link.MaxClicks
+}
+
+// linkColumns are the columns selected for building Link,
+// in the order expected by scanLink
+const linkColumns = "id, original_url, creation_time, expiration_time, click_count, tags, owner, password_hash, max_clicks, activation_time, sticky_variants, forward_query, query_override, utm_params, redirect_status, preview, normalized_url, check_status, check_latency, checked_at"
+
+// ListFilter represents the criteria for selecting links.
+// Empty Owner and Tag match any link. In case Limit is 0 or
+// negative, all matching links are returned
+type ListFilter struct {
+	Owner  string
+	Tag    string
+	Offset int
+	Limit  int
+}
+
 type db struct {
 	con           *sql.DB
 	statements    map[string]*sql.Stmt
 	expiration    int
+	retention     int64
 	cleanerHandle chan struct{}
+
+	// workspace scopes all the queries, scoped is set for the
+	// workers returned by Workspace
+	workspace string
+	scoped    bool
 }
 
 type config struct {
@@ -166,6 +423,10 @@ type config struct {
 	DbName      string `json:"db_name"`
 	MaxOpenCons int    `json:"max_open_cons"`
 	MaxIdleCons int    `json:"max_idle_cons"`
+
+	// ExpiredRetention is the period in days for which expired
+	// links are kept, DefaultExpiredRetention when not set
+	ExpiredRetention *int `json:"expired_retention"`
 }
 
 func (worker *db) Find(stmtID string, param string) (id string, url string, err error) {
@@ -180,37 +441,239 @@ func (worker *db) Find(stmtID string, param string) (id string, url string, err
 		return
 	}
 
-	if expirationTime != 0 {
-		now := int(time.Now().Unix())
-		if now >= expirationTime {
-			log.Printf("Deleting expired entry {%v: %v}...", id, url)
-			err = worker.unregister(id)
+	if id == "" {
+		return
+	}
+
+	// links which have not been activated yet keep their id
+	// and url, while the expired ones release them. They are
+	// deleted only after the retention period, until then Create
+	// replaces them
+	if worker.outdated(int64(expirationTime), time.Now().Unix()) {
+		log.Printf("Deleting expired entry {%v: %v}...", id, url)
+		err = worker.unregister(id)
+		if err != nil {
+			log.Panicf("Deleting expired entry {%v: %v} failed: %v", id, url, err)
+		}
+
+		log.Printf("Expired entry {%v: %v} successfully deleted", id, url)
+	}
+
+	if windowState(0, int64(expirationTime), time.Now().Unix()) == StateExpired {
+		id, url = "", ""
+	}
+
+	return
+}
+
+func (worker *db) Register(id string, url string) (err error) {
+	err = worker.Create(&Link{ID: id, URL: url})
+	return
+}
+
+func (worker *db) Get(id string) (link *Link, err error) {
+	var found *Link
+
+	rows, err := worker.statements["link_by_id"].Query(worker.workspace, id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		found, err = scanLink(rows)
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	if found == nil {
+		return
+	}
+
+	if worker.outdated(found.ExpiresAt.Unix(), time.Now().Unix()) {
+		log.Printf("Deleting expired entry {%v: %v}...", found.ID, found.URL)
+		err = worker.unregister(found.ID)
+		if err != nil {
+			return
+		}
+
+		log.Printf("Expired entry {%v: %v} successfully deleted", found.ID, found.URL)
+		return
+	}
+
+	targetRows, err := worker.statements["targets_by_id"].Query(worker.workspace, found.ID)
+	if err != nil {
+		return
+	}
+	defer targetRows.Close()
+
+	err = scanTargets(targetRows, map[string]*Link{found.ID: found})
+	if err != nil {
+		return
+	}
+
+	variantRows, err := worker.statements["variants_by_id"].Query(worker.workspace, found.ID)
+	if err != nil {
+		return
+	}
+	defer variantRows.Close()
+
+	err = scanVariants(variantRows, map[string]*Link{found.ID: found})
+	if err != nil {
+		return
+	}
+
+	link = found
+
+	return
+}
+
+func (worker *db) Create(link *Link) (err error) {
+	tx, err := worker.con.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("INSERT INTO url(workspace, id, original_url, creation_time, expiration_time, tags, owner, password_hash, max_clicks, activation_time, sticky_variants, forward_query, query_override, utm_params, redirect_status, preview, normalized_url) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+
+	creationTime := time.Now().Unix()
+
+	var activationTime int64
+	if !link.NotBefore.IsZero() {
+		activationTime = link.NotBefore.Unix()
+	}
+
+	expirationTime := link.ExpiresAt.Unix()
+	if link.ExpiresAt.IsZero() {
+		expirationTime = creationTime + int64(worker.expiration)
+		if activationTime > creationTime {
+			expirationTime = activationTime + int64(worker.expiration)
+		}
+	}
+
+	normalizedURL := link.NormalizedURL
+	if normalizedURL == "" {
+		normalizedURL = link.URL
+	}
+
+	// expired links kept for the retention period release their
+	// id and urls to the new link
+	err = worker.releaseExpired(tx, link.ID, link.URL, normalizedURL, creationTime)
+	if err != nil {
+		return
+	}
+
+	_, err = stmt.Exec(worker.workspace, link.ID, link.URL, creationTime, expirationTime, strings.Join(link.Tags, ","), link.Owner, link.PasswordHash, link.MaxClicks, activationTime, link.StickyVariants, link.ForwardQuery, link.QueryOverride, joinUTM(link.UTM), link.RedirectStatus, link.Preview, normalizedURL)
+	if err != nil {
+		return
+	}
+
+	if len(link.Targets) != 0 {
+		var targetStmt *sql.Stmt
+		targetStmt, err = tx.Prepare("INSERT INTO url_target(workspace, id, platform, target_url) VALUES(?, ?, ?, ?)")
+		if err != nil {
+			return
+		}
+		defer targetStmt.Close()
+
+		for platform, target := range link.Targets {
+			_, err = targetStmt.Exec(worker.workspace, link.ID, platform, target)
 			if err != nil {
-				log.Panicf("Deleting expired entry {%v: %v} failed: %v", id, url, err)
+				return
 			}
+		}
+	}
 
-			log.Printf("Expired entry {%v: %v} successfully deleted", id, url)
+	if len(link.Variants) != 0 {
+		var variantStmt *sql.Stmt
+		variantStmt, err = tx.Prepare("INSERT INTO url_variant(workspace, id, name, position, target_url, weight) VALUES(?, ?, ?, ?, ?, ?)")
+		if err != nil {
 			return
 		}
+		defer variantStmt.Close()
+
+		for i, variant := range link.Variants {
+			_, err = variantStmt.Exec(worker.workspace, link.ID, variant.Name, i, variant.URL, variant.Weight)
+			if err != nil {
+				return
+			}
+		}
 	}
 
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+
+	link.CreatedAt = time.Unix(creationTime, 0)
+	link.ExpiresAt = time.Unix(expirationTime, 0)
+
 	return
 }
 
-func (worker *db) Register(id string, url string) (err error) {
+func (worker *db) Click(id string) (clicked bool, err error) {
+	result, err := worker.con.Exec("UPDATE url SET click_count = click_count + 1 WHERE workspace = ? AND id = ? AND (max_clicks = 0 OR click_count < max_clicks)", worker.workspace, id)
+	if err != nil {
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+
+	clicked = affected != 0
+
+	return
+}
+
+func (worker *db) ClickVariant(id string, name string) (clicked bool, err error) {
+	result, err := worker.con.Exec("UPDATE url_variant SET click_count = click_count + 1 WHERE workspace = ? AND id = ? AND name = ?", worker.workspace, id, name)
+	if err != nil {
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+
+	clicked = affected != 0
+
+	return
+}
+
+func (worker *db) Delete(id string) (deleted bool, err error) {
 	tx, err := worker.con.Begin()
 	if err != nil {
 		return
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO url(id, original_url, creation_time, expiration_time) VALUES(?, ?, UNIX_TIMESTAMP(), UNIX_TIMESTAMP() + %v)", worker.expiration))
+	result, err := tx.Exec("DELETE FROM url WHERE workspace = ? AND id = ?", worker.workspace, id)
 	if err != nil {
 		return
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(id, url)
+	for _, table := range []string{"url_target", "url_variant"} {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE workspace = ? AND id = ?", worker.workspace, id)
+		if err != nil {
+			return
+		}
+	}
+
+	affected, err := result.RowsAffected()
 	if err != nil {
 		return
 	}
@@ -220,10 +683,149 @@ func (worker *db) Register(id string, url string) (err error) {
 		return
 	}
 
+	deleted = affected != 0
+
+	return
+}
+
+func (worker *db) Extend(id string, expiresAt time.Time) (extended bool, err error) {
+	tx, err := worker.con.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+
+	result, err := tx.Exec("UPDATE url SET expiration_time = ? WHERE workspace = ? AND id = ? AND expiration_time > ?", expiresAt.Unix(), worker.workspace, id, now)
+	if err != nil {
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+
+	// MySQL reports only the changed rows as affected, so the
+	// link may exist with the same expiration time already
+	if affected == 0 {
+		err = tx.QueryRow("SELECT COUNT(*) FROM url WHERE workspace = ? AND id = ? AND expiration_time > ?", worker.workspace, id, now).Scan(&affected)
+		if err != nil {
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+
+	extended = affected != 0
+
+	return
+}
+
+func (worker *db) RecordCheck(id string, check Check) (recorded bool, err error) {
+	result, err := worker.con.Exec("UPDATE url SET check_status = ?, check_latency = ?, checked_at = ? WHERE workspace = ? AND id = ?",
+		check.Status, check.Latency.Milliseconds(), check.CheckedAt.Unix(), worker.workspace, id)
+	if err != nil {
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+
+	recorded = affected != 0
+
+	return
+}
+
+func (worker *db) List(filter ListFilter) (links []*Link, err error) {
+	query := "SELECT " + linkColumns + " FROM url WHERE workspace = ? AND expiration_time > ?"
+	args := []interface{}{worker.workspace, time.Now().Unix()}
+
+	if filter.Owner != "" {
+		query += " AND owner = ?"
+		args = append(args, filter.Owner)
+	}
+
+	if filter.Tag != "" {
+		query += " AND FIND_IN_SET(?, tags) > 0"
+		args = append(args, filter.Tag)
+	}
+
+	query += " ORDER BY creation_time DESC, id"
+
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := worker.con.Query(query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	links = []*Link{}
+	for rows.Next() {
+		var link *Link
+		link, err = scanLink(rows)
+		if err != nil {
+			links = nil
+			return
+		}
+
+		links = append(links, link)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		links = nil
+		return
+	}
+
+	err = worker.listDetails(links)
+	if err != nil {
+		links = nil
+		return
+	}
+
 	return
 }
 
+func (worker *db) Count() (count int64, err error) {
+	err = worker.con.QueryRow("SELECT COUNT(*) FROM url WHERE workspace = ? AND expiration_time > ?", worker.workspace, time.Now().Unix()).Scan(&count)
+	return
+}
+
+func (worker *db) Workspace(id string, expiration int) Worker {
+	scoped := worker.in(id)
+	if expiration > 0 {
+		scoped.expiration = expiration * 24 * 60 * 60
+	}
+
+	return scoped
+}
+
+// in returns copy of the worker scoped to the workspace
+func (worker *db) in(workspace string) *db {
+	scoped := *worker
+	scoped.workspace = workspace
+	scoped.scoped = true
+
+	return &scoped
+}
+
 func (worker *db) Shutdown() {
+	// the pool is owned by the worker returned by NewWorker
+	if worker.scoped {
+		return
+	}
+
 	log.Println("Shutting down DB pool...")
 
 	close(worker.cleanerHandle)
@@ -254,7 +856,7 @@ func (worker *db) query(stmt *sql.Stmt, param string) (id string,
 	expirationTime int,
 	err error) {
 
-	rows, err := stmt.Query(param)
+	rows, err := stmt.Query(worker.workspace, param)
 	if err != nil {
 		return
 	}
@@ -275,6 +877,46 @@ func (worker *db) query(stmt *sql.Stmt, param string) (id string,
 	return
 }
 
+// releaseExpired deletes in the transaction the expired links
+// having the provided id, url or normalized url
+func (worker *db) releaseExpired(tx *sql.Tx, id string, url string, normalizedURL string, now int64) (err error) {
+	rows, err := tx.Query("SELECT id FROM url WHERE workspace = ? AND (id = ? OR original_url = ? OR normalized_url = ?) AND expiration_time <> 0 AND expiration_time <= ?", worker.workspace, id, url, normalizedURL, now)
+	if err != nil {
+		return
+	}
+
+	var expired []string
+	for rows.Next() {
+		var expiredID string
+		err = rows.Scan(&expiredID)
+		if err != nil {
+			rows.Close()
+			return
+		}
+
+		expired = append(expired, expiredID)
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	for _, expiredID := range expired {
+		log.Printf("Replacing expired entry %v...", expiredID)
+
+		for _, table := range []string{"url", "url_target", "url_variant"} {
+			_, err = tx.Exec("DELETE FROM "+table+" WHERE workspace = ? AND id = ?", worker.workspace, expiredID)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
 func (worker *db) unregister(id string) (err error) {
 	tx, err := worker.con.Begin()
 	if err != nil {
@@ -282,17 +924,24 @@ func (worker *db) unregister(id string) (err error) {
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare("DELETE FROM url WHERE id LIKE ?")
+	stmt, err := tx.Prepare("DELETE FROM url WHERE workspace = ? AND id LIKE ?")
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(id)
+	_, err = stmt.Exec(worker.workspace, id)
 	if err != nil {
 		return
 	}
 
+	for _, table := range []string{"url_target", "url_variant"} {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE workspace = ? AND id LIKE ?", worker.workspace, id)
+		if err != nil {
+			return
+		}
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return
@@ -301,14 +950,53 @@ func (worker *db) unregister(id string) (err error) {
 	return
 }
 
+// listDetails selects the targets and the variants of all the
+// provided links with single query per table
+func (worker *db) listDetails(links []*Link) (err error) {
+	if len(links) == 0 {
+		return
+	}
+
+	byID := make(map[string]*Link, len(links))
+	args := make([]interface{}, 0, len(links)+1)
+	args = append(args, worker.workspace)
+	for _, link := range links {
+		byID[link.ID] = link
+		args = append(args, link.ID)
+	}
+
+	placeholders := strings.Repeat(", ?", len(links))[2:]
+	targetRows, err := worker.con.Query("SELECT id, platform, target_url FROM url_target WHERE workspace = ? AND id IN ("+placeholders+")", args...)
+	if err != nil {
+		return
+	}
+	defer targetRows.Close()
+
+	err = scanTargets(targetRows, byID)
+	if err != nil {
+		return
+	}
+
+	variantRows, err := worker.con.Query("SELECT id, name, target_url, weight, click_count FROM url_variant WHERE workspace = ? AND id IN ("+placeholders+") ORDER BY id, position", args...)
+	if err != nil {
+		return
+	}
+	defer variantRows.Close()
+
+	err = scanVariants(variantRows, byID)
+
+	return
+}
+
 func (worker *db) clean() {
 	var (
+		workspace      string
 		id             string
 		url            string
 		expirationTime int
 	)
 
-	stmt, err := worker.prepareStmt("SELECT id, original_url, expiration_time FROM url")
+	stmt, err := worker.prepareStmt("SELECT workspace, id, original_url, expiration_time FROM url")
 	if err != nil {
 		log.Printf("Error while running cleaner for expired entries: %v", err)
 		return
@@ -323,16 +1011,15 @@ func (worker *db) clean() {
 	defer rows.Close()
 
 	for rows.Next() {
-		err = rows.Scan(&id, &url, &expirationTime)
+		err = rows.Scan(&workspace, &id, &url, &expirationTime)
 		if err != nil {
 			log.Printf("Error while running cleaner for expired entries: %v", err)
 			return
 		}
 
-		now := int(time.Now().Unix())
-		if now >= expirationTime {
+		if worker.outdated(int64(expirationTime), time.Now().Unix()) {
 			log.Printf("Deleting expired entry {%v: %v}...", id, url)
-			err := worker.unregister(id)
+			err := worker.in(workspace).unregister(id)
 			if err != nil {
 				log.Printf("Error while running cleaner for expired entries: %v", err)
 				return
@@ -346,3 +1033,144 @@ func (worker *db) clean() {
 		return
 	}
 }
+
+// windowState returns the state of the link with the provided
+// activation and expiration times in unix seconds at now. 0
+// means the respective bound is not set
+func windowState(activationTime int64, expirationTime int64, now int64) State {
+	switch {
+	case expirationTime != 0 && now >= expirationTime:
+		return StateExpired
+	case activationTime != 0 && now < activationTime:
+		return StatePending
+	default:
+		return StateActive
+	}
+}
+
+// outdated reports whether the link with the provided
+// expiration time has been expired for longer than the
+// retention period, so it should be deleted
+func (worker *db) outdated(expirationTime int64, now int64) bool {
+	return windowState(0, expirationTime+worker.retention, now) == StateExpired
+}
+
+// scanLink builds Link from the current row, which should
+// contain linkColumns
+func scanLink(rows *sql.Rows) (link *Link, err error) {
+	var (
+		creationTime   int64
+		expirationTime int64
+		activationTime int64
+		tags           string
+		utm            string
+		checkLatency   int64
+		checkedAt      int64
+	)
+
+	link = &Link{}
+	err = rows.Scan(&link.ID, &link.URL, &creationTime, &expirationTime, &link.ClickCount, &tags, &link.Owner, &link.PasswordHash, &link.MaxClicks, &activationTime, &link.StickyVariants, &link.ForwardQuery, &link.QueryOverride, &utm, &link.RedirectStatus, &link.Preview, &link.NormalizedURL, &link.LastCheck.Status, &checkLatency, &checkedAt)
+	if err != nil {
+		link = nil
+		return
+	}
+
+	link.CreatedAt = time.Unix(creationTime, 0)
+	link.ExpiresAt = time.Unix(expirationTime, 0)
+	link.Tags = splitTags(tags)
+	link.UTM = splitUTM(utm)
+
+	if activationTime != 0 {
+		link.NotBefore = time.Unix(activationTime, 0)
+	}
+
+	if checkedAt != 0 {
+		link.LastCheck.Latency = time.Duration(checkLatency) * time.Millisecond
+		link.LastCheck.CheckedAt = time.Unix(checkedAt, 0)
+	}
+
+	return
+}
+
+// scanTargets adds the targets from the rows, which should
+// contain id, platform and target_url, to the links with the
+// respective ids
+func scanTargets(rows *sql.Rows, links map[string]*Link) (err error) {
+	for rows.Next() {
+		var id, platform, target string
+		err = rows.Scan(&id, &platform, &target)
+		if err != nil {
+			return
+		}
+
+		link, ok := links[id]
+		if !ok {
+			continue
+		}
+
+		if link.Targets == nil {
+			link.Targets = make(map[string]string)
+		}
+		link.Targets[platform] = target
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+// scanVariants appends the variants from the rows, which should
+// contain id, name, target_url, weight and click_count ordered
+// by position, to the links with the respective ids
+func scanVariants(rows *sql.Rows, links map[string]*Link) (err error) {
+	for rows.Next() {
+		var (
+			id      string
+			variant Variant
+		)
+		err = rows.Scan(&id, &variant.Name, &variant.URL, &variant.Weight, &variant.ClickCount)
+		if err != nil {
+			return
+		}
+
+		if link, ok := links[id]; ok {
+			link.Variants = append(link.Variants, variant)
+		}
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+// joinUTM encodes the UTM parameters as query string
+func joinUTM(utm map[string]string) string {
+	values := url.Values{}
+	for key, value := range utm {
+		values.Set(key, value)
+	}
+
+	return values.Encode()
+}
+
+func splitUTM(utm string) map[string]string {
+	values, err := url.ParseQuery(utm)
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+
+	params := make(map[string]string, len(values))
+	for key := range values {
+		params[key] = values.Get(key)
+	}
+
+	return params
+}
+
+func splitTags(tags string) []string {
+	if tags == "" {
+		return []string{}
+	}
+
+	return strings.Split(tags, ",")
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/georgiv/url-shortener/server/db"
 	"github.com/georgiv/url-shortener/testdata"
@@ -252,3 +253,515 @@ func TestShutdown(t *testing.T) {
 
 	testdata.Execute(t, test)
 }
+
+func TestCreate(t *testing.T) {
+	test := func() {
+		worker, err := db.NewWorker(7)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		defer worker.Shutdown()
+
+		link := &db.Link{
+			ID:    "cranki",
+			URL:   "http://testurl.com",
+			Tags:  []string{"docs", "q3"},
+			Owner: "cranki",
+		}
+
+		err = worker.Create(link)
+		if err != nil {
+			t.Errorf("Expected nil, received %v", err)
+		}
+
+		if link.ExpiresAt.Sub(link.CreatedAt) != 7*24*time.Hour {
+			t.Errorf("Expected 168h0m0s, received %v", link.ExpiresAt.Sub(link.CreatedAt))
+		}
+
+		dbLink, err := worker.Get("cranki")
+		if err != nil {
+			t.Errorf("Expected nil, received %v", err)
+		}
+		if dbLink == nil {
+			t.Fatalf("Expected link, received nil")
+		}
+		if dbLink.URL != link.URL {
+			t.Errorf("Expected %s, received %s", link.URL, dbLink.URL)
+		}
+		if strings.Join(dbLink.Tags, ",") != "docs,q3" {
+			t.Errorf("Expected [docs q3], received %v", dbLink.Tags)
+		}
+		if dbLink.Owner != link.Owner {
+			t.Errorf("Expected %s, received %s", link.Owner, dbLink.Owner)
+		}
+		if !dbLink.CreatedAt.Equal(link.CreatedAt) {
+			t.Errorf("Expected %v, received %v", link.CreatedAt, dbLink.CreatedAt)
+		}
+
+		testdata.DeleteAll(t)
+	}
+
+	testdata.Execute(t, test)
+}
+
+func TestGetNonExistingEntry(t *testing.T) {
+	test := func() {
+		worker, err := db.NewWorker(7)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		defer worker.Shutdown()
+
+		link, err := worker.Get("cranki")
+		if err != nil {
+			t.Errorf("Expected nil, received %v", err)
+		}
+		if link != nil {
+			t.Errorf("Expected nil, received %v", link)
+		}
+	}
+
+	testdata.Execute(t, test)
+}
+
+func TestGetExpiredEntry(t *testing.T) {
+	test := func() {
+		worker, err := db.NewWorker(7)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		defer worker.Shutdown()
+
+		testdata.AddEntry(t, "cranki", "http://testurl.com", -1)
+
+		link, err := worker.Get("cranki")
+		if err != nil {
+			t.Errorf("Expected nil, received %v", err)
+		}
+		if link == nil || link.State(time.Now()) != db.StateExpired {
+			t.Errorf("Expected expired link, received %v", link)
+		}
+
+		testdata.DeleteAll(t)
+	}
+
+	testdata.Execute(t, test)
+}
+
+func TestFindExpiredEntry(t *testing.T) {
+	test := func() {
+		worker, err := db.NewWorker(7)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		defer worker.Shutdown()
+
+		testdata.AddEntry(t, "cranki", "http://testurl.com", -1)
+
+		id, url, err := worker.Find("url_to_id", "http://testurl.com")
+		if err != nil {
+			t.Errorf("Expected nil, received %v", err)
+		}
+		if id != "" || url != "" {
+			t.Errorf("Expected empty strings, received %v and %v", id, url)
+		}
+
+		if dbID, _ := testdata.GetEntry(t, "cranki"); dbID != "cranki" {
+			t.Errorf("Expected expired entry kept, received %v", dbID)
+		}
+
+		err = worker.Register("cranki", "http://anothertesturl.com")
+		if err != nil {
+			t.Errorf("Expected expired entry replaced, received %v", err)
+		}
+
+		if _, dbURL := testdata.GetEntry(t, "cranki"); dbURL != "http://anothertesturl.com" {
+			t.Errorf("Expected http://anothertesturl.com, received %v", dbURL)
+		}
+
+		testdata.DeleteAll(t)
+	}
+
+	testdata.Execute(t, test)
+}
+
+func TestGetOutdatedEntry(t *testing.T) {
+	test := func() {
+		worker, err := db.NewWorker(7)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		defer worker.Shutdown()
+
+		testdata.AddEntry(t, "cranki", "http://testurl.com", -8*24*60*60)
+
+		link, err := worker.Get("cranki")
+		if err != nil {
+			t.Errorf("Expected nil, received %v", err)
+		}
+		if link != nil {
+			t.Errorf("Expected nil, received %v", link)
+		}
+
+		id, _ := testdata.GetEntry(t, "cranki")
+		if id != "" {
+			t.Errorf("Expected empty string, received %s", id)
+		}
+	}
+
+	testdata.Execute(t, test)
+}
+
+func TestCreateActivationWindow(t *testing.T) {
+	test := func() {
+		worker, err := db.NewWorker(7)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		defer worker.Shutdown()
+
+		notBefore := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+
+		err = worker.Create(&db.Link{ID: "cranki", URL: "http://testurl.com", NotBefore: notBefore})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		link, err := worker.Get("cranki")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !link.NotBefore.Equal(notBefore) {
+			t.Errorf("Expected %v, received %v", notBefore, link.NotBefore)
+		}
+		if !link.ExpiresAt.Equal(notBefore.Add(7 * 24 * time.Hour)) {
+			t.Errorf("Expected expiration counted from activation, received %v", link.ExpiresAt)
+		}
+		if link.State(time.Now()) != db.StatePending {
+			t.Errorf("Expected pending link, received %v", link.State(time.Now()))
+		}
+
+		id, _, err := worker.Find("id_to_url", "cranki")
+		if err != nil || id != "cranki" {
+			t.Errorf("Expected pending link to keep its id, received %v, %v", id, err)
+		}
+
+		testdata.DeleteAll(t)
+	}
+
+	testdata.Execute(t, test)
+}
+
+func TestClick(t *testing.T) {
+	test := func() {
+		worker, err := db.NewWorker(7)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		defer worker.Shutdown()
+
+		testdata.AddEntry(t, "cranki", "http://testurl.com", 604800)
+
+		for i := 0; i < 3; i++ {
+			clicked, err := worker.Click("cranki")
+			if err != nil {
+				t.Errorf("Expected nil, received %v", err)
+			}
+			if !clicked {
+				t.Errorf("Expected click to be counted")
+			}
+		}
+
+		link, err := worker.Get("cranki")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if link.ClickCount != 3 {
+			t.Errorf("Expected 3, received %v", link.ClickCount)
+		}
+
+		testdata.DeleteAll(t)
+	}
+
+	testdata.Execute(t, test)
+}
+
+func TestClickMaxClicks(t *testing.T) {
+	test := func() {
+		worker, err := db.NewWorker(7)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		defer worker.Shutdown()
+
+		err = worker.Create(&db.Link{ID: "cranki", URL: "http://testurl.com", MaxClicks: 2})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		results := make(chan bool, 10)
+		for i := 0; i < 10; i++ {
+			go func() {
+				clicked, err := worker.Click("cranki")
+				if err != nil {
+					t.Errorf("Expected nil, received %v", err)
+				}
+				results <- clicked
+			}()
+		}
+
+		counted := 0
+		for i := 0; i < 10; i++ {
+			if <-results {
+				counted++
+			}
+		}
+		if counted != 2 {
+			t.Errorf("Expected 2 counted clicks, received %v", counted)
+		}
+
+		link, err := worker.Get("cranki")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if link.ClickCount != 2 || !link.Exhausted() {
+			t.Errorf("Expected exhausted link with 2 clicks, received %+v", link)
+		}
+
+		testdata.DeleteAll(t)
+	}
+
+	testdata.Execute(t, test)
+}
+
+func TestDelete(t *testing.T) {
+	test := func() {
+		worker, err := db.NewWorker(7)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		defer worker.Shutdown()
+
+		testdata.AddEntry(t, "cranki", "http://testurl.com", 604800)
+		testdata.AddEntry(t, "crank_", "http://anothertesturl.com", 604800)
+
+		deleted, err := worker.Delete("crank_")
+		if err != nil {
+			t.Errorf("Expected nil, received %v", err)
+		}
+		if !deleted {
+			t.Errorf("Expected true, received false")
+		}
+
+		id, _ := testdata.GetEntry(t, "cranki")
+		if id != "cranki" {
+			t.Errorf("Expected cranki, received %s", id)
+		}
+
+		deleted, err = worker.Delete("crank_")
+		if err != nil {
+			t.Errorf("Expected nil, received %v", err)
+		}
+		if deleted {
+			t.Errorf("Expected false, received true")
+		}
+
+		testdata.DeleteAll(t)
+	}
+
+	testdata.Execute(t, test)
+}
+
+func TestList(t *testing.T) {
+	test := func() {
+		worker, err := db.NewWorker(7)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		defer worker.Shutdown()
+
+		links := []*db.Link{
+			{ID: "cranki", URL: "http://testurl.com", Tags: []string{"docs", "q3"}, Owner: "cranki"},
+			{ID: "tester", URL: "http://anothertesturl.com", Tags: []string{"q3"}},
+		}
+		for _, link := range links {
+			err = worker.Create(link)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+
+		testdata.AddEntry(t, "expird", "http://expiredtesturl.com", -1)
+
+		found, err := worker.List(db.ListFilter{})
+		if err != nil {
+			t.Errorf("Expected nil, received %v", err)
+		}
+		if len(found) != 2 {
+			t.Errorf("Expected 2 links, received %v", len(found))
+		}
+
+		found, err = worker.List(db.ListFilter{Tag: "docs"})
+		if err != nil {
+			t.Errorf("Expected nil, received %v", err)
+		}
+		if len(found) != 1 || found[0].ID != "cranki" {
+			t.Errorf("Expected [cranki], received %v", found)
+		}
+
+		found, err = worker.List(db.ListFilter{Owner: "cranki", Tag: "q3"})
+		if err != nil {
+			t.Errorf("Expected nil, received %v", err)
+		}
+		if len(found) != 1 || found[0].ID != "cranki" {
+			t.Errorf("Expected [cranki], received %v", found)
+		}
+
+		found, err = worker.List(db.ListFilter{Offset: 1, Limit: 1})
+		if err != nil {
+			t.Errorf("Expected nil, received %v", err)
+		}
+		if len(found) != 1 {
+			t.Errorf("Expected 1 link, received %v", len(found))
+		}
+
+		testdata.DeleteAll(t)
+	}
+
+	testdata.Execute(t, test)
+}
+
+func TestCreateTargets(t *testing.T) {
+	test := func() {
+		worker, err := db.NewWorker(7)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		defer worker.Shutdown()
+
+		targets := map[string]string{
+			"ios":     "https://apps.apple.com/app/id1",
+			"android": "https://play.google.com/store/apps/details?id=app",
+		}
+		err = worker.Create(&db.Link{ID: "cranki", URL: "http://testurl.com", Targets: targets})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		err = worker.Create(&db.Link{ID: "tester", URL: "http://anothertesturl.com"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		link, err := worker.Get("cranki")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(link.Targets) != 2 || link.Targets["ios"] != targets["ios"] {
+			t.Errorf("Expected targets %v, received %v", targets, link.Targets)
+		}
+
+		found, err := worker.List(db.ListFilter{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for _, link := range found {
+			if link.ID == "cranki" && len(link.Targets) != 2 || link.ID == "tester" && len(link.Targets) != 0 {
+				t.Errorf("Unexpected targets of %v: %v", link.ID, link.Targets)
+			}
+		}
+
+		_, err = worker.Delete("cranki")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		err = worker.Create(&db.Link{ID: "cranki", URL: "http://testurl.com"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		link, err = worker.Get("cranki")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(link.Targets) != 0 {
+			t.Errorf("Expected targets of the deleted link to be removed, received %v", link.Targets)
+		}
+
+		testdata.DeleteAll(t)
+	}
+
+	testdata.Execute(t, test)
+}
+
+func TestCreateVariants(t *testing.T) {
+	test := func() {
+		worker, err := db.NewWorker(7)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		defer worker.Shutdown()
+
+		variants := []db.Variant{
+			{Name: "b", URL: "http://testurl.com/b", Weight: 30},
+			{Name: "a", URL: "http://testurl.com/a", Weight: 70},
+		}
+		err = worker.Create(&db.Link{ID: "cranki", URL: "http://testurl.com", Variants: variants, StickyVariants: true})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		clicked, err := worker.ClickVariant("cranki", "a")
+		if err != nil || !clicked {
+			t.Errorf("Expected clicked variant, received %v, %v", clicked, err)
+		}
+
+		clicked, err = worker.ClickVariant("cranki", "c")
+		if err != nil || clicked {
+			t.Errorf("Expected missing variant, received %v, %v", clicked, err)
+		}
+
+		link, err := worker.Get("cranki")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !link.StickyVariants || len(link.Variants) != 2 || link.Variants[0].Name != "b" || link.Variants[1].ClickCount != 1 {
+			t.Errorf("Unexpected variants: %+v", link.Variants)
+		}
+
+		found, err := worker.List(db.ListFilter{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(found) != 1 || len(found[0].Variants) != 2 || found[0].Variants[1].Weight != 70 {
+			t.Errorf("Unexpected listed variants: %+v", found)
+		}
+
+		_, err = worker.Delete("cranki")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		clicked, err = worker.ClickVariant("cranki", "a")
+		if err != nil || clicked {
+			t.Errorf("Expected variants of the deleted link to be removed, received %v, %v", clicked, err)
+		}
+
+		testdata.DeleteAll(t)
+	}
+
+	testdata.Execute(t, test)
+}
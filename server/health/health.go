@@ -0,0 +1,442 @@
+// Package health checks whether the destinations of the links
+// are still reachable, so rotten links are found before the
+// visitors report them.
+//
+// Copyright 2019 cranki. All rights reserved.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/policy"
+)
+
+// DefaultConcurrency is the number of destinations checked at
+// the same time used when Options.Concurrency is not set
+const DefaultConcurrency = 4
+
+// DefaultHostDelay is the minimum interval between requests to
+// the same host used when Options.HostDelay is not set
+const DefaultHostDelay = time.Second
+
+// DefaultTimeout is the timeout of a single request used when
+// Options.Timeout is not set
+const DefaultTimeout = 10 * time.Second
+
+// DefaultUserAgent is the User-Agent header sent to the
+// destinations when Options.UserAgent is empty
+const DefaultUserAgent = "url-shortener-linkcheck/1.0"
+
+// maxRedirects is the number of redirects followed before the
+// destination is reported as unreachable
+const maxRedirects = 10
+
+// Options represents the settings of Checker
+type Options struct {
+	// Concurrency is the number of destinations checked at the
+	// same time. In case 0 or negative value is set,
+	// DefaultConcurrency is used
+	Concurrency int
+
+	// HostDelay is the minimum interval between the requests to
+	// the same host. In case 0 or negative value is set,
+	// DefaultHostDelay is used
+	HostDelay time.Duration
+
+	// Timeout limits single request including the redirects,
+	// but not the wait for the host. In case 0 or negative value
+	// is set, DefaultTimeout is used
+	Timeout time.Duration
+
+	// UserAgent is sent to the destinations. In case it is
+	// empty, DefaultUserAgent is used
+	UserAgent string
+
+	// Policy decides which destinations and redirects may be
+	// requested. In case it is nil, the default policy rejecting
+	// private hosts is used
+	Policy *policy.Policy
+
+	// Transport sends the requests. In case it is nil, transport
+	// like http.DefaultTransport without proxy is used, which
+	// refuses to connect to private, loopback and link-local
+	// addresses after resolving the hosts, unless the policy
+	// allows private hosts
+	Transport http.RoundTripper
+
+	// Resolver resolves the hosts of the destinations when
+	// Transport is nil. In case it is nil, the default resolver
+	// is used
+	Resolver *net.Resolver
+
+	// Logger reports the progress of Watch. In case it is nil,
+	// the standard logger is used
+	Logger *log.Logger
+}
+
+// Result represents the check of the destination of a link
+type Result struct {
+	db.Check
+
+	ID  string
+	URL string
+
+	// Err is the reason the destination could not be reached
+	Err error
+}
+
+// Checker checks the destinations of links. It is safe for
+// concurrent use
+type Checker struct {
+	options Options
+	client  *http.Client
+
+	mu    sync.Mutex
+	hosts map[string]time.Time
+}
+
+// New creates and returns Checker with the provided options
+func New(options Options) *Checker {
+	if options.Concurrency <= 0 {
+		options.Concurrency = DefaultConcurrency
+	}
+
+	if options.HostDelay <= 0 {
+		options.HostDelay = DefaultHostDelay
+	}
+
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+
+	if options.UserAgent == "" {
+		options.UserAgent = DefaultUserAgent
+	}
+
+	if options.Logger == nil {
+		options.Logger = log.New(log.Writer(), log.Prefix(), log.Flags())
+	}
+
+	if options.Policy == nil {
+		// the default policy reads no files, so it cannot fail
+		options.Policy, _ = policy.New(policy.Options{Logger: options.Logger})
+	}
+
+	checker := &Checker{
+		options: options,
+		hosts:   make(map[string]time.Time),
+	}
+
+	transport := options.Transport
+	if transport == nil {
+		transport = newTransport(options)
+	}
+
+	checker.client = &http.Client{
+		Transport: transport,
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("Stopped after %v redirects", maxRedirects)
+			}
+
+			return options.Policy.Check(r.URL.String())
+		},
+	}
+
+	return checker
+}
+
+// newTransport returns transport connecting to the destinations
+// directly. The policy checks only the names of the hosts, so
+// unless it allows private hosts, the connections to private
+// addresses are refused after resolving them, including the
+// ones of redirects
+func newTransport(options Options) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Resolver:  options.Resolver,
+	}
+	if !options.Policy.AllowsPrivate() {
+		dialer.Control = refusePrivate
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return transport
+}
+
+// refusePrivate refuses connections to private, loopback,
+// link-local and unspecified addresses
+func refusePrivate(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || policy.PrivateIP(ip) {
+		return fmt.Errorf("Address %v is private, loopback or link-local address", host)
+	}
+
+	return nil
+}
+
+// Check sends HEAD request to the url and falls back to GET in
+// case the destination does not support HEAD. Redirects are
+// followed, so the status is the one of the final destination
+func (checker *Checker) Check(ctx context.Context, rawURL string) (result Result) {
+	result.URL = rawURL
+	defer func() {
+		result.CheckedAt = time.Now()
+	}()
+
+	err := checker.options.Policy.Check(rawURL)
+	if err != nil {
+		result.Err = err
+		return
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		result.Err = err
+		return
+	}
+
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		err = checker.wait(ctx, u.Host)
+		if err != nil {
+			result.Err = err
+			return
+		}
+
+		requestCtx, cancel := context.WithTimeout(ctx, checker.options.Timeout)
+		start := time.Now()
+		status, err := checker.send(requestCtx, method, rawURL)
+		result.Status, result.Latency, result.Err = status, time.Since(start), err
+		cancel()
+
+		if status != http.StatusMethodNotAllowed && status != http.StatusNotImplemented {
+			return
+		}
+	}
+
+	return
+}
+
+// send sends request with the method to the url and returns the
+// status code. The body is not read
+func (checker *Checker) send(ctx context.Context, method string, rawURL string) (status int, err error) {
+	r, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return
+	}
+	r.Header.Set("User-Agent", checker.options.UserAgent)
+
+	resp, err := checker.client.Do(r)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return
+	}
+	resp.Body.Close()
+
+	status = resp.StatusCode
+
+	return
+}
+
+// wait blocks until the next request to the host is allowed
+// and reserves the following slot, so the requests to the same
+// host are at least HostDelay apart
+func (checker *Checker) wait(ctx context.Context, host string) error {
+	host = strings.ToLower(host)
+
+	checker.mu.Lock()
+	now := time.Now()
+	next := checker.hosts[host]
+	if next.Before(now) {
+		next = now
+	}
+	checker.hosts[host] = next.Add(checker.options.HostDelay)
+	checker.mu.Unlock()
+
+	timer := time.NewTimer(next.Sub(now))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CheckLinks checks the destinations of the links with the
+// configured concurrency and records the results through the
+// DB worker. The links are interleaved by host, so the workers
+// are not all waiting for the same host. In case fn is not nil,
+// it is called with the results in the order of completion from
+// single goroutine. Returns the first error of recording
+func (checker *Checker) CheckLinks(ctx context.Context, dbWorker db.Worker, links []*db.Link, fn func(Result)) error {
+	queue := make(chan *db.Link)
+	results := make(chan Result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < checker.options.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for link := range queue {
+				result := checker.Check(ctx, link.URL)
+				result.ID = link.ID
+				results <- result
+			}
+		}()
+	}
+
+	go func() {
+		defer close(queue)
+
+		for _, link := range interleave(links) {
+			select {
+			case queue <- link:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var recordErr error
+	for result := range results {
+		// interrupted checks say nothing about the destination
+		if ctx.Err() != nil {
+			continue
+		}
+
+		if recordErr == nil {
+			_, recordErr = dbWorker.RecordCheck(result.ID, result.Check)
+		}
+
+		if fn != nil {
+			fn(result)
+		}
+	}
+
+	if recordErr != nil {
+		return recordErr
+	}
+
+	return ctx.Err()
+}
+
+// Stale returns the links which have not been checked since
+// the provided time
+func Stale(links []*db.Link, since time.Time) []*db.Link {
+	stale := make([]*db.Link, 0, len(links))
+	for _, link := range links {
+		if link.LastCheck.CheckedAt.Before(since) {
+			stale = append(stale, link)
+		}
+	}
+
+	return stale
+}
+
+// Watch checks the destinations of all links not checked within
+// the interval every interval until the context is done. The
+// links are managed by the DB workers returned by workers, which
+// is called on every tick, so the namespaces added meanwhile are
+// checked as well. The errors are only logged
+func (checker *Checker) Watch(ctx context.Context, workers func() ([]db.Worker, error), interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		dbWorkers, err := workers()
+		if err != nil {
+			checker.options.Logger.Printf("Error while listing links for health check: %v", err)
+			continue
+		}
+
+		var checked, unhealthy int
+		for _, dbWorker := range dbWorkers {
+			links, err := dbWorker.List(db.ListFilter{})
+			if err != nil {
+				checker.options.Logger.Printf("Error while listing links for health check: %v", err)
+				continue
+			}
+
+			err = checker.CheckLinks(ctx, dbWorker, Stale(links, time.Now().Add(-interval)), func(result Result) {
+				checked++
+				if !result.Healthy() {
+					unhealthy++
+				}
+			})
+			if err != nil && ctx.Err() == nil {
+				checker.options.Logger.Printf("Error while recording health checks: %v", err)
+			}
+		}
+
+		if checked != 0 {
+			checker.options.Logger.Printf("Health check of %v links completed, %v unhealthy", checked, unhealthy)
+		}
+	}
+}
+
+// interleave orders the links round-robin by the hosts of their
+// destinations, keeping the order of the links of each host
+func interleave(links []*db.Link) []*db.Link {
+	var hosts []string
+	byHost := make(map[string][]*db.Link)
+	for _, link := range links {
+		host := ""
+		if u, err := url.Parse(link.URL); err == nil {
+			host = strings.ToLower(u.Host)
+		}
+
+		if _, ok := byHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], link)
+	}
+
+	ordered := make([]*db.Link, 0, len(links))
+	for len(ordered) < len(links) {
+		for _, host := range hosts {
+			if queued := byHost[host]; len(queued) != 0 {
+				ordered = append(ordered, queued[0])
+				byHost[host] = queued[1:]
+			}
+		}
+	}
+
+	return ordered
+}
@@ -0,0 +1,243 @@
+package health_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/health"
+	"github.com/georgiv/url-shortener/server/policy"
+	"github.com/georgiv/url-shortener/testdata"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func newChecker(t *testing.T, options health.Options) *health.Checker {
+	// the test servers listen on loopback addresses
+	p, err := policy.New(policy.Options{AllowPrivate: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	options.Policy = p
+	if options.HostDelay == 0 {
+		options.HostDelay = time.Millisecond
+	}
+
+	return health.New(options)
+}
+
+func TestCheck(t *testing.T) {
+	var methods []string
+	var mu sync.Mutex
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/ok":
+		case "/moved":
+			http.Redirect(w, r, "/gone", http.StatusFound)
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/nohead":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/slow":
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	checker := newChecker(t, health.Options{Timeout: 50 * time.Millisecond})
+
+	tests := []struct {
+		path    string
+		status  int
+		healthy bool
+	}{
+		{"/ok", 200, true},
+		{"/moved", 410, false},
+		{"/nohead", 200, true},
+		{"/slow", 0, false},
+	}
+
+	for _, test := range tests {
+		result := checker.Check(context.Background(), server.URL+test.path)
+		if result.Status != test.status || result.Healthy() != test.healthy {
+			t.Errorf("%v: expected status %v, received %v (%v)", test.path, test.status, result.Status, result.Err)
+		}
+
+		if result.CheckedAt.IsZero() || (test.status != 0 && result.Latency <= 0) {
+			t.Errorf("%v: expected latency and time of the check, received %+v", test.path, result.Check)
+		}
+	}
+
+	mu.Lock()
+	if methods[2] != "HEAD /gone" || methods[3] != "HEAD /nohead" || methods[4] != "GET /nohead" {
+		t.Errorf("Unexpected requests: %v", methods)
+	}
+	mu.Unlock()
+
+	blocked := health.New(health.Options{}).Check(context.Background(), server.URL+"/ok")
+	if blocked.Err == nil || blocked.Status != 0 {
+		t.Errorf("Expected private destination rejected by the default policy, received %+v", blocked)
+	}
+}
+
+// loopbackResolver returns resolver answering every name with
+// 127.0.0.1 through fake DNS server, like public names pointing
+// to private addresses do
+func loopbackResolver(t *testing.T) *net.Resolver {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var query dnsmessage.Message
+			if query.Unpack(buf[:n]) != nil || len(query.Questions) != 1 {
+				continue
+			}
+
+			answer := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true},
+				Questions: query.Questions,
+			}
+			if question := query.Questions[0]; question.Type == dnsmessage.TypeA {
+				answer.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+				}}
+			}
+
+			packed, err := answer.Pack()
+			if err == nil {
+				conn.WriteTo(packed, addr)
+			}
+		}
+	}()
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", conn.LocalAddr().String())
+		},
+	}
+}
+
+func TestCheckResolvedPrivate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	target := "http://internal.example.com:" + port + "/ok"
+
+	resolver := loopbackResolver(t)
+
+	// the name passes the policy, but resolves to loopback address
+	result := health.New(health.Options{Resolver: resolver}).Check(context.Background(), target)
+	if result.Err == nil || !strings.Contains(result.Err.Error(), "127.0.0.1") || result.Status != 0 {
+		t.Errorf("Expected destination resolved to loopback address refused, received %+v", result)
+	}
+
+	p, err := policy.New(policy.Options{AllowPrivate: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	result = health.New(health.Options{Resolver: resolver, Policy: p}).Check(context.Background(), target)
+	if result.Err != nil || result.Status != 200 {
+		t.Errorf("Expected private destination allowed by the policy, received %+v", result)
+	}
+}
+
+func TestCheckLinks(t *testing.T) {
+	var mu sync.Mutex
+	var last time.Time
+	var minGap time.Duration = time.Hour
+	var inFlight, maxInFlight int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		now := time.Now()
+		if !last.IsZero() && now.Sub(last) < minGap {
+			minGap = now.Sub(last)
+		}
+		last = now
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("first1", server.URL+"/a")
+	dbWorker.Register("second", server.URL+"/b")
+	dbWorker.Register("missng", server.URL+"/missing")
+
+	links, _ := dbWorker.List(db.ListFilter{})
+
+	checker := newChecker(t, health.Options{Concurrency: 3, HostDelay: 30 * time.Millisecond})
+
+	results := make(map[string]health.Result)
+	err := checker.CheckLinks(context.Background(), dbWorker, links, func(result health.Result) {
+		results[result.ID] = result
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(results) != 3 || results["missng"].Status != 404 || results["first1"].Status != 200 {
+		t.Errorf("Unexpected results: %+v", results)
+	}
+
+	// all the links share the host, so they are requested one by one
+	if minGap < 25*time.Millisecond || maxInFlight != 1 {
+		t.Errorf("Expected requests at least 30ms apart, received %v apart, %v at once", minGap, maxInFlight)
+	}
+
+	link, _ := dbWorker.Get("missng")
+	if link.LastCheck.Status != 404 || link.LastCheck.CheckedAt.IsZero() {
+		t.Errorf("Expected recorded check, received %+v", link.LastCheck)
+	}
+
+	stale := health.Stale(links, time.Now().Add(-time.Hour))
+	if len(stale) != 3 {
+		t.Errorf("Expected links listed before the check to be stale, received %v", len(stale))
+	}
+
+	links, _ = dbWorker.List(db.ListFilter{})
+	if stale := health.Stale(links, time.Now().Add(-time.Hour)); len(stale) != 0 {
+		t.Errorf("Expected no stale links, received %v", len(stale))
+	}
+}
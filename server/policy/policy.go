@@ -0,0 +1,425 @@
+// Package policy decides which urls may be shortened, so the
+// service cannot be used as an open redirector to dangerous
+// schemes, internal hosts or denied domains.
+//
+// Copyright 2019 cranki. All rights reserved.
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/idna"
+)
+
+// DefaultSchemes are the schemes allowed when Options.Schemes
+// is empty
+var DefaultSchemes = []string{"http", "https"}
+
+// DefaultReloadInterval is the interval of checking the
+// blocklist file for changes used when Options.ReloadInterval
+// is not set
+const DefaultReloadInterval = 10 * time.Second
+
+// Options represents the rules of Policy. Domain patterns are
+// matched against the host of the url in the form returned by
+// CanonicalHost, to which the patterns are converted as well,
+// so international names match their punycode form. Patterns
+// without wildcards match the domain and all its subdomains,
+// patterns with wildcards (*, ? and [...] as in path.Match)
+// match the whole host, e.g. *.example.com matches only the
+// subdomains of example.com
+type Options struct {
+	// Schemes are the allowed schemes of the urls. In case it is
+	// empty, DefaultSchemes are used
+	Schemes []string
+
+	// AllowedDomains are the only domains allowed, in case it is
+	// not empty
+	AllowedDomains []string
+
+	// DeniedDomains are the domains which are not allowed, even
+	// when they match AllowedDomains
+	DeniedDomains []string
+
+	// AllowPrivate allows hosts which are private, loopback,
+	// link-local or unspecified addresses and the localhost and
+	// .local names. They are rejected by default
+	AllowPrivate bool
+
+	// BlocklistFile is the path of a file with denied domain
+	// patterns, one per line. Empty lines and lines starting
+	// with # are ignored. The file is reloaded when it changes
+	BlocklistFile string
+
+	// ReloadInterval is the minimum interval between checks of
+	// the blocklist file for changes. In case 0 or negative
+	// value is set, DefaultReloadInterval is used
+	ReloadInterval time.Duration
+
+	// Logger reports the reloads of the blocklist file and the
+	// errors while reloading it. In case it is nil, the standard
+	// logger is used
+	Logger *log.Logger
+}
+
+// Violation is returned by Policy.Check for urls breaking the
+// rules
+type Violation struct {
+	URL    string
+	Detail string
+}
+
+func (v *Violation) Error() string {
+	return v.Detail
+}
+
+// Policy checks urls by the rules of its options. It is safe
+// for concurrent use
+type Policy struct {
+	options Options
+	schemes map[string]bool
+	allowed []string
+	denied  []string
+
+	mu        sync.Mutex
+	blocklist []string
+	modTime   time.Time
+	size      int64
+	checked   time.Time
+}
+
+// New creates and returns Policy with the provided options. In
+// case the blocklist file cannot be read, error is returned
+func New(options Options) (*Policy, error) {
+	if len(options.Schemes) == 0 {
+		options.Schemes = DefaultSchemes
+	}
+
+	if options.ReloadInterval <= 0 {
+		options.ReloadInterval = DefaultReloadInterval
+	}
+
+	if options.Logger == nil {
+		options.Logger = log.New(log.Writer(), log.Prefix(), log.Flags())
+	}
+
+	policy := &Policy{
+		options: options,
+		schemes: make(map[string]bool, len(options.Schemes)),
+		allowed: canonicalPatterns(options.AllowedDomains),
+		denied:  canonicalPatterns(options.DeniedDomains),
+	}
+	for _, scheme := range options.Schemes {
+		policy.schemes[strings.ToLower(scheme)] = true
+	}
+
+	if options.BlocklistFile != "" {
+		err := policy.load(time.Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return policy, nil
+}
+
+// Check returns *Violation in case the url breaks the rules.
+// The url is expected to be already parsed successfully by
+// url.ParseRequestURI
+func (policy *Policy) Check(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return &Violation{URL: rawURL, Detail: fmt.Sprintf("Invalid url: %v", rawURL)}
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if !policy.schemes[scheme] {
+		return &Violation{URL: rawURL, Detail: fmt.Sprintf("Scheme %v is not allowed. Allowed schemes: %v", scheme, policy.options.Schemes)}
+	}
+
+	host := CanonicalHost(u.Hostname())
+	if host == "" {
+		if scheme == "http" || scheme == "https" {
+			return &Violation{URL: rawURL, Detail: fmt.Sprintf("Url %v has no host", rawURL)}
+		}
+
+		return nil
+	}
+
+	if !policy.options.AllowPrivate && isPrivate(host) {
+		return &Violation{URL: rawURL, Detail: fmt.Sprintf("Host %v is private, loopback or link-local address", host)}
+	}
+
+	if len(policy.allowed) != 0 && !matchesAny(host, policy.allowed) {
+		return &Violation{URL: rawURL, Detail: fmt.Sprintf("Domain %v is not allowed", host)}
+	}
+
+	if matchesAny(host, policy.denied) || matchesAny(host, policy.currentBlocklist()) {
+		return &Violation{URL: rawURL, Detail: fmt.Sprintf("Domain %v is denied", host)}
+	}
+
+	return nil
+}
+
+// AllowsPrivate reports whether the policy allows private,
+// loopback and link-local hosts
+func (policy *Policy) AllowsPrivate() bool {
+	return policy.options.AllowPrivate
+}
+
+// currentBlocklist returns the patterns of the blocklist file,
+// reloading them first in case the file has changed since they
+// were loaded. In case the reload fails, the loaded patterns
+// are kept
+func (policy *Policy) currentBlocklist() []string {
+	if policy.options.BlocklistFile == "" {
+		return nil
+	}
+
+	policy.mu.Lock()
+	defer policy.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(policy.checked) >= policy.options.ReloadInterval {
+		err := policy.loadLocked(now)
+		if err != nil {
+			policy.options.Logger.Printf("Error while reloading blocklist %v: %v", policy.options.BlocklistFile, err)
+		}
+	}
+
+	return policy.blocklist
+}
+
+func (policy *Policy) load(now time.Time) error {
+	policy.mu.Lock()
+	defer policy.mu.Unlock()
+
+	return policy.loadLocked(now)
+}
+
+// loadLocked reads the blocklist file in case its modification
+// time or size differs from the loaded one
+func (policy *Policy) loadLocked(now time.Time) error {
+	policy.checked = now
+
+	info, err := os.Stat(policy.options.BlocklistFile)
+	if err != nil {
+		return err
+	}
+
+	if info.ModTime().Equal(policy.modTime) && info.Size() == policy.size {
+		return nil
+	}
+
+	f, err := os.Open(policy.options.BlocklistFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return err
+	}
+
+	if !policy.modTime.IsZero() {
+		policy.options.Logger.Printf("Reloaded blocklist %v: %v patterns", policy.options.BlocklistFile, len(patterns))
+	}
+
+	policy.blocklist = canonicalPatterns(patterns)
+	policy.modTime = info.ModTime()
+	policy.size = info.Size()
+
+	return nil
+}
+
+// CanonicalHost returns the lowercase host without the trailing
+// dot, converting international domain names to punycode the way
+// browsers do, e.g. bücher.example to xn--bcher-kva.example and
+// the ideographic full stop to dot. Hosts which are not valid
+// domain names, e.g. with underscores, are converted as far as
+// possible
+func CanonicalHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || net.ParseIP(host) != nil {
+		return host
+	}
+
+	ascii, _ := idna.Lookup.ToASCII(host)
+	if ascii == "" {
+		return host
+	}
+
+	return strings.TrimSuffix(ascii, ".")
+}
+
+// canonicalPatterns converts the domain patterns to the form of
+// the hosts they are matched against, dropping the empty ones
+func canonicalPatterns(patterns []string) []string {
+	converted := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = CanonicalHost(strings.TrimSpace(pattern))
+		if pattern != "" {
+			converted = append(converted, pattern)
+		}
+	}
+
+	return converted
+}
+
+// matchesAny reports whether the host matches any of the
+// domain patterns, which should be converted by
+// canonicalPatterns
+func matchesAny(host string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, "*?[") {
+			if host == pattern || strings.HasSuffix(host, "."+pattern) {
+				return true
+			}
+			continue
+		}
+
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isPrivate reports whether the host is an address which should
+// not be reachable through the service, or a name resolving to
+// such addresses by convention. Names are not resolved, so public
+// names of private addresses are not detected
+func isPrivate(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".local") {
+		return true
+	}
+
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ip = parseLooseIPv4(host)
+	}
+	if ip == nil {
+		return false
+	}
+
+	return PrivateIP(ip)
+}
+
+// reservedNets are the special-purpose ranges which reach
+// internal hosts besides the private ones: this network, the
+// shared address space of carrier-grade NAT, the benchmarking
+// networks and the NAT64 prefix embedding IPv4 addresses
+var reservedNets = parseCIDRs("0.0.0.0/8", "100.64.0.0/10", "198.18.0.0/15", "64:ff9b::/96")
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+
+	return nets
+}
+
+// PrivateIP reports whether the address is private, loopback,
+// link-local, unspecified or in one of the reserved ranges, so
+// it should not be reachable through the service
+func PrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseLooseIPv4 parses the IPv4 forms which browsers accept
+// besides the dotted decimal one, i.e. 1 to 4 parts in decimal,
+// octal (leading 0) or hexadecimal (leading 0x), e.g. 2130706433,
+// 0x7f.1 or 0177.0.0.1. It returns nil for other hosts
+func parseLooseIPv4(host string) net.IP {
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+
+	values := make([]uint64, len(parts))
+	for i, part := range parts {
+		v, ok := parseIPv4Part(part)
+		if !ok {
+			return nil
+		}
+		values[i] = v
+	}
+
+	// the last part fills the remaining bytes
+	var ip uint64
+	for i, v := range values[:len(values)-1] {
+		if v > 0xff {
+			return nil
+		}
+		ip |= v << (8 * uint(3-i))
+	}
+
+	last := values[len(values)-1]
+	if last >= 1<<(8*uint(5-len(values))) {
+		return nil
+	}
+	ip |= last
+
+	return net.IPv4(byte(ip>>24), byte(ip>>16), byte(ip>>8), byte(ip))
+}
+
+// parseIPv4Part parses single part of the loose IPv4 forms, which
+// is hexadecimal with leading 0x, octal with leading 0 or decimal.
+// Other prefixes, signs and underscores are not accepted
+func parseIPv4Part(part string) (uint64, bool) {
+	base, digits := 10, part
+	switch {
+	case strings.HasPrefix(part, "0x") || strings.HasPrefix(part, "0X"):
+		base, digits = 16, part[2:]
+		// browsers read bare 0x as 0
+		if digits == "" {
+			return 0, true
+		}
+	case len(part) > 1 && part[0] == '0':
+		base, digits = 8, part[1:]
+	}
+
+	v, err := strconv.ParseUint(digits, base, 32)
+	return v, err == nil
+}
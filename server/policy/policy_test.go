@@ -0,0 +1,147 @@
+package policy_test
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/policy"
+)
+
+func TestCheck(t *testing.T) {
+	defaultPolicy, _ := policy.New(policy.Options{})
+	domains, _ := policy.New(policy.Options{
+		AllowedDomains: []string{"example.com", "*.example.org", "bit.*"},
+		DeniedDomains:  []string{"evil.example.com"},
+	})
+	private, _ := policy.New(policy.Options{Schemes: []string{"http", "mailto"}, AllowPrivate: true})
+	international, _ := policy.New(policy.Options{
+		DeniedDomains: []string{"evil.com", "xn--bcher-kva.example", "*.münchen.example"},
+	})
+
+	tests := []struct {
+		policy  *policy.Policy
+		url     string
+		allowed bool
+	}{
+		{defaultPolicy, "https://google.com/search?q=go", true},
+		{defaultPolicy, "HTTP://Google.COM", true},
+		{defaultPolicy, "javascript:alert(1)", false},
+		{defaultPolicy, "data:text/html,<script>alert(1)</script>", false},
+		{defaultPolicy, "file:///etc/passwd", false},
+		{defaultPolicy, "ftp://files.example.com", false},
+		{defaultPolicy, "http:///path", false},
+		{defaultPolicy, "http://localhost:8080", false},
+		{defaultPolicy, "http://api.localhost", false},
+		{defaultPolicy, "http://printer.local", false},
+		{defaultPolicy, "http://127.0.0.1", false},
+		{defaultPolicy, "http://127.1", false},
+		{defaultPolicy, "http://2130706433", false},
+		{defaultPolicy, "http://0x7f000001", false},
+		{defaultPolicy, "http://0177.0.0.1", false},
+		{defaultPolicy, "http://0x7f.0.0.01", false},
+		{defaultPolicy, "http://0x.0x.0x.0x", false},
+		{defaultPolicy, "http://0b1111111.1", true},
+		{defaultPolicy, "http://0o177.0.0.1", true},
+		{defaultPolicy, "http://1_27.0.0.1", true},
+		{defaultPolicy, "http://+127.0.0.1", true},
+		{defaultPolicy, "http://0.0.0.0", false},
+		{defaultPolicy, "http://0.1.2.3", false},
+		{defaultPolicy, "http://100.64.0.1", false},
+		{defaultPolicy, "http://100.127.255.254", false},
+		{defaultPolicy, "http://100.128.0.1", true},
+		{defaultPolicy, "http://198.18.0.1", false},
+		{defaultPolicy, "http://198.19.255.254", false},
+		{defaultPolicy, "http://198.20.0.1", true},
+		{defaultPolicy, "http://[64:ff9b::7f00:1]", false},
+		{defaultPolicy, "http://[64:ff9b::808:808]", false},
+		{defaultPolicy, "http://10.1.2.3", false},
+		{defaultPolicy, "http://172.16.0.1", false},
+		{defaultPolicy, "http://192.168.1.1", false},
+		{defaultPolicy, "http://169.254.169.254/latest/meta-data", false},
+		{defaultPolicy, "http://[::1]", false},
+		{defaultPolicy, "http://[fe80::1%25eth0]", false},
+		{defaultPolicy, "http://[fd00::1]", false},
+		{defaultPolicy, "http://[::ffff:127.0.0.1]", false},
+		{defaultPolicy, "http://8.8.8.8", true},
+		{defaultPolicy, "http://1e100.net", true},
+		{domains, "https://example.com", true},
+		{domains, "https://www.example.com.", true},
+		{domains, "https://evil.example.com", false},
+		{domains, "https://a.evil.example.com", false},
+		{domains, "https://example.org", false},
+		{domains, "https://www.example.org", true},
+		{domains, "https://bit.ly", true},
+		{domains, "https://notexample.com", false},
+		{private, "http://localhost:8080", true},
+		{private, "http://192.168.1.1", true},
+		{private, "mailto:someone@example.com", true},
+		{private, "https://google.com", false},
+		{defaultPolicy, "http://１２７．０．０．１", false},
+		{international, "http://evil。com/", false},
+		{international, "http://EVIL．com./", false},
+		{international, "http://bücher.example/", false},
+		{international, "http://shop.BÜCHER.example/", false},
+		{international, "http://www.xn--mnchen-3ya.example/", false},
+		{international, "http://bucher.example/", true},
+	}
+
+	for _, test := range tests {
+		err := test.policy.Check(test.url)
+		if allowed := err == nil; allowed != test.allowed {
+			t.Errorf("%v: expected allowed %v, received %v", test.url, test.allowed, err)
+		}
+
+		if _, ok := err.(*policy.Violation); err != nil && !ok {
+			t.Errorf("%v: expected *policy.Violation, received %T", test.url, err)
+		}
+	}
+}
+
+func TestBlocklistFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "blocklist.txt")
+	err := ioutil.WriteFile(file, []byte("# phishing\nphish.example.com\n\n*.malware.test\n"), 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	p, err := policy.New(policy.Options{BlocklistFile: file, ReloadInterval: time.Nanosecond, Logger: log.New(ioutil.Discard, "", 0)})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for url, allowed := range map[string]bool{
+		"https://phish.example.com":       false,
+		"https://login.phish.example.com": false,
+		"https://a.malware.test":          false,
+		"https://malware.test":            true,
+		"https://spam.test":               true,
+	} {
+		if err := p.Check(url); (err == nil) != allowed {
+			t.Errorf("%v: expected allowed %v, received %v", url, allowed, err)
+		}
+	}
+
+	err = ioutil.WriteFile(file, []byte("spam.test\n"), 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if p.Check("https://spam.test") == nil || p.Check("https://phish.example.com") != nil {
+		t.Errorf("Expected reloaded blocklist")
+	}
+
+	// the last loaded patterns are kept while the file is missing
+	os.Remove(file)
+	if p.Check("https://spam.test") == nil {
+		t.Errorf("Expected blocklist kept after failed reload")
+	}
+
+	_, err = policy.New(policy.Options{BlocklistFile: file})
+	if err == nil {
+		t.Errorf("Expected error for missing blocklist file")
+	}
+}
@@ -0,0 +1,236 @@
+// Package qr renders QR codes of short links as PNG or SVG
+// images. The codes are encoded in-process, so the rendering
+// needs no external service.
+//
+// Copyright 2019 cranki. All rights reserved.
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Format is the image format of the rendered code
+type Format string
+
+// Supported formats
+const (
+	FormatPNG Format = "png"
+	FormatSVG Format = "svg"
+)
+
+// Formats lists all supported formats
+var Formats = []Format{FormatPNG, FormatSVG}
+
+// Level is the error correction level of the code. Higher
+// levels tolerate more damage of the printed code at the cost
+// of more modules
+type Level string
+
+// Supported levels, recovering about 7%, 15%, 25% and 30% of
+// the code respectively
+const (
+	LevelLow      Level = "L"
+	LevelMedium   Level = "M"
+	LevelQuartile Level = "Q"
+	LevelHigh     Level = "H"
+)
+
+// Levels lists all supported levels
+var Levels = []Level{LevelLow, LevelMedium, LevelQuartile, LevelHigh}
+
+var recoveryLevels = map[Level]qrcode.RecoveryLevel{
+	LevelLow:      qrcode.Low,
+	LevelMedium:   qrcode.Medium,
+	LevelQuartile: qrcode.High,
+	LevelHigh:     qrcode.Highest,
+}
+
+// Limits and defaults of the options
+const (
+	DefaultSize   = 256
+	MinSize       = 32
+	MaxSize       = 2048
+	DefaultMargin = 4
+	MaxMargin     = 32
+	DefaultLevel  = LevelMedium
+	DefaultFormat = FormatPNG
+)
+
+// Options represents the parameters of the rendered code
+type Options struct {
+	// Format of the image, DefaultFormat when empty
+	Format Format
+
+	// Size is the width and height of the image in pixels. In
+	// case it is 0, DefaultSize is used. Codes with too many
+	// modules for the size are rendered larger, one pixel per
+	// module
+	Size int
+
+	// Level is the error correction level, DefaultLevel when
+	// empty
+	Level Level
+
+	// Margin is the width of the quiet zone around the code in
+	// modules. Scanners expect at least DefaultMargin
+	Margin int
+}
+
+// ValidFormat reports whether f is one of Formats
+func ValidFormat(f Format) bool {
+	return f == FormatPNG || f == FormatSVG
+}
+
+// ValidLevel reports whether l is one of Levels
+func ValidLevel(l Level) bool {
+	_, ok := recoveryLevels[l]
+	return ok
+}
+
+// ContentType returns the media type of the format
+func ContentType(f Format) string {
+	if f == FormatSVG {
+		return "image/svg+xml"
+	}
+
+	return "image/png"
+}
+
+// FormatOf returns the format matching the extension of the
+// file name, FormatSVG for .svg files and FormatPNG otherwise
+func FormatOf(name string) Format {
+	if strings.HasSuffix(strings.ToLower(name), ".svg") {
+		return FormatSVG
+	}
+
+	return FormatPNG
+}
+
+// Encode renders the QR code of the content. The same content
+// and options always result in the same image
+func Encode(content string, options Options) (data []byte, err error) {
+	options, err = withDefaults(options)
+	if err != nil {
+		return
+	}
+
+	code, err := qrcode.New(content, recoveryLevels[options.Level])
+	if err != nil {
+		err = fmt.Errorf("Error while encoding QR code: %v", err)
+		return
+	}
+	code.DisableBorder = true
+
+	modules := code.Bitmap()
+
+	if options.Format == FormatSVG {
+		data = renderSVG(modules, options)
+		return
+	}
+
+	return renderPNG(modules, options)
+}
+
+func withDefaults(options Options) (Options, error) {
+	if options.Format == "" {
+		options.Format = DefaultFormat
+	}
+
+	if options.Size == 0 {
+		options.Size = DefaultSize
+	}
+
+	if options.Level == "" {
+		options.Level = DefaultLevel
+	}
+
+	switch {
+	case !ValidFormat(options.Format):
+		return options, fmt.Errorf("Invalid format: %v. Supported formats: %v", options.Format, Formats)
+	case !ValidLevel(options.Level):
+		return options, fmt.Errorf("Invalid level: %v. Supported levels: %v", options.Level, Levels)
+	case options.Size < MinSize || options.Size > MaxSize:
+		return options, fmt.Errorf("Invalid size: %v. It should be integer between %v and %v", options.Size, MinSize, MaxSize)
+	case options.Margin < 0 || options.Margin > MaxMargin:
+		return options, fmt.Errorf("Invalid margin: %v. It should be integer between 0 and %v", options.Margin, MaxMargin)
+	}
+
+	return options, nil
+}
+
+// renderPNG scales the modules by the largest whole number of
+// pixels fitting the size and centers them in the image
+func renderPNG(modules [][]bool, options Options) ([]byte, error) {
+	total := len(modules) + 2*options.Margin
+
+	side := options.Size
+	if side < total {
+		side = total
+	}
+
+	scale := side / total
+	offset := (side-scale*total)/2 + options.Margin*scale
+
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y, row := range modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(offset+x*scale+dx, offset+y*scale+dy, 1)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	err := encoder.Encode(&buf, img)
+	if err != nil {
+		return nil, fmt.Errorf("Error while encoding PNG: %v", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// renderSVG draws the dark modules as single path, one
+// rectangle per horizontal run, in units of modules
+func renderSVG(modules [][]bool, options Options) []byte {
+	total := len(modules) + 2*options.Margin
+
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%v" height="%v" viewBox="0 0 %v %v" shape-rendering="crispEdges">`+"\n", options.Size, options.Size, total, total)
+	buf.WriteString(`<rect width="100%" height="100%" fill="#ffffff"/>` + "\n")
+	buf.WriteString(`<path fill="#000000" d="`)
+
+	for y, row := range modules {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+
+			run := 1
+			for x+run < len(row) && row[x+run] {
+				run++
+			}
+
+			fmt.Fprintf(&buf, "M%v %vh%vv1h-%vz", x+options.Margin, y+options.Margin, run, run)
+			x += run - 1
+		}
+	}
+
+	buf.WriteString(`"/>` + "\n</svg>\n")
+
+	return buf.Bytes()
+}
@@ -0,0 +1,110 @@
+package qr_test
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/georgiv/url-shortener/server/qr"
+)
+
+const content = "https://s.example.com/api/urls/cranki"
+
+func TestEncodePNG(t *testing.T) {
+	data, err := qr.Encode(content, qr.Options{Size: 300, Margin: 4})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Invalid PNG: %v", err)
+	}
+
+	if bounds := img.Bounds(); bounds.Dx() != 300 || bounds.Dy() != 300 {
+		t.Fatalf("Expected 300x300 image, received %v", bounds)
+	}
+
+	// version 3 code has 29 modules, so with the margin of 4 each
+	// module is 8 pixels and the code is centered with 2 pixels
+	dark := func(x, y int) bool {
+		r, _, _, _ := img.At(x, y).RGBA()
+		return r == 0
+	}
+
+	if dark(0, 0) || dark(33, 33) {
+		t.Errorf("Expected light quiet zone")
+	}
+
+	// the finder pattern in the top left corner
+	if !dark(34, 34) || !dark(34+6*8, 34) || dark(34+8, 34+8) || !dark(34+3*8, 34+3*8) {
+		t.Errorf("Expected finder pattern in the top left corner")
+	}
+
+	again, _ := qr.Encode(content, qr.Options{Size: 300, Margin: 4})
+	if !bytes.Equal(data, again) {
+		t.Errorf("Expected the same image for the same options")
+	}
+
+	other, _ := qr.Encode(content, qr.Options{Size: 300, Margin: 4, Level: qr.LevelHigh})
+	if bytes.Equal(data, other) {
+		t.Errorf("Expected different image for different level")
+	}
+}
+
+func TestEncodeSmallSize(t *testing.T) {
+	data, err := qr.Encode(content, qr.Options{Size: 32, Margin: 2})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Invalid PNG: %v", err)
+	}
+
+	if bounds := img.Bounds(); bounds.Dx() != 33 {
+		t.Errorf("Expected image of one pixel per module, received %v", bounds)
+	}
+}
+
+func TestEncodeSVG(t *testing.T) {
+	data, err := qr.Encode(content, qr.Options{Format: qr.FormatSVG, Size: 512, Margin: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	svg := string(data)
+	for _, expected := range []string{`width="512"`, `viewBox="0 0 31 31"`, `d="M1 1h7v1h-7z`} {
+		if !strings.Contains(svg, expected) {
+			t.Errorf("Expected %v in %v", expected, svg)
+		}
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	tests := []qr.Options{
+		{Format: "gif"},
+		{Level: "X"},
+		{Size: 16},
+		{Size: qr.MaxSize + 1},
+		{Margin: -1},
+		{Margin: qr.MaxMargin + 1},
+	}
+
+	for _, options := range tests {
+		_, err := qr.Encode(content, options)
+		if err == nil {
+			t.Errorf("%+v: expected error", options)
+		}
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	for name, expected := range map[string]qr.Format{"poster.svg": qr.FormatSVG, "POSTER.SVG": qr.FormatSVG, "poster.png": qr.FormatPNG, "poster": qr.FormatPNG} {
+		if format := qr.FormatOf(name); format != expected {
+			t.Errorf("%v: expected %v, received %v", name, expected, format)
+		}
+	}
+}
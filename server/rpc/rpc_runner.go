@@ -0,0 +1,66 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// Run serves the provided gRPC server on host and port until
+// the context is cancelled or the listener fails. On
+// cancellation the server is stopped gracefully, waiting up
+// to 5 seconds for the in-flight calls.
+// Params:
+//   - ctx: context controlling the lifetime of the listener
+//   - server: server handling the incoming calls
+//   - host: binding host of the listener
+//   - port: listening port of the listener
+//   - logger: logger used for reporting the listener state. In
+//     case nil is passed, a logger writing to the standard error
+//     with the standard flags is used
+func Run(ctx context.Context, server *grpc.Server, host string, port int, logger *log.Logger) (err error) {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("%v:%v", host, port))
+	if err != nil {
+		return
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	logger.Printf("gRPC server accepts requests on port %v", port)
+
+	select {
+	case err = <-errs:
+		return
+	case <-ctx.Done():
+	}
+
+	logger.Println("Shutting down gRPC server...")
+
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		server.Stop()
+	}
+
+	logger.Println("gRPC server successfully shut down")
+
+	return
+}
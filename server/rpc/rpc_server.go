@@ -0,0 +1,386 @@
+// Package rpc provides gRPC server exposing the API for
+// registering and resolving URL aliases described in
+// shortenerpb/shortener.proto.
+//
+// Copyright 2019 cranki. All rights reserved.
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/rpc/shortenerpb"
+	"github.com/georgiv/url-shortener/server/service"
+	"github.com/georgiv/url-shortener/server/workspace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// errorDomain is the domain of the google.rpc.ErrorInfo
+// details attached to the returned errors
+const errorDomain = "url-shortener"
+
+// accessCodes maps the codes of service.AccessError to the
+// status codes
+var accessCodes = map[string]codes.Code{
+	service.CodePasswordRequired:  codes.Unauthenticated,
+	service.CodePasswordIncorrect: codes.PermissionDenied,
+	service.CodeTooManyAttempts:   codes.ResourceExhausted,
+	service.CodeLinkExhausted:     codes.FailedPrecondition,
+	service.CodeLinkNotActive:     codes.FailedPrecondition,
+	service.CodeLinkExpired:       codes.FailedPrecondition,
+}
+
+// Options represents the tunable parameters of the server
+// returned by NewServer
+type Options struct {
+	// MaxURLLength is the maximum length of the registered URL.
+	// In case 0 or negative value is set,
+	// service.DefaultMaxURLLength is used
+	MaxURLLength int
+
+	// LinkService applies the rules for registering and resolving
+	// links. Frontends sharing the same instance share the limits
+	// of failed password attempts. In case it is nil, one is
+	// created on top of the DB worker honouring MaxURLLength
+	LinkService *service.Service
+
+	// Workspaces are the tenants of the deployment. Calls are
+	// assigned to a workspace by their API key, sent as bearer
+	// authorization metadata, or by their authority. In case it
+	// is nil, all links belong to the default workspace
+	Workspaces *workspace.Registry
+}
+
+// NewServer creates and returns gRPC server with registered
+// LinkService, the standard health-check service and the
+// server reflection. It does not own the lifecycle of the
+// provided DB worker, so the caller is responsible for
+// shutting it down.
+// Params:
+//   - dbWorker: storage layer used for registering and
+//     looking up URL aliases
+//   - options: tunable parameters of the server
+//   - logger: logger used for reporting errors. In case nil
+//     is passed, a logger writing to the standard error with
+//     the standard flags is used
+func NewServer(dbWorker db.Worker, options Options, logger *log.Logger) *grpc.Server {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if options.LinkService == nil {
+		options.LinkService = service.New(dbWorker, service.Options{MaxURLLength: options.MaxURLLength, Logger: logger})
+	}
+
+	server := grpc.NewServer()
+
+	shortenerpb.RegisterLinkServiceServer(server, &linkServer{
+		linkService: options.LinkService,
+		workspaces:  options.Workspaces,
+		logger:      logger,
+	})
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus(shortenerpb.LinkService_ServiceDesc.ServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+
+	reflection.Register(server)
+
+	return server
+}
+
+type linkServer struct {
+	shortenerpb.UnimplementedLinkServiceServer
+
+	linkService *service.Service
+	workspaces  *workspace.Registry
+	logger      *log.Logger
+}
+
+func (server *linkServer) CreateLink(ctx context.Context, req *shortenerpb.CreateLinkRequest) (*shortenerpb.Link, error) {
+	create := service.Request{
+		ID:        req.GetId(),
+		URL:       req.GetUrl(),
+		Tags:      req.GetTags(),
+		Owner:     req.GetOwner(),
+		Password:  req.GetPassword(),
+		MaxClicks: req.GetMaxClicks(),
+		Targets:   req.GetTargets(),
+
+		StickyVariants: req.GetStickyVariants(),
+
+		ForwardQuery:  req.GetForwardQuery(),
+		QueryOverride: req.GetQueryOverride(),
+		UTM:           req.GetUtm(),
+
+		RedirectStatus: int(req.GetRedirectStatus()),
+		Preview:        req.GetPreview(),
+	}
+	for _, v := range req.GetVariants() {
+		create.Variants = append(create.Variants, db.Variant{Name: v.GetName(), URL: v.GetUrl(), Weight: int(v.GetWeight())})
+	}
+	if req.NotBefore != nil {
+		create.NotBefore = req.NotBefore.AsTime()
+	}
+	if req.ExpiresAt != nil {
+		create.ExpiresAt = req.ExpiresAt.AsTime()
+	}
+
+	linkService, err := server.links(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := linkService.Create(create)
+	if err != nil {
+		return nil, server.serviceError(err, "Error while registering id %v for url %v: %v", req.GetId(), req.GetUrl(), err)
+	}
+
+	return toMessage(link), nil
+}
+
+func (server *linkServer) GetLink(ctx context.Context, req *shortenerpb.GetLinkRequest) (*shortenerpb.Link, error) {
+	linkService, err := server.links(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := linkService.DB().Get(req.GetId())
+	if err != nil {
+		return nil, server.internalError("Error while retrieving data for id %v: %v", req.GetId(), err)
+	}
+
+	if link == nil {
+		return nil, notFound(req.GetId())
+	}
+
+	return toMessage(link), nil
+}
+
+func (server *linkServer) ResolveLink(ctx context.Context, req *shortenerpb.ResolveLinkRequest) (*shortenerpb.ResolveLinkResponse, error) {
+	linkService, err := server.links(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := linkService.Resolve(req.GetId(), req.GetPassword())
+	if err != nil {
+		return nil, server.serviceError(err, "Error while retrieving data for id %v: %v", req.GetId(), err)
+	}
+
+	if link == nil {
+		return nil, notFound(req.GetId())
+	}
+
+	target, variant := linkService.Destination(link, req.GetUserAgent(), req.GetVariant())
+	linkService.CountVariant(link, variant)
+
+	// malformed pairs are skipped, as browsers would do
+	query, _ := url.ParseQuery(req.GetQuery())
+	target = service.MergeQuery(link, target, query)
+
+	return &shortenerpb.ResolveLinkResponse{Url: target, Variant: variant}, nil
+}
+
+func (server *linkServer) DeleteLink(ctx context.Context, req *shortenerpb.DeleteLinkRequest) (*shortenerpb.DeleteLinkResponse, error) {
+	linkService, err := server.links(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := linkService.DB().Delete(req.GetId())
+	if err != nil {
+		return nil, server.internalError("Error while deleting id %v: %v", req.GetId(), err)
+	}
+
+	if !deleted {
+		return nil, notFound(req.GetId())
+	}
+
+	return &shortenerpb.DeleteLinkResponse{}, nil
+}
+
+func (server *linkServer) ListLinks(ctx context.Context, req *shortenerpb.ListLinksRequest) (*shortenerpb.ListLinksResponse, error) {
+	filter := db.ListFilter{
+		Owner:  req.GetOwner(),
+		Tag:    req.GetTag(),
+		Offset: int(req.GetOffset()),
+		Limit:  int(req.GetLimit()),
+	}
+	if filter.Limit == 0 {
+		filter.Limit = service.DefaultListLimit
+	}
+
+	linkService, err := server.links(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := linkService.List(filter)
+	if err != nil {
+		return nil, server.serviceError(err, "Error while listing links: %v", err)
+	}
+
+	resp := &shortenerpb.ListLinksResponse{
+		Links:  make([]*shortenerpb.Link, 0, len(links)),
+		Offset: int32(filter.Offset),
+		Limit:  int32(filter.Limit),
+	}
+	for _, link := range links {
+		resp.Links = append(resp.Links, toMessage(link))
+	}
+
+	return resp, nil
+}
+
+// serviceError converts the errors returned by service.Service
+// to status errors. Unexpected errors are logged and reported
+// as internal
+func (server *linkServer) serviceError(err error, format string, v ...interface{}) error {
+	switch e := err.(type) {
+	case *service.ValidationError:
+		code := e.Errors[0].Code
+		if len(e.Errors) > 1 {
+			code = "validation_failed"
+		}
+
+		violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(e.Errors))
+		for _, fieldErr := range e.Errors {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{
+				Field:       fieldErr.Field,
+				Description: fieldErr.Detail,
+			})
+		}
+
+		return withDetails(status.New(codes.InvalidArgument, e.Error()),
+			&errdetails.ErrorInfo{Reason: code, Domain: errorDomain},
+			&errdetails.BadRequest{FieldViolations: violations})
+	case *service.PolicyError:
+		violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(e.Errors))
+		for _, fieldErr := range e.Errors {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{
+				Field:       fieldErr.Field,
+				Description: fieldErr.Detail,
+			})
+		}
+
+		return withDetails(status.New(codes.PermissionDenied, e.Error()),
+			&errdetails.ErrorInfo{Reason: e.Code(), Domain: errorDomain},
+			&errdetails.BadRequest{FieldViolations: violations})
+	case *service.ConflictError:
+		return withDetails(status.New(codes.AlreadyExists, e.Detail),
+			&errdetails.ErrorInfo{
+				Reason:   e.Code,
+				Domain:   errorDomain,
+				Metadata: map[string]string{"id": e.ID, "url": e.URL},
+			})
+	case *service.QuotaError:
+		return withDetails(status.New(codes.ResourceExhausted, e.Error()),
+			&errdetails.ErrorInfo{
+				Reason:   service.CodeQuotaExceeded,
+				Domain:   errorDomain,
+				Metadata: map[string]string{"workspace": e.Workspace},
+			})
+	case *service.AccessError:
+		details := []protoadapt.MessageV1{&errdetails.ErrorInfo{
+			Reason:   e.Code,
+			Domain:   errorDomain,
+			Metadata: map[string]string{"id": e.ID},
+		}}
+		if e.RetryAfter > 0 {
+			details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(e.RetryAfter)})
+		}
+
+		return withDetails(status.New(accessCodes[e.Code], e.Detail), details...)
+	default:
+		return server.internalError(format, v...)
+	}
+}
+
+func (server *linkServer) internalError(format string, v ...interface{}) error {
+	server.logger.Printf(format, v...)
+
+	return withDetails(status.New(codes.Internal, "Internal server error"),
+		&errdetails.ErrorInfo{Reason: "internal_error", Domain: errorDomain})
+}
+
+func notFound(id string) error {
+	return withDetails(status.New(codes.NotFound, fmt.Sprintf("ID %v does not exist", id)),
+		&errdetails.ErrorInfo{
+			Reason:   "not_found",
+			Domain:   errorDomain,
+			Metadata: map[string]string{"id": id},
+		})
+}
+
+// withDetails attaches the details to the status. In case they
+// cannot be marshalled, the status is returned without them
+func withDetails(st *status.Status, details ...protoadapt.MessageV1) error {
+	detailed, err := st.WithDetails(details...)
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+func toMessage(link *db.Link) *shortenerpb.Link {
+	message := &shortenerpb.Link{
+		Id:         link.ID,
+		Url:        link.URL,
+		CreatedAt:  timestamppb.New(link.CreatedAt),
+		ExpiresAt:  timestamppb.New(link.ExpiresAt),
+		ClickCount: link.ClickCount,
+		Tags:       link.Tags,
+		Owner:      link.Owner,
+		Protected:  link.PasswordHash != "",
+		MaxClicks:  link.MaxClicks,
+		Targets:    link.Targets,
+
+		StickyVariants: link.StickyVariants,
+
+		ForwardQuery:  link.ForwardQuery,
+		QueryOverride: link.QueryOverride,
+		Utm:           link.UTM,
+
+		RedirectStatus: int32(link.RedirectStatus),
+		Preview:        link.Preview,
+	}
+
+	for _, v := range link.Variants {
+		message.Variants = append(message.Variants, &shortenerpb.Variant{Name: v.Name, Url: v.URL, Weight: int32(v.Weight), ClickCount: v.ClickCount})
+	}
+
+	if !link.NotBefore.IsZero() {
+		message.NotBefore = timestamppb.New(link.NotBefore)
+	}
+
+	if link.MaxClicks > link.ClickCount {
+		message.RemainingClicks = link.MaxClicks - link.ClickCount
+	}
+
+	if !link.LastCheck.CheckedAt.IsZero() {
+		message.Check = &shortenerpb.Check{
+			Status:    int32(link.LastCheck.Status),
+			Healthy:   link.LastCheck.Healthy(),
+			LatencyMs: link.LastCheck.Latency.Milliseconds(),
+			CheckedAt: timestamppb.New(link.LastCheck.CheckedAt),
+		}
+	}
+
+	return message
+}
@@ -0,0 +1,422 @@
+package rpc_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/rpc"
+	"github.com/georgiv/url-shortener/server/rpc/shortenerpb"
+	"github.com/georgiv/url-shortener/server/workspace"
+	"github.com/georgiv/url-shortener/testdata"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func dial(t *testing.T) *grpc.ClientConn {
+	return dialWith(t, rpc.Options{})
+}
+
+func dialWith(t *testing.T, options rpc.Options) *grpc.ClientConn {
+	listener := bufconn.Listen(1024 * 1024)
+	server := rpc.NewServer(testdata.NewMemoryWorker(), options, nil)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Error while dialing: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func reason(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+
+	return ""
+}
+
+func TestCreateAndResolve(t *testing.T) {
+	c := shortenerpb.NewLinkServiceClient(dial(t))
+	ctx := context.Background()
+
+	link, err := c.CreateLink(ctx, &shortenerpb.CreateLinkRequest{Id: "cranki", Url: "http://testurl.com", Tags: []string{"docs"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if link.Id != "cranki" || link.Url != "http://testurl.com" || !link.ExpiresAt.AsTime().After(link.CreatedAt.AsTime()) {
+		t.Errorf("Unexpected link: %v", link)
+	}
+
+	resolved, err := c.ResolveLink(ctx, &shortenerpb.ResolveLinkRequest{Id: "cranki"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if resolved.Url != "http://testurl.com" {
+		t.Errorf("Expected url http://testurl.com, received: %v", resolved.Url)
+	}
+
+	link, err = c.GetLink(ctx, &shortenerpb.GetLinkRequest{Id: "cranki"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if link.ClickCount != 1 {
+		t.Errorf("Expected 1 click, received: %v", link.ClickCount)
+	}
+}
+
+func TestCreateErrors(t *testing.T) {
+	c := shortenerpb.NewLinkServiceClient(dial(t))
+	ctx := context.Background()
+
+	_, err := c.CreateLink(ctx, &shortenerpb.CreateLinkRequest{Id: "cranki", Url: "http://testurl.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		req    *shortenerpb.CreateLinkRequest
+		code   codes.Code
+		reason string
+	}{
+		{&shortenerpb.CreateLinkRequest{Url: "testurl"}, codes.InvalidArgument, "invalid_url"},
+		{&shortenerpb.CreateLinkRequest{Id: "abc", Url: "http://other.com"}, codes.InvalidArgument, "alias_invalid"},
+		{&shortenerpb.CreateLinkRequest{Id: "abc", Url: "other"}, codes.InvalidArgument, "validation_failed"},
+		{&shortenerpb.CreateLinkRequest{Id: "cranki", Url: "http://other.com"}, codes.AlreadyExists, "alias_taken"},
+		{&shortenerpb.CreateLinkRequest{Url: "http://testurl.com"}, codes.AlreadyExists, "url_taken"},
+		{&shortenerpb.CreateLinkRequest{Url: "javascript:alert(1)"}, codes.PermissionDenied, "url_blocked"},
+	}
+
+	for _, test := range tests {
+		_, err := c.CreateLink(ctx, test.req)
+		if status.Code(err) != test.code || reason(err) != test.reason {
+			t.Errorf("Expected %v/%v for %v, received: %v/%v", test.code, test.reason, test.req, status.Code(err), reason(err))
+		}
+	}
+}
+
+func TestDeleteAndList(t *testing.T) {
+	c := shortenerpb.NewLinkServiceClient(dial(t))
+	ctx := context.Background()
+
+	for _, url := range []string{"http://first.com", "http://second.com"} {
+		_, err := c.CreateLink(ctx, &shortenerpb.CreateLinkRequest{Url: url, Owner: "cranki"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	list, err := c.ListLinks(ctx, &shortenerpb.ListLinksRequest{Owner: "cranki"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(list.Links) != 2 || list.Limit != 50 {
+		t.Fatalf("Expected 2 links with limit 50, received: %v", list)
+	}
+
+	_, err = c.DeleteLink(ctx, &shortenerpb.DeleteLinkRequest{Id: list.Links[0].Id})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = c.DeleteLink(ctx, &shortenerpb.DeleteLinkRequest{Id: list.Links[0].Id})
+	if status.Code(err) != codes.NotFound || reason(err) != "not_found" {
+		t.Errorf("Expected not found, received: %v", err)
+	}
+
+	_, err = c.ResolveLink(ctx, &shortenerpb.ResolveLinkRequest{Id: list.Links[0].Id})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected not found, received: %v", err)
+	}
+
+	_, err = c.ListLinks(ctx, &shortenerpb.ListLinksRequest{Limit: 5000})
+	if status.Code(err) != codes.InvalidArgument || reason(err) != "invalid_parameter" {
+		t.Errorf("Expected invalid argument, received: %v", err)
+	}
+}
+
+func TestHealthAndReflection(t *testing.T) {
+	conn := dial(t)
+	ctx := context.Background()
+
+	health, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "shortener.v1.LinkService"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if health.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Errorf("Expected serving status, received: %v", health.Status)
+	}
+
+	stream, err := grpc_reflection_v1.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err = stream.Send(&grpc_reflection_v1.ServerReflectionRequest{
+		MessageRequest: &grpc_reflection_v1.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	services := map[string]bool{}
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		services[s.Name] = true
+	}
+
+	if !services["shortener.v1.LinkService"] || !services["grpc.health.v1.Health"] {
+		t.Errorf("Expected link and health services, received: %v", services)
+	}
+}
+
+func TestResolveProtected(t *testing.T) {
+	c := shortenerpb.NewLinkServiceClient(dial(t))
+	ctx := context.Background()
+
+	link, err := c.CreateLink(ctx, &shortenerpb.CreateLinkRequest{Id: "secret", Url: "http://testurl.com", Password: "s3cr3t"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !link.Protected {
+		t.Errorf("Expected protected link, received: %v", link)
+	}
+
+	tests := []struct {
+		password string
+		code     codes.Code
+		reason   string
+	}{
+		{"", codes.Unauthenticated, "password_required"},
+		{"wrong", codes.PermissionDenied, "password_incorrect"},
+		{"s3cr3t", codes.OK, ""},
+	}
+
+	for _, test := range tests {
+		_, err := c.ResolveLink(ctx, &shortenerpb.ResolveLinkRequest{Id: "secret", Password: test.password})
+		if status.Code(err) != test.code || reason(err) != test.reason {
+			t.Errorf("Expected %v/%v for %q, received: %v/%v", test.code, test.reason, test.password, status.Code(err), reason(err))
+		}
+	}
+}
+
+func TestResolveMaxClicks(t *testing.T) {
+	c := shortenerpb.NewLinkServiceClient(dial(t))
+	ctx := context.Background()
+
+	link, err := c.CreateLink(ctx, &shortenerpb.CreateLinkRequest{Id: "single", Url: "http://testurl.com", MaxClicks: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if link.MaxClicks != 1 || link.RemainingClicks != 1 {
+		t.Errorf("Expected 1 remaining click, received: %v", link)
+	}
+
+	_, err = c.ResolveLink(ctx, &shortenerpb.ResolveLinkRequest{Id: "single"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = c.ResolveLink(ctx, &shortenerpb.ResolveLinkRequest{Id: "single"})
+	if status.Code(err) != codes.FailedPrecondition || reason(err) != "link_exhausted" {
+		t.Errorf("Expected exhausted link, received: %v", err)
+	}
+}
+
+func TestResolveNotActive(t *testing.T) {
+	c := shortenerpb.NewLinkServiceClient(dial(t))
+	ctx := context.Background()
+
+	notBefore := timestamppb.New(time.Now().Add(time.Hour))
+	link, err := c.CreateLink(ctx, &shortenerpb.CreateLinkRequest{Id: "launch", Url: "http://testurl.com", NotBefore: notBefore})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if link.NotBefore.AsTime().Unix() != notBefore.AsTime().Unix() {
+		t.Errorf("Expected not_before %v, received: %v", notBefore.AsTime(), link.NotBefore.AsTime())
+	}
+
+	_, err = c.ResolveLink(ctx, &shortenerpb.ResolveLinkRequest{Id: "launch"})
+	if status.Code(err) != codes.FailedPrecondition || reason(err) != "link_not_active" {
+		t.Errorf("Expected link which is not active, received: %v", err)
+	}
+}
+
+func TestResolveTargets(t *testing.T) {
+	c := shortenerpb.NewLinkServiceClient(dial(t))
+	ctx := context.Background()
+
+	targets := map[string]string{"android": "https://play.google.com/store/apps/details?id=app"}
+	link, err := c.CreateLink(ctx, &shortenerpb.CreateLinkRequest{Id: "appins", Url: "http://testurl.com", Targets: targets})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if link.Targets["android"] != targets["android"] {
+		t.Errorf("Expected targets %v, received: %v", targets, link.Targets)
+	}
+
+	tests := []struct {
+		userAgent string
+		url       string
+	}{
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8)", targets["android"]},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)", "http://testurl.com"},
+		{"", "http://testurl.com"},
+	}
+
+	for _, test := range tests {
+		resolved, err := c.ResolveLink(ctx, &shortenerpb.ResolveLinkRequest{Id: "appins", UserAgent: test.userAgent})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if resolved.Url != test.url {
+			t.Errorf("%v: expected %v, received: %v", test.userAgent, test.url, resolved.Url)
+		}
+	}
+
+	_, err = c.CreateLink(ctx, &shortenerpb.CreateLinkRequest{Url: "http://anothertesturl.com", Targets: map[string]string{"tv": "http://tv.com"}})
+	if status.Code(err) != codes.InvalidArgument || reason(err) != "target_invalid" {
+		t.Errorf("Expected invalid target, received: %v", err)
+	}
+}
+
+func TestResolveVariants(t *testing.T) {
+	c := shortenerpb.NewLinkServiceClient(dial(t))
+	ctx := context.Background()
+
+	link, err := c.CreateLink(ctx, &shortenerpb.CreateLinkRequest{
+		Id:             "splits",
+		Url:            "http://testurl.com",
+		Variants:       []*shortenerpb.Variant{{Url: "http://testurl.com/a"}, {Url: "http://testurl.com/b", Weight: 3}},
+		StickyVariants: true,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(link.Variants) != 2 || link.Variants[0].Name != "a" || link.Variants[1].Weight != 3 {
+		t.Errorf("Unexpected variants: %v", link.Variants)
+	}
+
+	resolved, err := c.ResolveLink(ctx, &shortenerpb.ResolveLinkRequest{Id: "splits"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if resolved.Url != "http://testurl.com/"+resolved.Variant {
+		t.Errorf("Expected url of variant %v, received: %v", resolved.Variant, resolved.Url)
+	}
+
+	for i := 0; i < 10; i++ {
+		again, err := c.ResolveLink(ctx, &shortenerpb.ResolveLinkRequest{Id: "splits", Variant: resolved.Variant})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if again.Variant != resolved.Variant {
+			t.Fatalf("Expected sticky variant %v, received: %v", resolved.Variant, again.Variant)
+		}
+	}
+
+	link, err = c.GetLink(ctx, &shortenerpb.GetLinkRequest{Id: "splits"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if link.Variants[0].ClickCount+link.Variants[1].ClickCount != 11 {
+		t.Errorf("Expected 11 variant clicks, received: %v", link.Variants)
+	}
+}
+
+func TestResolveQuery(t *testing.T) {
+	c := shortenerpb.NewLinkServiceClient(dial(t))
+	ctx := context.Background()
+
+	link, err := c.CreateLink(ctx, &shortenerpb.CreateLinkRequest{
+		Id:             "cranki",
+		Url:            "http://testurl.com/?a=1#top",
+		ForwardQuery:   true,
+		Utm:            map[string]string{"utm_medium": "grpc"},
+		RedirectStatus: 302,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if link.RedirectStatus != 302 {
+		t.Errorf("Expected redirect status 302, received: %v", link.RedirectStatus)
+	}
+
+	resolved, err := c.ResolveLink(ctx, &shortenerpb.ResolveLinkRequest{Id: "cranki", Query: "a=2&b=%C3%A9"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if expected := "http://testurl.com/?a=1&b=%C3%A9&utm_medium=grpc#top"; resolved.Url != expected {
+		t.Errorf("Expected %v, received: %v", expected, resolved.Url)
+	}
+}
+
+func TestWorkspaces(t *testing.T) {
+	registry, err := workspace.New([]workspace.Workspace{{ID: "acme", Hosts: []string{"go.acme.com"}, APIKeys: []string{workspace.HashKey("acme-key")}}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	c := shortenerpb.NewLinkServiceClient(dialWith(t, rpc.Options{Workspaces: registry}))
+	acme := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer acme-key")
+
+	_, err = c.CreateLink(acme, &shortenerpb.CreateLinkRequest{Id: "cranki", Url: "http://testurl.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = c.GetLink(acme, &shortenerpb.GetLinkRequest{Id: "cranki"})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	_, err = c.GetLink(context.Background(), &shortenerpb.GetLinkRequest{Id: "cranki"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound in the default workspace, received: %v", err)
+	}
+
+	other := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer other-key")
+	_, err = c.ResolveLink(other, &shortenerpb.ResolveLinkRequest{Id: "cranki"})
+	if status.Code(err) != codes.Unauthenticated || reason(err) != "api_key_invalid" {
+		t.Errorf("Expected Unauthenticated with api_key_invalid, received: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/georgiv/url-shortener/server/service"
+	"github.com/georgiv/url-shortener/server/workspace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// links returns the link service of the workspace of the call,
+// resolved by the API key sent as bearer authorization metadata
+// or by the authority, which may be branded domain as well. In
+// case managed is set, the workspaces with API keys require one
+// of them
+func (server *linkServer) links(ctx context.Context, managed bool) (*service.Service, error) {
+	var key, host string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) != 0 {
+			key = workspace.BearerKey(values[0])
+		}
+		if values := md.Get(":authority"); len(values) != 0 {
+			host = values[0]
+		}
+	}
+
+	ws, err := server.workspaces.Resolve(key, host)
+	if err != nil {
+		return nil, withDetails(status.New(codes.Unauthenticated, "API key does not belong to any workspace"),
+			&errdetails.ErrorInfo{Reason: "api_key_invalid", Domain: errorDomain})
+	}
+
+	if managed && ws.RequiresKey() && key == "" {
+		return nil, withDetails(status.New(codes.Unauthenticated, fmt.Sprintf("Managing the links of workspace %v requires API key", ws.ID)),
+			&errdetails.ErrorInfo{Reason: "api_key_required", Domain: errorDomain})
+	}
+
+	if ws.ID == "" && key == "" {
+		linkService, _, err := server.linkService.Host(host)
+		if err != nil {
+			return nil, server.internalError("Error while retrieving domain %v: %v", host, err)
+		}
+
+		return linkService, nil
+	}
+
+	return server.linkService.Workspace(ws), nil
+}
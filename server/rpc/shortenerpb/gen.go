@@ -0,0 +1,7 @@
+// Package shortenerpb contains the Go code generated from
+// shortener.proto with protoc-gen-go and protoc-gen-go-grpc.
+//
+// Copyright 2019 cranki. All rights reserved.
+package shortenerpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative shortener.proto
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Machine-readable codes of the errors returned by Resolve
+// when the link cannot be accessed
+const (
+	CodePasswordRequired  = "password_required"
+	CodePasswordIncorrect = "password_incorrect"
+	CodeTooManyAttempts   = "too_many_attempts"
+	CodeLinkExhausted     = "link_exhausted"
+	CodeLinkNotActive     = "link_not_active"
+	CodeLinkExpired       = "link_expired"
+)
+
+// Defaults of the rate limiting of failed password attempts
+const (
+	DefaultMaxPasswordAttempts = 5
+	DefaultPasswordLockout     = time.Minute
+)
+
+// maxPasswordLength is the maximum length in bytes of the
+// password accepted by bcrypt
+const maxPasswordLength = 72
+
+// AccessError is returned by Resolve when the link exists,
+// but cannot be accessed with the provided credentials or not
+// anymore. RetryAfter is set when the access is temporary
+// blocked
+type AccessError struct {
+	Code       string
+	Detail     string
+	ID         string
+	RetryAfter time.Duration
+}
+
+func (err *AccessError) Error() string {
+	return err.Detail
+}
+
+// outsideWindow returns *AccessError in case the link is not
+// active at now. RetryAfter of links which have not been
+// activated yet is the time left until the activation
+func outsideWindow(link *db.Link, now time.Time) error {
+	switch link.State(now) {
+	case db.StatePending:
+		return &AccessError{
+			Code:       CodeLinkNotActive,
+			Detail:     fmt.Sprintf("ID %v is not active until %v", link.ID, link.NotBefore.UTC().Format(time.RFC3339)),
+			ID:         link.ID,
+			RetryAfter: link.NotBefore.Sub(now),
+		}
+	case db.StateExpired:
+		return &AccessError{
+			Code:   CodeLinkExpired,
+			Detail: fmt.Sprintf("ID %v expired at %v", link.ID, link.ExpiresAt.UTC().Format(time.RFC3339)),
+			ID:     link.ID,
+		}
+	default:
+		return nil
+	}
+}
+
+func exhausted(link *db.Link) error {
+	return &AccessError{
+		Code:   CodeLinkExhausted,
+		Detail: fmt.Sprintf("ID %v has reached its maximum of %v clicks", link.ID, link.MaxClicks),
+		ID:     link.ID,
+	}
+}
+
+func hashPassword(password string) (hash string, err error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return
+	}
+
+	hash = string(hashed)
+
+	return
+}
+
+// unlock checks the password of the protected link. Once the
+// failed attempts for the link reach the limit, all attempts
+// are rejected until the lockout passes
+func (service *Service) unlock(id string, hash string, password string) error {
+	if password == "" {
+		return &AccessError{
+			Code:   CodePasswordRequired,
+			Detail: fmt.Sprintf("ID %v is protected by password", id),
+			ID:     id,
+		}
+	}
+
+	if wait := service.attempts.blocked(service.attemptKey(id)); wait > 0 {
+		return &AccessError{
+			Code:       CodeTooManyAttempts,
+			Detail:     fmt.Sprintf("Too many failed password attempts for id %v. Try again later", id),
+			ID:         id,
+			RetryAfter: wait,
+		}
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		service.attempts.fail(service.attemptKey(id))
+
+		return &AccessError{
+			Code:   CodePasswordIncorrect,
+			Detail: fmt.Sprintf("Incorrect password for id %v", id),
+			ID:     id,
+		}
+	}
+
+	service.attempts.reset(service.attemptKey(id))
+
+	return nil
+}
+
+// attemptLimiter counts the failed password attempts per link
+// in fixed windows starting with the first failure
+type attemptLimiter struct {
+	mu       sync.Mutex
+	max      int
+	lockout  time.Duration
+	failures map[string]*failures
+}
+
+type failures struct {
+	count int
+	since time.Time
+}
+
+func newAttemptLimiter(max int, lockout time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		max:      max,
+		lockout:  lockout,
+		failures: make(map[string]*failures),
+	}
+}
+
+// blocked returns how long the attempts for id are rejected.
+// 0 means they are allowed
+func (limiter *attemptLimiter) blocked(id string) time.Duration {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	f, ok := limiter.failures[id]
+	if !ok || f.count < limiter.max {
+		return 0
+	}
+
+	wait := f.since.Add(limiter.lockout).Sub(time.Now())
+	if wait <= 0 {
+		delete(limiter.failures, id)
+		return 0
+	}
+
+	return wait
+}
+
+func (limiter *attemptLimiter) fail(id string) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := time.Now()
+
+	f, ok := limiter.failures[id]
+	if !ok || now.Sub(f.since) >= limiter.lockout {
+		limiter.purge(now)
+		f = &failures{since: now}
+		limiter.failures[id] = f
+	}
+
+	f.count++
+}
+
+func (limiter *attemptLimiter) reset(id string) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	delete(limiter.failures, id)
+}
+
+// purge drops the windows which have already passed, so the
+// failures of abandoned links do not pile up
+func (limiter *attemptLimiter) purge(now time.Time) {
+	for id, f := range limiter.failures {
+		if now.Sub(f.since) >= limiter.lockout {
+			delete(limiter.failures, id)
+		}
+	}
+}
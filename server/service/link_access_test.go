@@ -0,0 +1,36 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttemptLimiter(t *testing.T) {
+	limiter := newAttemptLimiter(2, 50*time.Millisecond)
+
+	limiter.fail("secret")
+	if wait := limiter.blocked("secret"); wait != 0 {
+		t.Errorf("Expected attempts allowed after 1 failure, blocked for %v", wait)
+	}
+
+	limiter.fail("secret")
+	if wait := limiter.blocked("secret"); wait <= 0 {
+		t.Errorf("Expected attempts blocked after 2 failures")
+	}
+	if wait := limiter.blocked("other"); wait != 0 {
+		t.Errorf("Expected attempts for other link allowed, blocked for %v", wait)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if wait := limiter.blocked("secret"); wait != 0 {
+		t.Errorf("Expected attempts allowed after lockout, blocked for %v", wait)
+	}
+
+	limiter.fail("secret")
+	limiter.reset("secret")
+	limiter.fail("secret")
+	if wait := limiter.blocked("secret"); wait != 0 {
+		t.Errorf("Expected failures cleared by reset, blocked for %v", wait)
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/georgiv/url-shortener/server/policy"
+)
+
+// defaultPorts are the ports dropped from the urls of the
+// respective schemes
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// canonicalURL returns the normalized form of the url used for
+// detecting duplicates. The scheme and the host are lowercased,
+// the default port and the trailing dot of the host are dropped,
+// international hosts are converted to punycode, percent-encoded
+// unreserved characters are decoded and the remaining escapes
+// are uppercased. The empty path and the root path are the same.
+// In case sortQuery is set, the query parameters are sorted by
+// their names, keeping the order of repeated ones
+func canonicalURL(rawURL string, sortQuery bool) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Opaque != "" {
+		return u.String(), nil
+	}
+
+	host := policy.CanonicalHost(u.Hostname())
+	if port := u.Port(); port != "" && port != defaultPorts[u.Scheme] {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	path := normalizeEscapes(u.EscapedPath())
+	if path == "/" {
+		path = ""
+	}
+
+	query := normalizeEscapes(u.RawQuery)
+	if sortQuery && query != "" {
+		params := strings.Split(query, "&")
+		sort.SliceStable(params, func(i, j int) bool {
+			return paramName(params[i]) < paramName(params[j])
+		})
+		query = strings.Join(params, "&")
+	}
+
+	var b strings.Builder
+	b.WriteString(u.Scheme)
+	b.WriteString("://")
+	if u.User != nil {
+		b.WriteString(u.User.String())
+		b.WriteByte('@')
+	}
+	b.WriteString(host)
+	b.WriteString(path)
+	if query != "" {
+		b.WriteByte('?')
+		b.WriteString(query)
+	}
+	if u.Fragment != "" {
+		b.WriteByte('#')
+		b.WriteString(normalizeEscapes(u.EscapedFragment()))
+	}
+
+	return b.String(), nil
+}
+
+// paramName returns the name of the query parameter
+func paramName(param string) string {
+	if i := strings.IndexByte(param, '='); i >= 0 {
+		return param[:i]
+	}
+
+	return param
+}
+
+// normalizeEscapes decodes the percent-encoded unreserved
+// characters of RFC 3986 and uppercases the remaining escapes
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	const hex = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+		}
+		i += 2
+	}
+
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+)
+
+// Modes of handling urls pointing to short links of the service
+// itself, set by Options.SelfLinks
+const (
+	// SelfLinksReject rejects the urls, as following them would
+	// only add redirects or loop
+	SelfLinksReject = "reject"
+
+	// SelfLinksResolve replaces the urls with the final
+	// destination of the chain of short links. Links which do
+	// not redirect every client to their url are rejected
+	SelfLinksResolve = "resolve"
+)
+
+// DefaultMaxChainDepth is the maximum number of short links
+// followed while resolving used when Options.MaxChainDepth is
+// not set
+const DefaultMaxChainDepth = 5
+
+// resolveSelfLinks applies the SelfLinks mode to the url, the
+// platform targets and the variants of the request, replacing
+// them with their final destinations in resolve mode. The urls
+// which cannot be accepted are returned as field errors
+func (service *Service) resolveSelfLinks(req *Request) (errs []FieldError, err error) {
+	resolve := func(field string, rawURL string) string {
+		if err != nil {
+			return rawURL
+		}
+
+		final, detail, resolveErr := service.followChain(rawURL)
+		if resolveErr != nil {
+			err = resolveErr
+			return rawURL
+		}
+
+		if detail != "" {
+			errs = append(errs, FieldError{
+				Field:  field,
+				Code:   CodeSelfReference,
+				Detail: detail,
+			})
+			return rawURL
+		}
+
+		return final
+	}
+
+	req.URL = resolve("url", req.URL)
+
+	if len(req.Targets) != 0 {
+		platforms := make([]string, 0, len(req.Targets))
+		for platform := range req.Targets {
+			platforms = append(platforms, platform)
+		}
+		sort.Strings(platforms)
+
+		targets := make(map[string]string, len(req.Targets))
+		for _, platform := range platforms {
+			targets[platform] = resolve(fmt.Sprintf("targets.%v", platform), req.Targets[platform])
+		}
+		req.Targets = targets
+	}
+
+	if len(req.Variants) != 0 {
+		variants := append(req.Variants[:0:0], req.Variants...)
+		for i := range variants {
+			variants[i].URL = resolve(fmt.Sprintf("variants[%v].url", i), variants[i].URL)
+		}
+		req.Variants = variants
+	}
+
+	return
+}
+
+// followChain follows the url through the short links of the
+// service until it leaves the own hosts. Only links which
+// currently redirect every client to their url are followed, so
+// links which are protected, not active, expired or exhausted,
+// or have platform targets or variants, cannot be accepted. In
+// case the url cannot be accepted, the reason is returned as
+// detail
+func (service *Service) followChain(rawURL string) (final string, detail string, err error) {
+	final = rawURL
+	now := time.Now()
+
+	for depth := 0; ; depth++ {
+		id, dbWorker, own, ownErr := service.ownLink(final)
+		if ownErr != nil {
+			err = ownErr
+			return
+		}
+
+		if !own {
+			return
+		}
+
+		switch {
+		case id == "":
+			detail = fmt.Sprintf("Url %v points to the service itself", final)
+		case service.options.SelfLinks != SelfLinksResolve:
+			detail = fmt.Sprintf("Url %v is short link of the service", final)
+		case depth >= service.options.MaxChainDepth:
+			detail = fmt.Sprintf("Url %v is chain of more than %v short links", rawURL, service.options.MaxChainDepth)
+		}
+		if detail != "" {
+			return
+		}
+
+		link, getErr := dbWorker.Get(id)
+		if getErr != nil {
+			err = getErr
+			return
+		}
+
+		switch {
+		case link == nil:
+			detail = fmt.Sprintf("Url %v is short link which does not exist", final)
+		case link.PasswordHash != "":
+			detail = fmt.Sprintf("Url %v is protected short link", final)
+		case link.State(now) == db.StatePending:
+			detail = fmt.Sprintf("Url %v is short link which is not active yet", final)
+		case link.State(now) == db.StateExpired:
+			detail = fmt.Sprintf("Url %v is short link which has expired", final)
+		case link.Exhausted():
+			detail = fmt.Sprintf("Url %v is short link which has reached its maximum clicks", final)
+		case len(link.Targets) != 0 || len(link.Variants) != 0:
+			detail = fmt.Sprintf("Url %v is short link with platform targets or variants", final)
+		}
+		if detail != "" {
+			return
+		}
+
+		final = link.URL
+	}
+}
+
+// ownLink reports whether the url points to one of the own
+// hosts or branded domains of the service and returns the id of
+// the short link it points to, which is empty for other resources
+// of the service, along with the DB worker of the links served by
+// the host
+func (service *Service) ownLink(rawURL string) (id string, dbWorker db.Worker, own bool, err error) {
+	u, parseErr := url.Parse(rawURL)
+	if parseErr != nil {
+		return
+	}
+
+	host := normalizeHost(u.Hostname())
+	dbWorker, own = service.ownHosts[host]
+	if !own {
+		domain, domainErr := service.Domain(host)
+		if domainErr != nil || domain == nil {
+			err = domainErr
+			return
+		}
+
+		dbWorker, own = service.dbWorker.Workspace(domain.Host, 0), true
+	}
+
+	const prefix = "/api/urls/"
+	i := strings.LastIndex(u.Path, prefix)
+	if i < 0 {
+		return
+	}
+
+	// the preview page leads to the same link
+	rest := strings.TrimSuffix(u.Path[i+len(prefix):], "+")
+	if rest != "" && !strings.Contains(rest, "/") {
+		id = rest
+	}
+
+	return
+}
+
+// normalizeHost returns the lowercase host without the port
+// and the trailing dot. Full urls are accepted as well, e.g. the
+// public url of the frontend
+func normalizeHost(host string) string {
+	if !strings.Contains(host, "://") {
+		host = "//" + host
+	}
+
+	if u, err := url.Parse(host); err == nil {
+		host = u.Hostname()
+	}
+
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
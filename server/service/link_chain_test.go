@@ -0,0 +1,99 @@
+package service_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/service"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+func TestCreateSelfLinks(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "https://google.com")
+	dbWorker.Register("hopone", "https://s.example.com/api/urls/cranki")
+	dbWorker.Register("hoptwo", "https://S.example.com./prefix/api/urls/hopone+")
+	dbWorker.Create(&db.Link{ID: "launch", URL: "https://launch.example.com", NotBefore: time.Now().Add(time.Hour)})
+	dbWorker.Create(&db.Link{ID: "closed", URL: "https://closed.example.com", ExpiresAt: time.Now().Add(-time.Hour)})
+	dbWorker.Create(&db.Link{ID: "usedup", URL: "https://usedup.example.com", MaxClicks: 1})
+	dbWorker.Click("usedup")
+	dbWorker.Create(&db.Link{ID: "mobile", URL: "https://desktop.example.com", Targets: map[string]string{"ios": "https://ios.example.com"}})
+	dbWorker.Create(&db.Link{ID: "abtest", URL: "https://a.example.com", Variants: []db.Variant{{URL: "https://a.example.com", Weight: 1}, {URL: "https://b.example.com", Weight: 1}}})
+	dbWorker.Register("hopend", "https://s.example.com/api/urls/closed")
+
+	reject := service.New(dbWorker, service.Options{OwnHosts: []string{"https://s.example.com", "short.example.com:8080"}})
+	resolve := service.New(dbWorker, service.Options{OwnHosts: []string{"s.example.com"}, SelfLinks: service.SelfLinksResolve, MaxChainDepth: 2})
+
+	_, err := resolve.Create(service.Request{ID: "locked", URL: "https://example.com", Password: "secret1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		service *service.Service
+		req     service.Request
+		url     string
+		fields  []string
+	}{
+		{reject, service.Request{URL: "https://other.example.com/api/urls/cranki"}, "https://other.example.com/api/urls/cranki", nil},
+		{reject, service.Request{URL: "https://s.example.com/api/urls/cranki"}, "", []string{"url"}},
+		{reject, service.Request{URL: "http://short.example.com/api/urls/cranki"}, "", []string{"url"}},
+		{reject, service.Request{URL: "https://google.com", Targets: map[string]string{"ios": "https://s.example.com/api/urls/cranki"}}, "", []string{"targets.ios"}},
+		{resolve, service.Request{URL: "https://s.example.com/api/urls/hopone"}, "https://google.com", nil},
+		{resolve, service.Request{URL: "https://s.example.com/api/urls/cranki"}, "https://google.com", nil},
+		{resolve, service.Request{URL: "https://s.example.com/api/urls/hoptwo"}, "", []string{"url"}},
+		{resolve, service.Request{URL: "https://s.example.com/api/urls/unknwn"}, "", []string{"url"}},
+		{resolve, service.Request{URL: "https://s.example.com/api/urls/locked"}, "", []string{"url"}},
+		{resolve, service.Request{URL: "https://s.example.com/api/urls/launch"}, "", []string{"url"}},
+		{resolve, service.Request{URL: "https://s.example.com/api/urls/closed"}, "", []string{"url"}},
+		{resolve, service.Request{URL: "https://s.example.com/api/urls/hopend"}, "", []string{"url"}},
+		{resolve, service.Request{URL: "https://s.example.com/api/urls/usedup"}, "", []string{"url"}},
+		{resolve, service.Request{URL: "https://s.example.com/api/urls/mobile"}, "", []string{"url"}},
+		{resolve, service.Request{URL: "https://s.example.com/api/urls/abtest"}, "", []string{"url"}},
+		{resolve, service.Request{URL: "https://s.example.com/api/urls/cranki/qr"}, "", []string{"url"}},
+		{resolve, service.Request{URL: "https://s.example.com/api/v2/links"}, "", []string{"url"}},
+		{resolve, service.Request{URL: "https://google.com", Variants: []db.Variant{{URL: "https://bing.com"}, {URL: "https://s.example.com/api/urls/hoptwo"}}}, "", []string{"variants[1].url"}},
+	}
+
+	for _, test := range tests {
+		link, err := test.service.Create(test.req)
+		// the final destinations of existing links are registered
+		// already, so resolved urls conflict with them
+		if e, ok := err.(*service.ConflictError); ok && test.fields == nil {
+			if e.URL != test.url {
+				t.Errorf("Expected conflict on %v, received %v", test.url, e.URL)
+			}
+			continue
+		}
+
+		if test.fields == nil {
+			if err != nil {
+				t.Errorf("Unexpected error for %v: %v", test.req.URL, err)
+			} else if link.URL != test.url {
+				t.Errorf("Expected url %v, received %v", test.url, link.URL)
+			}
+			continue
+		}
+
+		perr, ok := err.(*service.PolicyError)
+		if !ok {
+			t.Errorf("Expected policy error for %+v, received: %v", test.req, err)
+			continue
+		}
+
+		if perr.Code() != service.CodeSelfReference {
+			t.Errorf("Expected code %v, received %v", service.CodeSelfReference, perr.Code())
+		}
+
+		var fields []string
+		for _, e := range perr.Errors {
+			fields = append(fields, e.Field)
+		}
+
+		if strings.Join(fields, ",") != strings.Join(test.fields, ",") {
+			t.Errorf("Expected rejected %v for %+v, received: %v", test.fields, test.req, fields)
+		}
+	}
+}
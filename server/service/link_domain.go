@@ -0,0 +1,232 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/policy"
+	"github.com/georgiv/url-shortener/server/workspace"
+)
+
+// Machine-readable codes of the errors returned when registering
+// domains
+const (
+	CodeHostInvalid         = "host_invalid"
+	CodeNotFoundPageInvalid = "not_found_page_invalid"
+	CodeDomainTaken         = "domain_taken"
+)
+
+// DefaultDomainTTL is the time for which the domains are cached
+// used when Options.DomainTTL is not set
+const DefaultDomainTTL = 30 * time.Second
+
+// MaxNotFoundPageSize is the maximum size in bytes of the not
+// found page of a domain
+const MaxNotFoundPageSize = 32 * 1024
+
+const (
+	// maxHostLength is the length of the workspace column which
+	// the links of the domains are stored under
+	maxHostLength = 64
+
+	// maxCachedDomains bounds the cache, as it holds the misses
+	// of arbitrary hosts as well
+	maxCachedDomains = 10000
+)
+
+var hostPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// DomainRequest represents the input for registering branded
+// domain
+type DomainRequest struct {
+	Host         string
+	NotFoundPage string
+}
+
+// CreateDomain validates the request and registers new branded
+// domain, whose links form separate namespace. In case of invalid
+// request *ValidationError is returned and in case the host is
+// already registered or serves the service *ConflictError is
+// returned
+func (service *Service) CreateDomain(req DomainRequest) (domain *db.Domain, err error) {
+	host := policy.CanonicalHost(normalizeHost(req.Host))
+
+	var errs []FieldError
+	if len(host) > maxHostLength || !hostPattern.MatchString(host) {
+		errs = append(errs, FieldError{
+			Field:  "host",
+			Code:   CodeHostInvalid,
+			Detail: fmt.Sprintf("Invalid host: %q. It should be domain name of at most %v characters", req.Host, maxHostLength),
+		})
+	}
+
+	if len(req.NotFoundPage) > MaxNotFoundPageSize {
+		errs = append(errs, FieldError{
+			Field:  "not_found_page",
+			Code:   CodeNotFoundPageInvalid,
+			Detail: fmt.Sprintf("Invalid not found page: it is %v bytes long. It should be at most %v bytes long", len(req.NotFoundPage), MaxNotFoundPageSize),
+		})
+	}
+
+	if len(errs) != 0 {
+		err = &ValidationError{Errors: errs}
+		return
+	}
+
+	if _, own := service.ownHosts[host]; own {
+		err = &ConflictError{
+			Code:   CodeDomainTaken,
+			Detail: fmt.Sprintf("Host %v already serves the service", host),
+		}
+		return
+	}
+
+	existing, err := service.dbWorker.GetDomain(host)
+	if err != nil {
+		return
+	}
+
+	if existing != nil {
+		err = &ConflictError{
+			Code:   CodeDomainTaken,
+			Detail: fmt.Sprintf("Domain %v already registered", host),
+		}
+		return
+	}
+
+	created := &db.Domain{
+		Host:         host,
+		NotFoundPage: req.NotFoundPage,
+	}
+
+	err = service.dbWorker.CreateDomain(created)
+	if err != nil {
+		return
+	}
+
+	service.domains.forget(host)
+	domain = created
+
+	return
+}
+
+// Domain returns the branded domain registered for the host. The
+// domains are cached for Options.DomainTTL, so the changes made
+// by other instances take effect after it. In case there is no
+// such domain nil is returned along with nil value for an error
+func (service *Service) Domain(host string) (domain *db.Domain, err error) {
+	host = policy.CanonicalHost(normalizeHost(host))
+	if host == "" {
+		return
+	}
+
+	domain, ok := service.domains.get(host)
+	if ok {
+		return
+	}
+
+	domain, err = service.dbWorker.GetDomain(host)
+	if err != nil {
+		return
+	}
+
+	service.domains.put(host, domain)
+
+	return
+}
+
+// Domains returns all branded domains ordered by their hosts
+func (service *Service) Domains() (domains []*db.Domain, err error) {
+	return service.dbWorker.ListDomains()
+}
+
+// DeleteDomain removes the branded domain registered for the
+// host. The links of the domain are kept until they expire.
+// Returns false in case there is no such domain
+func (service *Service) DeleteDomain(host string) (deleted bool, err error) {
+	host = policy.CanonicalHost(normalizeHost(host))
+
+	deleted, err = service.dbWorker.DeleteDomain(host)
+	if err != nil {
+		return
+	}
+
+	service.domains.forget(host)
+
+	return
+}
+
+// Host returns Service managing the links served by the host.
+// For the hosts of branded domains it manages the namespace of
+// the domain, which is returned as well. For the other hosts it
+// is the service itself and the domain is nil
+func (service *Service) Host(host string) (scoped *Service, domain *db.Domain, err error) {
+	domain, err = service.Domain(host)
+	if err != nil {
+		return
+	}
+
+	if domain == nil {
+		scoped = service
+		return
+	}
+
+	scoped = service.Workspace(&workspace.Workspace{ID: domain.Host})
+
+	return
+}
+
+// domainCache holds the domains looked up by their hosts,
+// including the hosts without domain. It is shared by the
+// copies of the service
+type domainCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedDomain
+}
+
+type cachedDomain struct {
+	domain  *db.Domain
+	expires time.Time
+}
+
+func newDomainCache(ttl time.Duration) *domainCache {
+	return &domainCache{
+		ttl:     ttl,
+		entries: make(map[string]cachedDomain),
+	}
+}
+
+func (cache *domainCache) get(host string) (domain *db.Domain, ok bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, ok := cache.entries[host]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	return entry.domain, true
+}
+
+func (cache *domainCache) put(host string, domain *db.Domain) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if len(cache.entries) >= maxCachedDomains {
+		cache.entries = make(map[string]cachedDomain)
+	}
+
+	cache.entries[host] = cachedDomain{domain: domain, expires: time.Now().Add(cache.ttl)}
+}
+
+func (cache *domainCache) forget(host string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	delete(cache.entries, host)
+}
@@ -0,0 +1,96 @@
+package service_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/georgiv/url-shortener/server/service"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+func TestCreateDomain(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "https://google.com")
+
+	linkService := service.New(dbWorker, service.Options{OwnHosts: []string{"s.example.com"}})
+
+	domain, err := linkService.CreateDomain(service.DomainRequest{Host: "Go.Team-A.io.", NotFoundPage: "<h1>Nope</h1>"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if domain.Host != "go.team-a.io" || domain.CreatedAt.IsZero() {
+		t.Errorf("Unexpected domain: %+v", domain)
+	}
+
+	tests := []struct {
+		req  service.DomainRequest
+		code string
+	}{
+		{service.DomainRequest{Host: "go.team-a.io"}, service.CodeDomainTaken},
+		{service.DomainRequest{Host: "s.example.com:8080"}, service.CodeDomainTaken},
+		{service.DomainRequest{Host: "localhost"}, service.CodeHostInvalid},
+		{service.DomainRequest{Host: "bad_host.io"}, service.CodeHostInvalid},
+		{service.DomainRequest{Host: strings.Repeat("a", 62) + ".io"}, service.CodeHostInvalid},
+		{service.DomainRequest{Host: "s.brand.com", NotFoundPage: strings.Repeat("a", service.MaxNotFoundPageSize+1)}, service.CodeNotFoundPageInvalid},
+	}
+
+	for _, test := range tests {
+		_, err := linkService.CreateDomain(test.req)
+		switch e := err.(type) {
+		case *service.ConflictError:
+			if e.Code != test.code {
+				t.Errorf("Expected %v for %v, received %v", test.code, test.req.Host, e.Code)
+			}
+		case *service.ValidationError:
+			if len(e.Errors) != 1 || e.Errors[0].Code != test.code {
+				t.Errorf("Expected %v for %v, received %v", test.code, test.req.Host, e.Errors)
+			}
+		default:
+			t.Errorf("Expected %v for %v, received %v", test.code, test.req.Host, err)
+		}
+	}
+
+	// the domain has its own namespace
+	scoped, found, err := linkService.Host("GO.team-a.io:443")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if found == nil || found.NotFoundPage != "<h1>Nope</h1>" {
+		t.Fatalf("Expected domain go.team-a.io, received %+v", found)
+	}
+
+	if link, _ := scoped.DB().Get("cranki"); link != nil {
+		t.Errorf("Expected no link cranki on the domain, received %v", link)
+	}
+
+	_, err = scoped.Create(service.Request{ID: "cranki", URL: "https://bing.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// short links of the domain are own links
+	_, err = linkService.Create(service.Request{URL: "https://go.team-a.io/api/urls/cranki"})
+	if e, ok := err.(*service.PolicyError); !ok || e.Code() != service.CodeSelfReference {
+		t.Errorf("Expected self reference error, received %v", err)
+	}
+
+	if other, domain, _ := linkService.Host("s.example.com"); other != linkService || domain != nil {
+		t.Errorf("Expected the service itself for its own host")
+	}
+
+	deleted, err := linkService.DeleteDomain("go.team-a.io")
+	if err != nil || !deleted {
+		t.Fatalf("Expected domain to be deleted, received %v, %v", deleted, err)
+	}
+
+	if _, domain, _ := linkService.Host("go.team-a.io"); domain != nil {
+		t.Errorf("Expected deleted domain not to be served, received %+v", domain)
+	}
+
+	domains, err := linkService.Domains()
+	if err != nil || len(domains) != 0 {
+		t.Errorf("Expected no domains, received %v, %v", domains, err)
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PolicyError is returned when valid urls of the request are
+// rejected by the url policy, e.g. for their scheme, their
+// private host or denied domain, or point to short links of
+// the service
+type PolicyError struct {
+	Errors []FieldError
+}
+
+// Code returns the code shared by all the errors, i.e.
+// CodeSelfReference when only self references are rejected, or
+// CodeURLBlocked otherwise
+func (err *PolicyError) Code() string {
+	for _, e := range err.Errors {
+		if e.Code != CodeSelfReference {
+			return CodeURLBlocked
+		}
+	}
+
+	if len(err.Errors) == 0 {
+		return CodeURLBlocked
+	}
+
+	return CodeSelfReference
+}
+
+func (err *PolicyError) Error() string {
+	details := make([]string, 0, len(err.Errors))
+	for _, e := range err.Errors {
+		details = append(details, e.Detail)
+	}
+
+	return strings.Join(details, "; ")
+}
+
+// checkPolicy checks the url, the platform targets and the
+// variants of the request, which should already be valid,
+// against the url policy and returns all the violations
+func (service *Service) checkPolicy(req Request) (errs []FieldError) {
+	check := func(field string, rawURL string) {
+		err := service.options.Policy.Check(rawURL)
+		if err != nil {
+			errs = append(errs, FieldError{
+				Field:  field,
+				Code:   CodeURLBlocked,
+				Detail: err.Error(),
+			})
+		}
+	}
+
+	check("url", req.URL)
+
+	platforms := make([]string, 0, len(req.Targets))
+	for platform := range req.Targets {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+
+	for _, platform := range platforms {
+		check(fmt.Sprintf("targets.%v", platform), req.Targets[platform])
+	}
+
+	for i, variant := range req.Variants {
+		check(fmt.Sprintf("variants[%v].url", i), variant.URL)
+	}
+
+	return
+}
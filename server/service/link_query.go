@@ -0,0 +1,152 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/georgiv/url-shortener/server/db"
+)
+
+// UTMParameters lists the parameters which links may append to
+// their targets by default
+var UTMParameters = []string{"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content"}
+
+const maxUTMValueLength = 255
+
+func (service *Service) validateUTM(utm map[string]string) (errs []FieldError) {
+	keys := make([]string, 0, len(utm))
+	for key := range utm {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := utm[key]
+
+		switch {
+		case !isUTMParameter(key):
+			errs = append(errs, FieldError{
+				Field:  fmt.Sprintf("utm.%v", key),
+				Code:   CodeUTMInvalid,
+				Detail: fmt.Sprintf("Invalid UTM parameter: %v. Supported parameters: %v", key, UTMParameters),
+			})
+		case len(value) == 0 || len(value) > maxUTMValueLength:
+			errs = append(errs, FieldError{
+				Field:  fmt.Sprintf("utm.%v", key),
+				Code:   CodeUTMInvalid,
+				Detail: fmt.Sprintf("Invalid value of %v: it should be 1 to %v characters long", key, maxUTMValueLength),
+			})
+		}
+	}
+
+	return
+}
+
+func isUTMParameter(key string) bool {
+	for _, p := range UTMParameters {
+		if p == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MergeQuery returns the target of the link with the incoming
+// query parameters of the short link and the default UTM
+// parameters of the link merged into its query. The rules are:
+//   - incoming parameters are forwarded only for links with
+//     ForwardQuery
+//   - in case the target and the incoming query set the same
+//     key, the values of the target are kept, unless the link has
+//     QueryOverride, in which case all the values of the target
+//     are replaced with the incoming ones
+//   - default UTM parameters are appended only in case neither
+//     the target nor the incoming query sets them
+//
+// The parameters of the target are kept verbatim in their order,
+// the added ones follow sorted by key. The fragment of the target
+// is kept at the end. Links without the settings get the target
+// unchanged
+func MergeQuery(link *db.Link, target string, incoming url.Values) string {
+	if !link.ForwardQuery && len(link.UTM) == 0 {
+		return target
+	}
+
+	base, fragment := target, ""
+	if i := strings.IndexByte(base, '#'); i >= 0 {
+		base, fragment = base[:i], base[i:]
+	}
+
+	path, rawQuery := base, ""
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path, rawQuery = path[:i], path[i+1:]
+	}
+
+	var pairs []string
+	stored := make(map[string]bool)
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		pairs = append(pairs, pair)
+		stored[queryKey(pair)] = true
+	}
+
+	added := url.Values{}
+	replaced := make(map[string]bool)
+
+	if link.ForwardQuery {
+		for key, values := range incoming {
+			if key == "" {
+				continue
+			}
+
+			if stored[key] {
+				if !link.QueryOverride {
+					continue
+				}
+				replaced[key] = true
+			}
+
+			added[key] = values
+		}
+	}
+
+	for key, value := range link.UTM {
+		if !stored[key] && added[key] == nil {
+			added.Set(key, value)
+		}
+	}
+
+	if len(added) == 0 {
+		return target
+	}
+
+	kept := make([]string, 0, len(pairs)+1)
+	for _, pair := range pairs {
+		if !replaced[queryKey(pair)] {
+			kept = append(kept, pair)
+		}
+	}
+	kept = append(kept, added.Encode())
+
+	return path + "?" + strings.Join(kept, "&") + fragment
+}
+
+// queryKey returns the decoded key of the key=value pair. Keys
+// which cannot be decoded are returned as they are
+func queryKey(pair string) string {
+	key := pair
+	if i := strings.IndexByte(key, '='); i >= 0 {
+		key = key[:i]
+	}
+
+	if unescaped, err := url.QueryUnescape(key); err == nil {
+		return unescaped
+	}
+
+	return key
+}
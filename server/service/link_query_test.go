@@ -0,0 +1,52 @@
+package service_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/service"
+)
+
+func TestMergeQuery(t *testing.T) {
+	forward := &db.Link{ForwardQuery: true}
+	override := &db.Link{ForwardQuery: true, QueryOverride: true}
+	utm := &db.Link{UTM: map[string]string{"utm_source": "news letter", "utm_medium": "email"}}
+	forwardUTM := &db.Link{ForwardQuery: true, UTM: map[string]string{"utm_source": "newsletter"}}
+
+	tests := []struct {
+		name     string
+		link     *db.Link
+		target   string
+		query    string
+		expected string
+	}{
+		{"disabled", &db.Link{}, "http://t.com/p?a=1", "b=2", "http://t.com/p?a=1"},
+		{"no query", forward, "http://t.com/p?a=1", "", "http://t.com/p?a=1"},
+		{"append", forward, "http://t.com/p", "b=2&a=1", "http://t.com/p?a=1&b=2"},
+		{"merge", forward, "http://t.com/p?z=0", "b=2", "http://t.com/p?z=0&b=2"},
+		{"trailing question mark", forward, "http://t.com/p?", "b=2", "http://t.com/p?b=2"},
+		{"conflict keeps target", forward, "http://t.com/p?a=1&c=3", "a=9&b=2", "http://t.com/p?a=1&c=3&b=2"},
+		{"conflict override", override, "http://t.com/p?a=1&c=3&a=2", "a=9&b=2", "http://t.com/p?c=3&a=9&b=2"},
+		{"multiple values", forward, "http://t.com/p", "a=1&a=2", "http://t.com/p?a=1&a=2"},
+		{"fragment", forward, "http://t.com/p?a=1#section-2", "b=2", "http://t.com/p?a=1&b=2#section-2"},
+		{"fragment with question mark", forward, "http://t.com/p#/route?x=1", "b=2", "http://t.com/p?b=2#/route?x=1"},
+		{"incoming fragment is not query", forward, "http://t.com/p", "b=2%23frag", "http://t.com/p?b=2%23frag"},
+		{"encoded target kept", forward, "http://t.com/a%20b?q=x%26y&s=caf%C3%A9", "b=2", "http://t.com/a%20b?q=x%26y&s=caf%C3%A9&b=2"},
+		{"encoded incoming", forward, "http://t.com/p", "q=a+b&r=%26%3D&u=%E2%9C%93", "http://t.com/p?q=a+b&r=%26%3D&u=%E2%9C%93"},
+		{"encoded key conflict", forward, "http://t.com/p?a%20b=1", "a+b=2", "http://t.com/p?a%20b=1"},
+		{"empty key skipped", forward, "http://t.com/p", "=1&b=2", "http://t.com/p?b=2"},
+		{"utm", utm, "http://t.com/p#top", "b=2", "http://t.com/p?utm_medium=email&utm_source=news+letter#top"},
+		{"utm kept by target", utm, "http://t.com/p?utm_source=ads", "", "http://t.com/p?utm_source=ads&utm_medium=email"},
+		{"utm replaced by incoming", forwardUTM, "http://t.com/p", "utm_source=twitter", "http://t.com/p?utm_source=twitter"},
+		{"utm with override", &db.Link{ForwardQuery: true, QueryOverride: true, UTM: map[string]string{"utm_source": "newsletter"}}, "http://t.com/p?utm_source=ads", "utm_source=twitter", "http://t.com/p?utm_source=twitter"},
+	}
+
+	for _, test := range tests {
+		incoming, _ := url.ParseQuery(test.query)
+
+		if merged := service.MergeQuery(test.link, test.target, incoming); merged != test.expected {
+			t.Errorf("%v: expected %v, received %v", test.name, test.expected, merged)
+		}
+	}
+}
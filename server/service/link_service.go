@@ -0,0 +1,746 @@
+// Package service provides the rules for registering and
+// managing URL aliases, shared by all the frontends of the
+// URL shortener (REST API, command line and gRPC).
+//
+// Copyright 2019 cranki. All rights reserved.
+package service
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/policy"
+	"github.com/georgiv/url-shortener/server/useragent"
+	"github.com/georgiv/url-shortener/server/workspace"
+)
+
+// Machine-readable codes of the errors returned by Service.
+// They are stable and clients may rely on them
+const (
+	CodeInvalidURL        = "invalid_url"
+	CodeURLTooLong        = "url_too_long"
+	CodeAliasInvalid      = "alias_invalid"
+	CodeTagInvalid        = "tag_invalid"
+	CodeOwnerInvalid      = "owner_invalid"
+	CodePasswordInvalid   = "password_invalid"
+	CodeMaxClicksInvalid  = "max_clicks_invalid"
+	CodeNotBeforeInvalid  = "not_before_invalid"
+	CodeExpirationInvalid = "expiration_invalid"
+	CodeTargetInvalid     = "target_invalid"
+	CodeVariantInvalid    = "variant_invalid"
+	CodeUTMInvalid        = "utm_invalid"
+	CodeRedirectInvalid   = "redirect_status_invalid"
+	CodeURLBlocked        = "url_blocked"
+	CodeSelfReference     = "url_self_reference"
+	CodeAliasTaken        = "alias_taken"
+	CodeURLTaken          = "url_taken"
+	CodeQuotaExceeded     = "quota_exceeded"
+	CodeInvalidParameter  = "invalid_parameter"
+)
+
+// DefaultMaxURLLength is the maximum length of the registered
+// URL used when Options.MaxURLLength is not set
+const DefaultMaxURLLength = 2048
+
+// RedirectStatuses lists the status codes which links may
+// redirect with
+var RedirectStatuses = []int{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+}
+
+// ValidRedirectStatus reports whether links may redirect with
+// the status
+func ValidRedirectStatus(status int) bool {
+	for _, s := range RedirectStatuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Paging limits of List. DefaultListLimit is used when the
+// filter does not specify limit
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 1000
+)
+
+const (
+	idLength       = 6
+	maxTags        = 16
+	maxTagLength   = 64
+	maxOwnerLength = 255
+)
+
+// Request represents the parameters of a new link. In case
+// ID is empty, one is generated from the URL. In case Password
+// is not empty, the link is resolved only with the password.
+// In case MaxClicks is positive, the link is resolved at most
+// MaxClicks times. Non-zero NotBefore and ExpiresAt bound the
+// window in which the link is resolved. In case ExpiresAt is
+// zero, the default expiration period is counted from the
+// activation. Targets maps platforms to the urls they are
+// redirected to instead of URL. In case Variants are set, the
+// traffic is split across them by their weights instead of URL.
+// Variants without name are named by their position (a, b, c...)
+// and variants without weight get weight 1. StickyVariants keeps
+// the variant of returning visitors. ForwardQuery, QueryOverride
+// and UTM control the query of the redirect as described in
+// MergeQuery. RedirectStatus is one of RedirectStatuses, 0 means
+// the default of the frontend. Preview makes the frontends show
+// the preview page before every redirect
+type Request struct {
+	ID        string
+	URL       string
+	Tags      []string
+	Owner     string
+	Password  string
+	MaxClicks int64
+	NotBefore time.Time
+	ExpiresAt time.Time
+	Targets   map[string]string
+
+	Variants       []db.Variant
+	StickyVariants bool
+
+	ForwardQuery  bool
+	QueryOverride bool
+	UTM           map[string]string
+
+	RedirectStatus int
+	Preview        bool
+}
+
+// FieldError represents validation error of single field
+// of the request
+type FieldError struct {
+	Field  string `json:"field"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+// ValidationError is returned when the request breaks
+// some of the rules
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (err *ValidationError) Error() string {
+	details := make([]string, 0, len(err.Errors))
+	for _, e := range err.Errors {
+		details = append(details, e.Detail)
+	}
+
+	return strings.Join(details, "; ")
+}
+
+// ConflictError is returned when the id or the url of the
+// request is already registered. ID and URL refer to the
+// existing link
+type ConflictError struct {
+	Code   string
+	Detail string
+	ID     string
+	URL    string
+}
+
+func (err *ConflictError) Error() string {
+	return err.Detail
+}
+
+// Options represents the tunable rules of Service
+type Options struct {
+	// MaxURLLength is the maximum length of the registered URL.
+	// In case 0 or negative value is set, DefaultMaxURLLength
+	// is used
+	MaxURLLength int
+
+	// Logger is used for reporting errors which do not fail
+	// the operation, e.g. failed click counting. In case it is
+	// nil, the standard logger is used
+	Logger *log.Logger
+
+	// MaxPasswordAttempts is the number of failed password
+	// attempts per link after which the following attempts are
+	// rejected for PasswordLockout. In case 0 or negative values
+	// are set, DefaultMaxPasswordAttempts and
+	// DefaultPasswordLockout are used
+	MaxPasswordAttempts int
+	PasswordLockout     time.Duration
+
+	// Policy decides which urls may be registered. In case it is
+	// nil, the default policy allowing only http and https urls
+	// of public hosts is used
+	Policy *policy.Policy
+
+	// OwnHosts are the hosts serving the short links of the
+	// service, e.g. the host of the public url of the frontends.
+	// Hosts with port are matched without it, full urls are
+	// accepted as well. Urls pointing to them are handled by
+	// SelfLinks
+	OwnHosts []string
+
+	// SelfLinks is SelfLinksReject or SelfLinksResolve. In case
+	// it is empty, SelfLinksReject is used
+	SelfLinks string
+
+	// MaxChainDepth is the maximum number of short links followed
+	// by SelfLinksResolve. In case 0 or negative value is set,
+	// DefaultMaxChainDepth is used
+	MaxChainDepth int
+
+	// SortQuery sorts the query parameters of the normalized urls,
+	// so urls differing only in their order are duplicates
+	SortQuery bool
+
+	// Workspaces are the tenants managed through Workspace. Their
+	// hosts are own hosts as well, whose short links are resolved
+	// in the respective workspace
+	Workspaces *workspace.Registry
+
+	// DomainTTL is the time for which the branded domains are
+	// cached. In case 0 or negative value is set, DefaultDomainTTL
+	// is used
+	DomainTTL time.Duration
+}
+
+// Service registers and manages links through db.Worker
+// enforcing the same rules for every frontend
+type Service struct {
+	dbWorker db.Worker
+	options  Options
+	attempts *attemptLimiter
+	random   *weightedRandom
+	ownHosts map[string]db.Worker
+	domains  *domainCache
+
+	workspace string
+	maxLinks  int64
+}
+
+// New creates and returns Service on top of the provided
+// DB worker. The caller remains responsible for shutting
+// the worker down
+func New(dbWorker db.Worker, options Options) *Service {
+	if options.MaxURLLength <= 0 {
+		options.MaxURLLength = DefaultMaxURLLength
+	}
+
+	if options.Logger == nil {
+		options.Logger = log.New(log.Writer(), log.Prefix(), log.Flags())
+	}
+
+	if options.MaxPasswordAttempts <= 0 {
+		options.MaxPasswordAttempts = DefaultMaxPasswordAttempts
+	}
+
+	if options.PasswordLockout <= 0 {
+		options.PasswordLockout = DefaultPasswordLockout
+	}
+
+	if options.SelfLinks != SelfLinksResolve {
+		options.SelfLinks = SelfLinksReject
+	}
+
+	if options.MaxChainDepth <= 0 {
+		options.MaxChainDepth = DefaultMaxChainDepth
+	}
+
+	if options.DomainTTL <= 0 {
+		options.DomainTTL = DefaultDomainTTL
+	}
+
+	ownHosts := make(map[string]db.Worker, len(options.OwnHosts))
+	for _, host := range options.OwnHosts {
+		if host = normalizeHost(host); host != "" {
+			ownHosts[host] = dbWorker
+		}
+	}
+
+	for _, ws := range options.Workspaces.Workspaces() {
+		scoped := dbWorker.Workspace(ws.ID, ws.Expiration)
+		for _, host := range ws.Hosts {
+			if host = normalizeHost(host); host != "" {
+				ownHosts[host] = scoped
+			}
+		}
+	}
+
+	if options.Policy == nil {
+		// the default policy reads no files, so it cannot fail
+		options.Policy, _ = policy.New(policy.Options{Logger: options.Logger})
+	}
+
+	return &Service{
+		dbWorker: dbWorker,
+		options:  options,
+		attempts: newAttemptLimiter(options.MaxPasswordAttempts, options.PasswordLockout),
+		random:   newWeightedRandom(),
+		ownHosts: ownHosts,
+		domains:  newDomainCache(options.DomainTTL),
+	}
+}
+
+// Validate checks the request against the rules and returns
+// all the violations. Empty result means valid request. The
+// url policy is checked separately by Create
+func (service *Service) Validate(req Request) (errs []FieldError) {
+	if req.URL == "" {
+		errs = append(errs, FieldError{
+			Field:  "url",
+			Code:   CodeInvalidURL,
+			Detail: "Url is required",
+		})
+	} else if len(req.URL) > service.options.MaxURLLength {
+		errs = append(errs, FieldError{
+			Field:  "url",
+			Code:   CodeURLTooLong,
+			Detail: fmt.Sprintf("Invalid url length: url is %v characters long. It should be at most %v characters long", len(req.URL), service.options.MaxURLLength),
+		})
+	} else if !isURL(req.URL) {
+		errs = append(errs, FieldError{
+			Field:  "url",
+			Code:   CodeInvalidURL,
+			Detail: fmt.Sprintf("Invalid url: %v", req.URL),
+		})
+	}
+
+	platforms := make([]string, 0, len(req.Targets))
+	for platform := range req.Targets {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+
+	for _, platform := range platforms {
+		target := req.Targets[platform]
+		field := fmt.Sprintf("targets.%v", platform)
+
+		if !useragent.Valid(useragent.Platform(platform)) {
+			errs = append(errs, FieldError{
+				Field:  field,
+				Code:   CodeTargetInvalid,
+				Detail: fmt.Sprintf("Invalid target platform: %v. Supported platforms: %v", platform, useragent.Platforms),
+			})
+		} else if len(target) > service.options.MaxURLLength {
+			errs = append(errs, FieldError{
+				Field:  field,
+				Code:   CodeURLTooLong,
+				Detail: fmt.Sprintf("Invalid target length: target is %v characters long. It should be at most %v characters long", len(target), service.options.MaxURLLength),
+			})
+		} else if !isURL(target) {
+			errs = append(errs, FieldError{
+				Field:  field,
+				Code:   CodeTargetInvalid,
+				Detail: fmt.Sprintf("Invalid target url: %v", target),
+			})
+		}
+	}
+
+	errs = append(errs, service.validateVariants(defaultVariants(req.Variants))...)
+	errs = append(errs, service.validateUTM(req.UTM)...)
+
+	if req.RedirectStatus != 0 && !ValidRedirectStatus(req.RedirectStatus) {
+		errs = append(errs, FieldError{
+			Field:  "redirect_status",
+			Code:   CodeRedirectInvalid,
+			Detail: fmt.Sprintf("Invalid redirect status: %v. Supported statuses: %v", req.RedirectStatus, RedirectStatuses),
+		})
+	}
+
+	if len(req.Tags) > maxTags {
+		errs = append(errs, FieldError{
+			Field:  "tags",
+			Code:   CodeTagInvalid,
+			Detail: fmt.Sprintf("Too many tags: %v. There should be at most %v tags", len(req.Tags), maxTags),
+		})
+	}
+
+	for i, tag := range req.Tags {
+		if len(tag) == 0 || len(tag) > maxTagLength || !isAllowed(tag) {
+			errs = append(errs, FieldError{
+				Field:  fmt.Sprintf("tags[%v]", i),
+				Code:   CodeTagInvalid,
+				Detail: fmt.Sprintf("Invalid tag: %v. Tags should be 1 to %v alphanumeric characters, underscores or dashes", tag, maxTagLength),
+			})
+		}
+	}
+
+	if len(req.Owner) > maxOwnerLength {
+		errs = append(errs, FieldError{
+			Field:  "owner",
+			Code:   CodeOwnerInvalid,
+			Detail: fmt.Sprintf("Invalid owner length: owner is %v characters long. It should be at most %v characters long", len(req.Owner), maxOwnerLength),
+		})
+	}
+
+	if len(req.Password) > maxPasswordLength {
+		errs = append(errs, FieldError{
+			Field:  "password",
+			Code:   CodePasswordInvalid,
+			Detail: fmt.Sprintf("Invalid password length: password is %v bytes long. It should be at most %v bytes long", len(req.Password), maxPasswordLength),
+		})
+	}
+
+	if req.MaxClicks < 0 {
+		errs = append(errs, FieldError{
+			Field:  "max_clicks",
+			Code:   CodeMaxClicksInvalid,
+			Detail: fmt.Sprintf("Invalid max clicks: %v. It should be non-negative integer", req.MaxClicks),
+		})
+	}
+
+	if !req.ExpiresAt.IsZero() && !req.ExpiresAt.After(time.Now()) {
+		errs = append(errs, FieldError{
+			Field:  "expires_at",
+			Code:   CodeExpirationInvalid,
+			Detail: fmt.Sprintf("Invalid expiration time: %v. It should be in the future", req.ExpiresAt.Format(time.RFC3339)),
+		})
+	} else if !req.ExpiresAt.IsZero() && !req.NotBefore.IsZero() && !req.NotBefore.Before(req.ExpiresAt) {
+		errs = append(errs, FieldError{
+			Field:  "not_before",
+			Code:   CodeNotBeforeInvalid,
+			Detail: fmt.Sprintf("Invalid activation time: %v. It should be before the expiration time %v", req.NotBefore.Format(time.RFC3339), req.ExpiresAt.Format(time.RFC3339)),
+		})
+	}
+
+	if len(req.ID) != 0 && len(req.ID) != idLength {
+		errs = append(errs, FieldError{
+			Field:  "id",
+			Code:   CodeAliasInvalid,
+			Detail: fmt.Sprintf("Invalid ID length: %v is %v character long. It should be exactly %v characters long", req.ID, len(req.ID), idLength),
+		})
+	} else if !isAllowed(req.ID) {
+		errs = append(errs, FieldError{
+			Field:  "id",
+			Code:   CodeAliasInvalid,
+			Detail: fmt.Sprintf("ID contains forbidden characters: %v. Allowed characters: alphanumeric characters, underscore and dash", req.ID),
+		})
+	}
+
+	return
+}
+
+// Create validates the request and registers new link. In case
+// of invalid request *ValidationError is returned, in case its
+// urls are rejected by the url policy or point to short links of
+// the service (see Options.SelfLinks) *PolicyError is returned
+// and in case the id or the url is already registered
+// *ConflictError is returned. In case the workspace of the
+// service has reached its quota *QuotaError is returned. Urls
+// are compared by their normalized form, while the original url
+// is kept for the redirect
+func (service *Service) Create(req Request) (link *db.Link, err error) {
+	errs := service.Validate(req)
+	if len(errs) != 0 {
+		err = &ValidationError{Errors: errs}
+		return
+	}
+
+	errs, err = service.resolveSelfLinks(&req)
+	if err != nil {
+		return
+	}
+
+	// resolved urls are checked as well
+	errs = append(errs, service.checkPolicy(req)...)
+	if len(errs) != 0 {
+		err = &PolicyError{Errors: errs}
+		return
+	}
+
+	normalizedURL, err := canonicalURL(req.URL, service.options.SortQuery)
+	if err != nil {
+		return
+	}
+
+	id, _, err := service.dbWorker.Find("url_to_id", normalizedURL)
+	if err != nil {
+		return
+	}
+
+	if id != "" {
+		err = &ConflictError{
+			Code:   CodeURLTaken,
+			Detail: fmt.Sprintf("Url %v already registered under id %v", req.URL, id),
+			ID:     id,
+			URL:    req.URL,
+		}
+		return
+	}
+
+	if req.ID == "" {
+		hasher := md5.New()
+		hasher.Write([]byte(req.URL))
+		hashed := hex.EncodeToString(hasher.Sum(nil))
+		req.ID = hashed[len(hashed)-idLength:]
+	}
+
+	_, url, err := service.dbWorker.Find("id_to_url", req.ID)
+	if err != nil {
+		return
+	}
+
+	if url != "" {
+		err = &ConflictError{
+			Code:   CodeAliasTaken,
+			Detail: fmt.Sprintf("ID %v already registered for url %v", req.ID, url),
+			ID:     req.ID,
+			URL:    url,
+		}
+		return
+	}
+
+	created := &db.Link{
+		ID:        req.ID,
+		URL:       req.URL,
+		Tags:      req.Tags,
+		Owner:     req.Owner,
+		MaxClicks: req.MaxClicks,
+		NotBefore: req.NotBefore,
+		ExpiresAt: req.ExpiresAt,
+		Targets:   req.Targets,
+
+		Variants:       defaultVariants(req.Variants),
+		StickyVariants: req.StickyVariants,
+
+		ForwardQuery:  req.ForwardQuery,
+		QueryOverride: req.QueryOverride,
+		UTM:           req.UTM,
+
+		RedirectStatus: req.RedirectStatus,
+		Preview:        req.Preview,
+
+		NormalizedURL: normalizedURL,
+	}
+
+	if req.Password != "" {
+		created.PasswordHash, err = hashPassword(req.Password)
+		if err != nil {
+			return
+		}
+	}
+
+	err = service.checkQuota()
+	if err != nil {
+		return
+	}
+
+	err = service.dbWorker.Create(created)
+	if err != nil {
+		return
+	}
+
+	link = created
+
+	return
+}
+
+// Extend sets new expiration time of the link registered under
+// id and returns the updated link. In case expiresAt is not in
+// the future or not after the activation of the link
+// *ValidationError is returned. In case there is no such link
+// nil is returned along with nil value for an error
+func (service *Service) Extend(id string, expiresAt time.Time) (link *db.Link, err error) {
+	if !expiresAt.After(time.Now()) {
+		err = &ValidationError{Errors: []FieldError{{
+			Field:  "expires_at",
+			Code:   CodeExpirationInvalid,
+			Detail: fmt.Sprintf("Invalid expiration time: %v. It should be in the future", expiresAt.Format(time.RFC3339)),
+		}}}
+		return
+	}
+
+	found, err := service.dbWorker.Get(id)
+	if err != nil || found == nil {
+		return
+	}
+
+	if !found.NotBefore.IsZero() && !found.NotBefore.Before(expiresAt) {
+		err = &ValidationError{Errors: []FieldError{{
+			Field:  "expires_at",
+			Code:   CodeExpirationInvalid,
+			Detail: fmt.Sprintf("Invalid expiration time: %v. It should be after the activation time %v", expiresAt.Format(time.RFC3339), found.NotBefore.Format(time.RFC3339)),
+		}}}
+		return
+	}
+
+	extended, err := service.dbWorker.Extend(id, expiresAt)
+	if err != nil || !extended {
+		return
+	}
+
+	link, err = service.dbWorker.Get(id)
+
+	return
+}
+
+// Resolve returns the link registered under id and counts a
+// click for it. Password is checked only for protected links.
+// In case the link is outside of its activation window, cannot
+// be accessed with the password or has reached its maximum
+// clicks *AccessError is returned. In
+// case there is no such link nil is returned along with nil
+// value for an error. Failed click counting is only logged for
+// links without maximum clicks
+func (service *Service) Resolve(id string, password string) (link *db.Link, err error) {
+	found, err := service.Check(id, password)
+	if err != nil || found == nil {
+		return
+	}
+
+	err = service.Click(found)
+	if err != nil {
+		return
+	}
+
+	link = found
+
+	return
+}
+
+// Click counts click of the link returned by Check. Failed
+// counting is only logged, unless the link has maximum clicks.
+// In case they were reached by concurrent clicks since the link
+// was checked, *AccessError is returned
+func (service *Service) Click(link *db.Link) error {
+	clicked, err := service.dbWorker.Click(link.ID)
+	if link.MaxClicks == 0 {
+		if err != nil {
+			service.options.Logger.Printf("Error while counting click for id %v: %v", link.ID, err)
+		}
+
+		return nil
+	}
+
+	// the link may be exhausted by concurrent clicks since it
+	// was selected, so the result of the click is decisive
+	if err != nil {
+		return err
+	}
+
+	if !clicked {
+		return exhausted(link)
+	}
+
+	return nil
+}
+
+// Check is like Resolve, but does not count click, e.g. for
+// requests which only inspect the redirect. Failed password
+// attempts are limited in the same way
+func (service *Service) Check(id string, password string) (link *db.Link, err error) {
+	found, err := service.dbWorker.Get(id)
+	if err != nil || found == nil {
+		return
+	}
+
+	// checked before the password, so no attempts are wasted
+	err = outsideWindow(found, time.Now())
+	if err != nil {
+		return
+	}
+
+	if found.Exhausted() {
+		err = exhausted(found)
+		return
+	}
+
+	if found.PasswordHash != "" {
+		err = service.unlock(id, found.PasswordHash, password)
+		if err != nil {
+			return
+		}
+	}
+
+	link = found
+
+	return
+}
+
+// Get returns the link registered under id without checking its
+// password or counting click, e.g. for rendering its QR code. In
+// case the link has expired and is kept only for the retention
+// period *AccessError is returned. In case there is no such link
+// nil is returned along with nil value for an error
+func (service *Service) Get(id string) (link *db.Link, err error) {
+	found, err := service.dbWorker.Get(id)
+	if err != nil || found == nil {
+		return
+	}
+
+	now := time.Now()
+	if found.State(now) == db.StateExpired {
+		err = outsideWindow(found, now)
+		return
+	}
+
+	link = found
+
+	return
+}
+
+// List returns page of the non-expired links matching the
+// filter. In case filter.Limit is 0, DefaultListLimit is used.
+// In case of limit or offset out of range *ValidationError is
+// returned
+func (service *Service) List(filter db.ListFilter) (links []*db.Link, err error) {
+	if filter.Limit == 0 {
+		filter.Limit = DefaultListLimit
+	}
+
+	var errs []FieldError
+
+	if filter.Limit < 0 || filter.Limit > MaxListLimit {
+		errs = append(errs, FieldError{
+			Field:  "limit",
+			Code:   CodeInvalidParameter,
+			Detail: fmt.Sprintf("Invalid limit: %v. It should be integer between 1 and %v", filter.Limit, MaxListLimit),
+		})
+	}
+
+	if filter.Offset < 0 {
+		errs = append(errs, FieldError{
+			Field:  "offset",
+			Code:   CodeInvalidParameter,
+			Detail: fmt.Sprintf("Invalid offset: %v. It should be non-negative integer", filter.Offset),
+		})
+	}
+
+	if len(errs) != 0 {
+		err = &ValidationError{Errors: errs}
+		return
+	}
+
+	links, err = service.dbWorker.List(filter)
+
+	return
+}
+
+func isURL(s string) bool {
+	_, err := url.ParseRequestURI(s)
+	return err == nil
+}
+
+func isAllowed(s string) bool {
+	for _, r := range s {
+		if !(unicode.IsLetter(r) || (r >= '0' && r <= '9') || r == '_' || r == '-') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,436 @@
+package service_test
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/policy"
+	"github.com/georgiv/url-shortener/server/service"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+func TestCreate(t *testing.T) {
+	s := service.New(testdata.NewMemoryWorker(), service.Options{})
+
+	link, err := s.Create(service.Request{URL: "http://testurl.com", Tags: []string{"docs"}, Owner: "cranki"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(link.ID) != 6 {
+		t.Errorf("Expected generated id of 6 characters, received: %v", link.ID)
+	}
+
+	if link.URL != "http://testurl.com" || link.Owner != "cranki" || len(link.Tags) != 1 {
+		t.Errorf("Unexpected link: %+v", link)
+	}
+}
+
+func TestCreateInvalid(t *testing.T) {
+	s := service.New(testdata.NewMemoryWorker(), service.Options{MaxURLLength: 20})
+
+	tests := []struct {
+		req  service.Request
+		code string
+	}{
+		{service.Request{URL: ""}, service.CodeInvalidURL},
+		{service.Request{URL: "testurl"}, service.CodeInvalidURL},
+		{service.Request{URL: "http://testurl.com/very/long"}, service.CodeURLTooLong},
+		{service.Request{ID: "abc", URL: "http://testurl.com"}, service.CodeAliasInvalid},
+		{service.Request{ID: "abc$de", URL: "http://testurl.com"}, service.CodeAliasInvalid},
+		{service.Request{URL: "http://testurl.com", Tags: []string{"a b"}}, service.CodeTagInvalid},
+		{service.Request{URL: "http://testurl.com", Password: strings.Repeat("p", 73)}, service.CodePasswordInvalid},
+		{service.Request{URL: "http://testurl.com", MaxClicks: -1}, service.CodeMaxClicksInvalid},
+		{service.Request{URL: "http://testurl.com", Targets: map[string]string{"windows": "http://testurl.com"}}, service.CodeTargetInvalid},
+		{service.Request{URL: "http://testurl.com", Targets: map[string]string{"ios": "testurl"}}, service.CodeTargetInvalid},
+		{service.Request{URL: "http://testurl.com", Targets: map[string]string{"ios": "http://testurl.com/" + strings.Repeat("a", 20)}}, service.CodeURLTooLong},
+		{service.Request{URL: "http://testurl.com", Variants: []db.Variant{{URL: "http://testurl.com/a"}}}, service.CodeVariantInvalid},
+		{service.Request{URL: "http://testurl.com", Variants: []db.Variant{{Name: "a", URL: "http://a.com"}, {Name: "a", URL: "http://b.com"}}}, service.CodeVariantInvalid},
+		{service.Request{URL: "http://testurl.com", Variants: []db.Variant{{URL: "http://a.com"}, {URL: "b.com"}}}, service.CodeVariantInvalid},
+		{service.Request{URL: "http://testurl.com", Variants: []db.Variant{{URL: "http://a.com"}, {URL: "http://b.com", Weight: -1}}}, service.CodeVariantInvalid},
+		{service.Request{URL: "http://testurl.com", UTM: map[string]string{"utm_id": "1"}}, service.CodeUTMInvalid},
+		{service.Request{URL: "http://testurl.com", UTM: map[string]string{"utm_source": ""}}, service.CodeUTMInvalid},
+		{service.Request{URL: "http://testurl.com", RedirectStatus: 303}, service.CodeRedirectInvalid},
+		{service.Request{URL: "http://testurl.com", ExpiresAt: time.Now().Add(-time.Hour)}, service.CodeExpirationInvalid},
+		{service.Request{URL: "http://testurl.com", NotBefore: time.Now().Add(2 * time.Hour), ExpiresAt: time.Now().Add(time.Hour)}, service.CodeNotBeforeInvalid},
+	}
+
+	for _, test := range tests {
+		_, err := s.Create(test.req)
+		verr, ok := err.(*service.ValidationError)
+		if !ok {
+			t.Errorf("Expected validation error for %+v, received: %v", test.req, err)
+			continue
+		}
+
+		if len(verr.Errors) != 1 || verr.Errors[0].Code != test.code {
+			t.Errorf("Expected code %v for %+v, received: %+v", test.code, test.req, verr.Errors)
+		}
+	}
+}
+
+func TestCreateBlocked(t *testing.T) {
+	urlPolicy, err := policy.New(policy.Options{DeniedDomains: []string{"evil.com"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	s := service.New(testdata.NewMemoryWorker(), service.Options{Policy: urlPolicy})
+
+	tests := []struct {
+		req    service.Request
+		fields []string
+	}{
+		{service.Request{URL: "javascript:alert(1)"}, []string{"url"}},
+		{service.Request{URL: "http://192.168.0.1/admin"}, []string{"url"}},
+		{service.Request{URL: "https://www.evil.com"}, []string{"url"}},
+		{service.Request{URL: "http://testurl.com", Targets: map[string]string{"ios": "file:///etc/passwd", "android": "http://localhost"}}, []string{"targets.android", "targets.ios"}},
+		{service.Request{URL: "http://testurl.com", Variants: []db.Variant{{URL: "http://a.com"}, {URL: "http://evil.com/b"}}}, []string{"variants[1].url"}},
+	}
+
+	for _, test := range tests {
+		_, err := s.Create(test.req)
+		perr, ok := err.(*service.PolicyError)
+		if !ok {
+			t.Errorf("Expected policy error for %+v, received: %v", test.req, err)
+			continue
+		}
+
+		var fields []string
+		for _, e := range perr.Errors {
+			if e.Code != service.CodeURLBlocked {
+				t.Errorf("Expected code %v, received %v", service.CodeURLBlocked, e.Code)
+			}
+			fields = append(fields, e.Field)
+		}
+
+		if strings.Join(fields, ",") != strings.Join(test.fields, ",") {
+			t.Errorf("Expected blocked %v for %+v, received: %v", test.fields, test.req, fields)
+		}
+	}
+
+	// invalid urls are reported before the policy is checked
+	_, err = s.Create(service.Request{URL: "http://localhost", MaxClicks: -1})
+	if _, ok := err.(*service.ValidationError); !ok {
+		t.Errorf("Expected validation error, received: %v", err)
+	}
+}
+
+func TestCreateConflict(t *testing.T) {
+	s := service.New(testdata.NewMemoryWorker(), service.Options{})
+
+	_, err := s.Create(service.Request{ID: "cranki", URL: "http://testurl.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		req  service.Request
+		code string
+	}{
+		{service.Request{ID: "other1", URL: "http://testurl.com"}, service.CodeURLTaken},
+		{service.Request{ID: "other2", URL: "HTTP://TestURL.com:80/"}, service.CodeURLTaken},
+		{service.Request{ID: "cranki", URL: "http://other.com"}, service.CodeAliasTaken},
+	}
+
+	for _, test := range tests {
+		_, err := s.Create(test.req)
+		cerr, ok := err.(*service.ConflictError)
+		if !ok || cerr.Code != test.code {
+			t.Errorf("Expected conflict %v for %+v, received: %v", test.code, test.req, err)
+		}
+	}
+}
+
+func TestCreateNormalized(t *testing.T) {
+	s := service.New(testdata.NewMemoryWorker(), service.Options{SortQuery: true})
+
+	link, err := s.Create(service.Request{URL: "HTTPS://Example.com:443/a?b=2&a=1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if link.URL != "HTTPS://Example.com:443/a?b=2&a=1" || link.NormalizedURL != "https://example.com/a?a=1&b=2" {
+		t.Errorf("Expected original and normalized url, received %v and %v", link.URL, link.NormalizedURL)
+	}
+
+	_, err = s.Create(service.Request{URL: "https://example.com/a?a=1&b=2"})
+	if cerr, ok := err.(*service.ConflictError); !ok || cerr.ID != link.ID {
+		t.Errorf("Expected conflict with %v, received: %v", link.ID, err)
+	}
+}
+
+func TestExtend(t *testing.T) {
+	s := service.New(testdata.NewMemoryWorker(), service.Options{})
+
+	_, err := s.Create(service.Request{ID: "cranki", URL: "http://testurl.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expiresAt := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+	link, err := s.Extend("cranki", expiresAt)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if link == nil || !link.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Expected expiration %v, received: %+v", expiresAt, link)
+	}
+
+	_, err = s.Extend("cranki", time.Now().Add(-time.Hour))
+	if _, ok := err.(*service.ValidationError); !ok {
+		t.Errorf("Expected validation error for past expiration, received: %v", err)
+	}
+
+	link, err = s.Extend("nonexi", expiresAt)
+	if err != nil || link != nil {
+		t.Errorf("Expected nil link for non-existing id, received: %+v, %v", link, err)
+	}
+}
+
+func TestResolveProtected(t *testing.T) {
+	s := service.New(testdata.NewMemoryWorker(), service.Options{MaxPasswordAttempts: 2, PasswordLockout: time.Minute})
+
+	link, err := s.Create(service.Request{ID: "secret", URL: "http://testurl.com", Password: "s3cr3t"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if link.PasswordHash == "" || link.PasswordHash == "s3cr3t" {
+		t.Errorf("Expected hashed password, received: %v", link.PasswordHash)
+	}
+
+	code := func(password string) string {
+		_, err := s.Resolve("secret", password)
+		if err == nil {
+			return ""
+		}
+
+		aerr, ok := err.(*service.AccessError)
+		if !ok {
+			t.Fatalf("Expected access error, received: %v", err)
+		}
+
+		return aerr.Code
+	}
+
+	tests := []struct {
+		password string
+		code     string
+	}{
+		{"", service.CodePasswordRequired},
+		{"wrong", service.CodePasswordIncorrect},
+		{"s3cr3t", ""},
+		{"wrong", service.CodePasswordIncorrect},
+		{"wrong", service.CodePasswordIncorrect},
+		{"s3cr3t", service.CodeTooManyAttempts},
+	}
+
+	for i, test := range tests {
+		if received := code(test.password); received != test.code {
+			t.Errorf("%v: expected %q, received: %q", i, test.code, received)
+		}
+	}
+
+}
+
+func TestResolveMaxClicks(t *testing.T) {
+	s := service.New(testdata.NewMemoryWorker(), service.Options{})
+
+	_, err := s.Create(service.Request{ID: "single", URL: "http://testurl.com", MaxClicks: 3})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var (
+		wg        sync.WaitGroup
+		resolved  int32
+		exhausted int32
+	)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			link, err := s.Resolve("single", "")
+			if aerr, ok := err.(*service.AccessError); ok && aerr.Code == service.CodeLinkExhausted {
+				atomic.AddInt32(&exhausted, 1)
+				return
+			}
+
+			if err != nil || link == nil {
+				t.Errorf("Unexpected result: %+v, %v", link, err)
+				return
+			}
+
+			atomic.AddInt32(&resolved, 1)
+		}()
+	}
+
+	wg.Wait()
+
+	if resolved != 3 || exhausted != 17 {
+		t.Errorf("Expected 3 resolved and 17 exhausted, received %v and %v", resolved, exhausted)
+	}
+}
+
+func TestResolveWindow(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	s := service.New(dbWorker, service.Options{})
+
+	notBefore := time.Now().Add(time.Hour)
+	link, err := s.Create(service.Request{ID: "launch", URL: "http://testurl.com", NotBefore: notBefore})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !link.ExpiresAt.After(notBefore.Add(6 * 24 * time.Hour)) {
+		t.Errorf("Expected expiration counted from activation, received: %v", link.ExpiresAt)
+	}
+
+	_, err = s.Resolve("launch", "")
+	aerr, ok := err.(*service.AccessError)
+	if !ok || aerr.Code != service.CodeLinkNotActive {
+		t.Fatalf("Expected %v, received: %v", service.CodeLinkNotActive, err)
+	}
+	if aerr.RetryAfter <= 59*time.Minute || aerr.RetryAfter > time.Hour {
+		t.Errorf("Expected retry after about an hour, received: %v", aerr.RetryAfter)
+	}
+
+	err = dbWorker.Create(&db.Link{ID: "closed", URL: "http://closed.com", ExpiresAt: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = s.Resolve("closed", "")
+	if aerr, ok := err.(*service.AccessError); !ok || aerr.Code != service.CodeLinkExpired {
+		t.Errorf("Expected %v, received: %v", service.CodeLinkExpired, err)
+	}
+
+	// looking up the url of the expired link keeps it for
+	// reporting it as expired
+	_, err = s.Create(service.Request{ID: "launch", URL: "http://closed.com"})
+	if cerr, ok := err.(*service.ConflictError); !ok || cerr.Code != service.CodeAliasTaken {
+		t.Fatalf("Expected %v, received: %v", service.CodeAliasTaken, err)
+	}
+
+	_, err = s.Resolve("closed", "")
+	if aerr, ok := err.(*service.AccessError); !ok || aerr.Code != service.CodeLinkExpired {
+		t.Errorf("Expected %v, received: %v", service.CodeLinkExpired, err)
+	}
+
+	_, err = s.Create(service.Request{ID: "closed", URL: "http://closed.com/again"})
+	if err != nil {
+		t.Fatalf("Expected the expired link replaced, received: %v", err)
+	}
+
+	link, err = s.Resolve("closed", "")
+	if err != nil || link.URL != "http://closed.com/again" {
+		t.Errorf("Expected http://closed.com/again, received: %v, %v", link, err)
+	}
+
+	_, err = s.Extend("launch", notBefore.Add(-time.Minute))
+	if _, ok := err.(*service.ValidationError); !ok {
+		t.Errorf("Expected validation error for expiration before activation, received: %v", err)
+	}
+}
+
+func TestDestination(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	s := service.New(dbWorker, service.Options{})
+
+	link, err := s.Create(service.Request{
+		ID:       "splits",
+		URL:      "http://testurl.com",
+		Targets:  map[string]string{"ios": "http://testurl.com/ios"},
+		Variants: []db.Variant{{URL: "http://testurl.com/a"}, {URL: "http://testurl.com/b", Weight: 999}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if link.Variants[0].Name != "a" || link.Variants[0].Weight != 1 || link.Variants[1].Name != "b" {
+		t.Errorf("Expected defaulted variants, received: %+v", link.Variants)
+	}
+
+	target, variant := s.Destination(link, "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)", "")
+	if target != "http://testurl.com/ios" || variant != "" {
+		t.Errorf("Expected platform target, received: %v, %v", target, variant)
+	}
+
+	picked := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		target, variant := s.Destination(link, "", "a")
+		s.CountVariant(link, variant)
+		if target != "http://testurl.com/"+variant {
+			t.Fatalf("Expected target of variant %v, received: %v", variant, target)
+		}
+		picked[variant]++
+	}
+
+	if picked["b"] < 900 {
+		t.Errorf("Expected variants picked by the weights, received: %v", picked)
+	}
+
+	stored, _ := dbWorker.Get("splits")
+	if stored.Variants[0].ClickCount != int64(picked["a"]) || stored.Variants[1].ClickCount != int64(picked["b"]) {
+		t.Errorf("Expected variant clicks %v, received: %+v", picked, stored.Variants)
+	}
+
+	sticky, err := s.Create(service.Request{
+		URL:            "http://anothertesturl.com",
+		Variants:       []db.Variant{{URL: "http://anothertesturl.com/a"}, {URL: "http://anothertesturl.com/b", Weight: 999}},
+		StickyVariants: true,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, variant := s.Destination(sticky, "", "a"); variant != "a" {
+			t.Fatalf("Expected sticky variant a, received: %v", variant)
+		}
+	}
+
+	if _, variant := s.Destination(sticky, "", "unknown"); variant == "unknown" {
+		t.Errorf("Expected unknown variant to be replaced")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	s := service.New(dbWorker, service.Options{})
+
+	_, err := s.Create(service.Request{ID: "single", URL: "http://testurl.com", MaxClicks: 1, Password: "s3cr3t"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		link, err := s.Check("single", "s3cr3t")
+		if err != nil || link == nil {
+			t.Fatalf("Expected link, received: %v, %v", link, err)
+		}
+	}
+
+	_, err = s.Check("single", "")
+	if aerr, ok := err.(*service.AccessError); !ok || aerr.Code != service.CodePasswordRequired {
+		t.Errorf("Expected %v, received: %v", service.CodePasswordRequired, err)
+	}
+
+	link, _ := dbWorker.Get("single")
+	if link.ClickCount != 0 {
+		t.Errorf("Expected no clicks, received: %v", link.ClickCount)
+	}
+
+	_, err = s.Resolve("single", "s3cr3t")
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
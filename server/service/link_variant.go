@@ -0,0 +1,184 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/useragent"
+)
+
+// Limits of the variants of a link
+const (
+	minVariants          = 2
+	maxVariants          = 10
+	maxVariantWeight     = 1000
+	maxVariantNameLength = 32
+)
+
+// defaultVariants returns copy of the variants with the defaults
+// applied: variants without name are named by their position
+// (a, b, c...) and variants without weight get weight 1
+func defaultVariants(variants []db.Variant) []db.Variant {
+	if len(variants) == 0 {
+		return nil
+	}
+
+	defaulted := make([]db.Variant, len(variants))
+	for i, variant := range variants {
+		if variant.Name == "" {
+			variant.Name = string(rune('a' + i))
+		}
+
+		if variant.Weight == 0 {
+			variant.Weight = 1
+		}
+
+		variant.ClickCount = 0
+		defaulted[i] = variant
+	}
+
+	return defaulted
+}
+
+// validateVariants checks the variants, which should already
+// have the defaults applied
+func (service *Service) validateVariants(variants []db.Variant) (errs []FieldError) {
+	if len(variants) == 0 {
+		return
+	}
+
+	if len(variants) < minVariants || len(variants) > maxVariants {
+		errs = append(errs, FieldError{
+			Field:  "variants",
+			Code:   CodeVariantInvalid,
+			Detail: fmt.Sprintf("Invalid number of variants: %v. There should be %v to %v variants", len(variants), minVariants, maxVariants),
+		})
+	}
+
+	names := make(map[string]bool, len(variants))
+	for i, variant := range variants {
+		field := fmt.Sprintf("variants[%v]", i)
+
+		switch {
+		case len(variant.Name) > maxVariantNameLength || !isAllowed(variant.Name):
+			errs = append(errs, FieldError{
+				Field:  field + ".name",
+				Code:   CodeVariantInvalid,
+				Detail: fmt.Sprintf("Invalid variant name: %v. Names should be 1 to %v alphanumeric characters, underscores or dashes", variant.Name, maxVariantNameLength),
+			})
+		case names[variant.Name]:
+			errs = append(errs, FieldError{
+				Field:  field + ".name",
+				Code:   CodeVariantInvalid,
+				Detail: fmt.Sprintf("Duplicate variant name: %v", variant.Name),
+			})
+		}
+		names[variant.Name] = true
+
+		if variant.Weight < 0 || variant.Weight > maxVariantWeight {
+			errs = append(errs, FieldError{
+				Field:  field + ".weight",
+				Code:   CodeVariantInvalid,
+				Detail: fmt.Sprintf("Invalid variant weight: %v. It should be integer between 1 and %v", variant.Weight, maxVariantWeight),
+			})
+		}
+
+		if variant.URL == "" || len(variant.URL) > service.options.MaxURLLength || !isURL(variant.URL) {
+			errs = append(errs, FieldError{
+				Field:  field + ".url",
+				Code:   CodeVariantInvalid,
+				Detail: fmt.Sprintf("Invalid variant url: %v. It should be valid url of at most %v characters", variant.URL, service.options.MaxURLLength),
+			})
+		}
+	}
+
+	return
+}
+
+// Destination returns the url which the resolved link redirects
+// the client with the provided User-Agent to, along with the name
+// of the chosen variant. Platform targets take precedence over
+// variants and the variant is empty for them. In case the link has
+// sticky variants and assigned names one of them, it is kept,
+// otherwise the variant is picked by the weights. The click of the
+// variant is counted separately by CountVariant
+func (service *Service) Destination(link *db.Link, userAgent string, assigned string) (target string, variant string) {
+	platform := useragent.Parse(userAgent)
+	if target, ok := link.Targets[string(platform)]; ok {
+		return target, ""
+	}
+
+	if len(link.Variants) == 0 {
+		return link.URL, ""
+	}
+
+	chosen := -1
+	if link.StickyVariants {
+		for i, v := range link.Variants {
+			if v.Name == assigned {
+				chosen = i
+			}
+		}
+	}
+
+	if chosen < 0 {
+		chosen = service.random.pick(link.Variants)
+	}
+
+	target, variant = link.Variants[chosen].URL, link.Variants[chosen].Name
+
+	return
+}
+
+// CountVariant counts click of the variant returned by
+// Destination. Empty variant is ignored and failed counting is
+// only logged
+func (service *Service) CountVariant(link *db.Link, variant string) {
+	if variant == "" {
+		return
+	}
+
+	_, err := service.dbWorker.ClickVariant(link.ID, variant)
+	if err != nil {
+		service.options.Logger.Printf("Error while counting click of variant %v for id %v: %v", variant, link.ID, err)
+	}
+}
+
+// weightedRandom picks variants by their weights. It is safe
+// for concurrent use
+type weightedRandom struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newWeightedRandom() *weightedRandom {
+	return &weightedRandom{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// pick returns the index of the picked variant
+func (random *weightedRandom) pick(variants []db.Variant) int {
+	total := 0
+	for _, v := range variants {
+		total += v.Weight
+	}
+
+	if total <= 0 {
+		return 0
+	}
+
+	random.mu.Lock()
+	n := random.rand.Intn(total)
+	random.mu.Unlock()
+
+	for i, v := range variants {
+		if n < v.Weight {
+			return i
+		}
+		n -= v.Weight
+	}
+
+	return len(variants) - 1
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/workspace"
+)
+
+// QuotaError is returned when the workspace already has the
+// maximum number of links which have not expired
+type QuotaError struct {
+	Workspace string
+	MaxLinks  int64
+}
+
+func (err *QuotaError) Error() string {
+	return fmt.Sprintf("Workspace %v has reached its maximum of %v links", err.Workspace, err.MaxLinks)
+}
+
+// Workspace returns Service managing the links of the workspace
+// with the same rules through the DB worker scoped to it. The
+// failed password attempts are counted per workspace. The
+// default workspace is managed by the service itself
+func (service *Service) Workspace(ws *workspace.Workspace) *Service {
+	if ws.ID == "" {
+		return service
+	}
+
+	scoped := *service
+	scoped.dbWorker = service.dbWorker.Workspace(ws.ID, ws.Expiration)
+	scoped.workspace = ws.ID
+	scoped.maxLinks = ws.MaxLinks
+
+	return &scoped
+}
+
+// DB returns the DB worker the service manages the links through
+func (service *Service) DB() db.Worker {
+	return service.dbWorker
+}
+
+// checkQuota returns *QuotaError in case the workspace cannot
+// have more links
+func (service *Service) checkQuota() error {
+	if service.maxLinks <= 0 {
+		return nil
+	}
+
+	count, err := service.dbWorker.Count()
+	if err != nil {
+		return err
+	}
+
+	if count >= service.maxLinks {
+		return &QuotaError{
+			Workspace: service.workspace,
+			MaxLinks:  service.maxLinks,
+		}
+	}
+
+	return nil
+}
+
+// attemptKey returns the key of the failed password attempts
+// for the link, so the same ids of different workspaces are
+// limited separately
+func (service *Service) attemptKey(id string) string {
+	if service.workspace == "" {
+		return id
+	}
+
+	return service.workspace + "/" + id
+}
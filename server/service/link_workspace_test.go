@@ -0,0 +1,77 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/georgiv/url-shortener/server/service"
+	"github.com/georgiv/url-shortener/server/workspace"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+func TestCreateWorkspace(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "https://google.com")
+
+	acme := &workspace.Workspace{ID: "acme", Hosts: []string{"go.acme.com"}, MaxLinks: 2}
+	registry, err := workspace.New([]workspace.Workspace{*acme})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	root := service.New(dbWorker, service.Options{
+		OwnHosts:   []string{"s.example.com"},
+		SelfLinks:  service.SelfLinksResolve,
+		Workspaces: registry,
+	})
+	scoped := root.Workspace(acme)
+
+	if root.Workspace(&workspace.Workspace{}) != root {
+		t.Errorf("Expected the default workspace to be managed by the service itself")
+	}
+
+	// the same id and url are free in another workspace
+	link, err := scoped.Create(service.Request{ID: "cranki", URL: "https://google.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if found, _ := root.DB().Get("cranki"); found == nil || found.URL != "https://google.com" {
+		t.Errorf("Expected the link of the default workspace to be kept, received %v", found)
+	}
+
+	if found, _ := scoped.DB().Get(link.ID); found == nil {
+		t.Errorf("Expected link %v in the workspace", link.ID)
+	}
+
+	// short links of the workspace hosts are resolved in the workspace
+	_, err = root.Create(service.Request{URL: "https://go.acme.com/api/urls/unknwn"})
+	if _, ok := err.(*service.PolicyError); !ok {
+		t.Errorf("Expected policy error for missing link of the workspace, received %v", err)
+	}
+
+	_, err = scoped.Create(service.Request{ID: "bingcm", URL: "https://bing.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	resolved, err := root.Create(service.Request{URL: "https://go.acme.com/api/urls/bingcm"})
+	if err != nil || resolved.URL != "https://bing.com" {
+		t.Errorf("Expected link of the workspace resolved to https://bing.com, received %v, %v", resolved, err)
+	}
+
+	_, err = scoped.Create(service.Request{URL: "https://example.com"})
+	if e, ok := err.(*service.QuotaError); !ok || e.Workspace != "acme" || e.MaxLinks != 2 {
+		t.Errorf("Expected quota error, received %v", err)
+	}
+
+	// conflicts are reported before the quota
+	_, err = scoped.Create(service.Request{URL: "https://bing.com"})
+	if _, ok := err.(*service.ConflictError); !ok {
+		t.Errorf("Expected conflict error, received %v", err)
+	}
+
+	_, err = root.Create(service.Request{URL: "https://example.com"})
+	if err != nil {
+		t.Errorf("Unexpected error for the default workspace: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+// Package useragent provides local classification of the
+// User-Agent header into the platforms targeted by links.
+// It relies only on well-known tokens of the header and does
+// not call any external service.
+//
+// Copyright 2019 cranki. All rights reserved.
+package useragent
+
+import (
+	"strings"
+)
+
+// Platform is the class of device the request comes from
+type Platform string
+
+// Platforms which links may target. Requests which cannot be
+// classified, e.g. from crawlers or unknown clients, are of
+// PlatformOther and get the default target
+const (
+	PlatformIOS     Platform = "ios"
+	PlatformAndroid Platform = "android"
+	PlatformDesktop Platform = "desktop"
+	PlatformOther   Platform = ""
+)
+
+// Platforms lists the platforms which links may target
+var Platforms = []Platform{PlatformIOS, PlatformAndroid, PlatformDesktop}
+
+// Valid reports whether links may target the platform
+func Valid(platform Platform) bool {
+	for _, p := range Platforms {
+		if p == platform {
+			return true
+		}
+	}
+
+	return false
+}
+
+var (
+	// botTokens mark crawlers and link previewers, which get
+	// the default target regardless of the platform they claim
+	botTokens = []string{"bot", "crawler", "spider", "slurp", "facebookexternalhit", "embedly", "preview"}
+
+	// otherMobileTokens mark mobile platforms which are not
+	// targeted, but claim to be Android or desktop for
+	// compatibility
+	otherMobileTokens = []string{"windows phone", "iemobile", "blackberry", "bb10", "kaios"}
+
+	iosTokens     = []string{"iphone", "ipad", "ipod", "cfnetwork", "darwin/"}
+	androidTokens = []string{"android"}
+	desktopTokens = []string{"windows nt", "macintosh", "mac os x", "cros", "x11", "linux"}
+)
+
+// Parse classifies the User-Agent header. Tokens are matched
+// case-insensitively in order of precedence: crawlers, other
+// mobile platforms, iOS, Android and desktop. Note that iPadOS
+// requests desktop sites by default and is reported as desktop
+func Parse(userAgent string) Platform {
+	ua := strings.ToLower(userAgent)
+
+	switch {
+	case ua == "", containsAny(ua, botTokens), containsAny(ua, otherMobileTokens):
+		return PlatformOther
+	case containsAny(ua, iosTokens):
+		return PlatformIOS
+	case containsAny(ua, androidTokens):
+		return PlatformAndroid
+	case containsAny(ua, desktopTokens):
+		return PlatformDesktop
+	default:
+		return PlatformOther
+	}
+}
+
+func containsAny(s string, tokens []string) bool {
+	for _, token := range tokens {
+		if strings.Contains(s, token) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,49 @@
+package useragent_test
+
+import (
+	"testing"
+
+	"github.com/georgiv/url-shortener/server/useragent"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		platform  useragent.Platform
+	}{
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1", useragent.PlatformIOS},
+		{"Mozilla/5.0 (iPad; CPU OS 12_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148", useragent.PlatformIOS},
+		{"MyApp/1.0 CFNetwork/1404.0.5 Darwin/22.3.0", useragent.PlatformIOS},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", useragent.PlatformAndroid},
+		{"Dalvik/2.1.0 (Linux; U; Android 13; SM-S911B Build/TP1A.220624.014)", useragent.PlatformAndroid},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", useragent.PlatformDesktop},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15", useragent.PlatformDesktop},
+		{"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0", useragent.PlatformDesktop},
+		{"Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", useragent.PlatformDesktop},
+		{"Mozilla/5.0 (Windows Phone 10.0; Android 6.0.1; Microsoft; Lumia 950) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/52.0.2743.116 Mobile Safari/537.36 Edge/15.14977", useragent.PlatformOther},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", useragent.PlatformOther},
+		{"Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", useragent.PlatformOther},
+		{"curl/8.4.0", useragent.PlatformOther},
+		{"", useragent.PlatformOther},
+	}
+
+	for _, test := range tests {
+		if platform := useragent.Parse(test.userAgent); platform != test.platform {
+			t.Errorf("%v: expected %q, received %q", test.userAgent, test.platform, platform)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	for _, platform := range useragent.Platforms {
+		if !useragent.Valid(platform) {
+			t.Errorf("Expected %q to be valid", platform)
+		}
+	}
+
+	for _, platform := range []useragent.Platform{useragent.PlatformOther, "windows", "IOS"} {
+		if useragent.Valid(platform) {
+			t.Errorf("Expected %q to be invalid", platform)
+		}
+	}
+}
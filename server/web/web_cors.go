@@ -0,0 +1,154 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// CORSOptions represents the cross-origin policy of the handler
+// returned by NewHandler. Preflight requests are answered for
+// every registered route, so handlers do not need OPTIONS routes
+type CORSOptions struct {
+	// AllowedOrigins lists the origins allowed to access the API.
+	// "*" allows any origin. In case it is empty, any origin
+	// is allowed
+	AllowedOrigins []string
+
+	// AllowedMethods lists the methods announced in preflight
+	// responses. In case it is empty, the methods of the routes
+	// matching the requested path are announced
+	AllowedMethods []string
+
+	// AllowedHeaders lists the request headers allowed in
+	// cross-origin requests. In case it is empty, Accept and
+	// Content-Type are allowed
+	AllowedHeaders []string
+
+	// ExposedHeaders lists the response headers accessible by
+	// the client. In case it is empty, Location is exposed
+	ExposedHeaders []string
+
+	// AllowCredentials allows cookies and authorization headers
+	// in cross-origin requests. The matching request origin is
+	// echoed back in this case, as browsers reject "*". Only the
+	// origins listed explicitly are allowed, "*" allows none, so
+	// arbitrary sites cannot send credentialed requests
+	AllowCredentials bool
+
+	// MaxAge is the time in seconds for which the preflight
+	// response may be cached. It is not sent when 0
+	MaxAge int
+}
+
+var corsMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+type cors struct {
+	router  *mux.Router
+	options CORSOptions
+}
+
+func withCORS(router *mux.Router, options CORSOptions) http.Handler {
+	if len(options.AllowedOrigins) == 0 {
+		options.AllowedOrigins = []string{"*"}
+	}
+
+	if len(options.AllowedHeaders) == 0 {
+		options.AllowedHeaders = []string{"Accept", "Content-Type"}
+	}
+
+	if len(options.ExposedHeaders) == 0 {
+		options.ExposedHeaders = []string{"Location"}
+	}
+
+	return &cors{router: router, options: options}
+}
+
+func (policy *cors) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		policy.router.ServeHTTP(w, r)
+		return
+	}
+
+	preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+
+	if preflight {
+		methods := policy.methods(r)
+		if len(methods) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if !policy.allowOrigin(w, origin) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.options.AllowedHeaders, ", "))
+		if policy.options.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(policy.options.MaxAge))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if policy.allowOrigin(w, origin) {
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.options.ExposedHeaders, ", "))
+	}
+
+	policy.router.ServeHTTP(w, r)
+}
+
+func (policy *cors) allowOrigin(w http.ResponseWriter, origin string) bool {
+	w.Header().Add("Vary", "Origin")
+
+	for _, o := range policy.options.AllowedOrigins {
+		if o == "*" {
+			if policy.options.AllowCredentials {
+				continue
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			return true
+		}
+
+		if strings.EqualFold(o, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if policy.options.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+func (policy *cors) methods(r *http.Request) []string {
+	candidates := policy.options.AllowedMethods
+	if len(candidates) == 0 {
+		candidates = corsMethods
+	}
+
+	return routeMethods(policy.router, r, candidates)
+}
+
+// routeMethods returns the candidate methods for which there
+// is a route matching the path of the request
+func routeMethods(router *mux.Router, r *http.Request, candidates []string) (methods []string) {
+	for _, method := range candidates {
+		req := *r
+		req.Method = method
+
+		var match mux.RouteMatch
+		if router.Match(&req, &match) && match.MatchErr == nil {
+			methods = append(methods, method)
+		}
+	}
+
+	return
+}
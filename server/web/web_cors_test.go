@@ -0,0 +1,139 @@
+package web_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/georgiv/url-shortener/server/web"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	options := web.Options{
+		CORS: web.CORSOptions{
+			AllowedOrigins: []string{"https://example.com"},
+			MaxAge:         600,
+		},
+	}
+	handler := web.NewHandler(testdata.NewMemoryWorker(), options, nil)
+
+	r := httptest.NewRequest("OPTIONS", "/api/urls", nil)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != 204 {
+		t.Errorf("Expected status code 204, received: %v", w.Code)
+	}
+
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":  "https://example.com",
+		"Access-Control-Allow-Methods": "POST",
+		"Access-Control-Allow-Headers": "Accept, Content-Type",
+		"Access-Control-Max-Age":       "600",
+	}
+	for k, v := range expected {
+		if w.Header().Get(k) != v {
+			t.Errorf("Expected %v for %v, received %v", v, k, w.Header().Get(k))
+		}
+	}
+
+	r = httptest.NewRequest("OPTIONS", "/api/urls/cranki", nil)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	methods := w.Header().Get("Access-Control-Allow-Methods")
+	if methods != "GET, HEAD, POST" {
+		t.Errorf("Expected GET, HEAD, POST, received %v", methods)
+	}
+}
+
+func TestCORSPreflightForbiddenOrigin(t *testing.T) {
+	options := web.Options{
+		CORS: web.CORSOptions{AllowedOrigins: []string{"https://example.com"}},
+	}
+	handler := web.NewHandler(testdata.NewMemoryWorker(), options, nil)
+
+	r := httptest.NewRequest("OPTIONS", "/api/urls", nil)
+	r.Header.Set("Origin", "https://evil.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != 403 {
+		t.Errorf("Expected status code 403, received: %v", w.Code)
+	}
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected no Access-Control-Allow-Origin, received %v", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestCORSCredentials(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "https://google.com")
+
+	options := web.Options{
+		CORS: web.CORSOptions{AllowedOrigins: []string{"*", "https://example.com"}, AllowCredentials: true},
+	}
+	handler := web.NewHandler(dbWorker, options, nil)
+
+	r := httptest.NewRequest("GET", "/api/urls/cranki", nil)
+	r.Header.Set("Origin", "https://example.com")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != 308 {
+		t.Errorf("Expected status code 308, received: %v", w.Code)
+	}
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Errorf("Expected https://example.com, received %v", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("Expected true, received %v", w.Header().Get("Access-Control-Allow-Credentials"))
+	}
+	if w.Header().Get("Access-Control-Expose-Headers") != "Location" {
+		t.Errorf("Expected Location, received %v", w.Header().Get("Access-Control-Expose-Headers"))
+	}
+}
+
+func TestCORSCredentialsAnyOrigin(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "https://google.com")
+
+	options := web.Options{
+		CORS: web.CORSOptions{AllowCredentials: true},
+	}
+	handler := web.NewHandler(dbWorker, options, nil)
+
+	// "*" does not let arbitrary sites send credentialed requests
+	r := httptest.NewRequest("GET", "/api/urls/cranki", nil)
+	r.Header.Set("Origin", "https://attacker.example")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected no allowed origin, received %v", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("Expected no credentials, received %v", w.Header().Get("Access-Control-Allow-Credentials"))
+	}
+
+	r = httptest.NewRequest("OPTIONS", "/api/urls/cranki", nil)
+	r.Header.Set("Origin", "https://attacker.example")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != 403 {
+		t.Errorf("Expected status code 403, received: %v", w.Code)
+	}
+}
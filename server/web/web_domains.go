@@ -0,0 +1,157 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/service"
+	"github.com/gorilla/mux"
+)
+
+// codeForbidden is the code of the problem sent for domain
+// requests outside of the default workspace
+const codeForbidden = "forbidden"
+
+// domainResource is the representation of a branded domain in
+// /api/v2
+type domainResource struct {
+	Host      string    `json:"host"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// NotFoundPage is present only for domains with own page
+	NotFoundPage string `json:"not_found_page,omitempty"`
+}
+
+// domainList is the representation of all branded domains in
+// /api/v2
+type domainList struct {
+	Domains []domainResource `json:"domains"`
+}
+
+// domainPayload is the payload for registering a branded domain
+// in /api/v2
+type domainPayload struct {
+	Host         string `json:"host"`
+	NotFoundPage string `json:"not_found_page,omitempty"`
+}
+
+// deployment allows managing the domains, which are shared by
+// all workspaces, only to the requests of the default workspace.
+// The other requests are rejected with 403
+func (handler *api) deployment(next http.HandlerFunc) http.HandlerFunc {
+	return handler.managed(func(w http.ResponseWriter, r *http.Request) {
+		if handler.links(r) != handler.linkService {
+			handler.writeProblem(w, r, problem{
+				Status: http.StatusForbidden,
+				Code:   codeForbidden,
+				Detail: "Domains are managed only in the default workspace",
+			})
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+func (handler *api) createDomain(w http.ResponseWriter, r *http.Request) {
+	var b domainPayload
+	if !handler.decode(w, r, &b) {
+		return
+	}
+
+	domain, err := handler.linkService.CreateDomain(service.DomainRequest{
+		Host:         b.Host,
+		NotFoundPage: b.NotFoundPage,
+	})
+	if err != nil {
+		handler.writeServiceError(w, r, payload{}, err, "Error while registering domain %v: %v", b.Host, err)
+		return
+	}
+
+	w.Header().Set("location", fmt.Sprintf("%v/api/v2/domains/%v", handler.options.PathPrefix, domain.Host))
+	handler.writeJSON(w, r, http.StatusCreated, handler.domainResource(r, domain))
+}
+
+func (handler *api) getDomain(w http.ResponseWriter, r *http.Request) {
+	host := mux.Vars(r)["host"]
+	domain, err := handler.linkService.Domain(host)
+	if err != nil {
+		handler.writeInternalError(w, r, "Error while retrieving domain %v: %v", host, err)
+		return
+	}
+
+	if domain == nil {
+		handler.writeDomainNotFound(w, r, host)
+		return
+	}
+
+	handler.writeJSON(w, r, http.StatusOK, handler.domainResource(r, domain))
+}
+
+func (handler *api) deleteDomain(w http.ResponseWriter, r *http.Request) {
+	host := mux.Vars(r)["host"]
+	deleted, err := handler.linkService.DeleteDomain(host)
+	if err != nil {
+		handler.writeInternalError(w, r, "Error while deleting domain %v: %v", host, err)
+		return
+	}
+
+	if !deleted {
+		handler.writeDomainNotFound(w, r, host)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (handler *api) listDomains(w http.ResponseWriter, r *http.Request) {
+	domains, err := handler.linkService.Domains()
+	if err != nil {
+		handler.writeInternalError(w, r, "Error while listing domains: %v", err)
+		return
+	}
+
+	list := domainList{Domains: make([]domainResource, 0, len(domains))}
+	for _, domain := range domains {
+		list.Domains = append(list.Domains, handler.domainResource(r, domain))
+	}
+
+	handler.writeJSON(w, r, http.StatusOK, list)
+}
+
+func (handler *api) writeDomainNotFound(w http.ResponseWriter, r *http.Request, host string) {
+	handler.writeProblem(w, r, problem{
+		Status: http.StatusNotFound,
+		Code:   codeNotFound,
+		Detail: fmt.Sprintf("Domain %v does not exist", host),
+	})
+}
+
+func (handler *api) domainResource(r *http.Request, domain *db.Domain) domainResource {
+	return domainResource{
+		Host:         domain.Host,
+		URL:          fmt.Sprintf("%v://%v", handler.scheme(r), domain.Host),
+		CreatedAt:    domain.CreatedAt.UTC(),
+		NotFoundPage: domain.NotFoundPage,
+	}
+}
+
+// writeNotFoundPage sends the not found page of the branded
+// domain of the request to browsers. Returns false in case the
+// request is not served by domain with own page
+func (handler *api) writeNotFoundPage(w http.ResponseWriter, r *http.Request) bool {
+	s := requestScope(r)
+	if s == nil || s.domain == nil || s.domain.NotFoundPage == "" || !wantsHTML(r) {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(s.domain.NotFoundPage))
+
+	return true
+}
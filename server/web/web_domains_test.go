@@ -0,0 +1,143 @@
+package web_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/georgiv/url-shortener/server/web"
+	"github.com/georgiv/url-shortener/server/workspace"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+func TestCreateLinkDomain(t *testing.T) {
+	registry, err := workspace.New([]workspace.Workspace{{ID: "acme", Hosts: []string{"go.acme.com"}, APIKeys: []string{workspace.HashKey("acme-key")}}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "https://google.com")
+
+	handler := web.NewHandler(dbWorker, web.Options{PublicURL: "https://s.example.com", Workspaces: registry}, nil)
+
+	send := func(method string, target string, body string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		for key, value := range header {
+			r.Header.Set(key, value)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	w := send("POST", "https://s.example.com/api/v2/domains", `{"host": "S.Brand.com", "not_found_page": "<h1>Not here</h1>"}`, nil)
+	if w.Code != 201 {
+		t.Fatalf("Expected status code 201, received: %v", w.Code)
+	}
+
+	if location := w.Header().Get("Location"); location != "/api/v2/domains/s.brand.com" {
+		t.Errorf("Expected /api/v2/domains/s.brand.com, received %v", location)
+	}
+
+	var d struct {
+		Host string `json:"host"`
+		URL  string `json:"url"`
+	}
+	err = json.Unmarshal(w.Body.Bytes(), &d)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if d.Host != "s.brand.com" || d.URL != "https://s.brand.com" {
+		t.Errorf("Unexpected domain: %+v", d)
+	}
+
+	w = send("POST", "https://s.brand.com/api/urls", `{"id": "cranki", "url": "https://bing.com"}`, nil)
+	if w.Code != 201 {
+		t.Fatalf("Expected status code 201, received: %v", w.Code)
+	}
+
+	if location := w.Header().Get("Location"); location != "https://s.brand.com/api/urls/cranki" {
+		t.Errorf("Expected https://s.brand.com/api/urls/cranki, received %v", location)
+	}
+
+	html := map[string]string{"Accept": "text/html"}
+
+	tests := []struct {
+		target   string
+		header   map[string]string
+		status   int
+		location string
+		body     string
+	}{
+		{"https://s.brand.com/api/urls/cranki", nil, 308, "https://bing.com", ""},
+		{"https://s.example.com/api/urls/cranki", nil, 308, "https://google.com", ""},
+		{"https://s.brand.com/api/urls/unknwn", html, 404, "", "<h1>Not here</h1>"},
+		{"https://s.example.com/api/urls/unknwn", html, 404, "", ""},
+	}
+
+	for _, test := range tests {
+		w := send("GET", test.target, "", test.header)
+		if w.Code != test.status {
+			t.Errorf("Expected status code %v for %v, received: %v", test.status, test.target, w.Code)
+		}
+
+		if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("Expected location %v for %v, received %v", test.location, test.target, location)
+		}
+
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("Expected body %v for %v, received %v", test.body, test.target, w.Body.String())
+		}
+	}
+
+	problems := []struct {
+		method string
+		target string
+		body   string
+		header map[string]string
+		status int
+		code   string
+	}{
+		{"POST", "https://s.example.com/api/v2/domains", `{"host": "s.brand.com"}`, nil, 409, "domain_taken"},
+		{"POST", "https://s.example.com/api/v2/domains", `{"host": "brand"}`, nil, 400, "host_invalid"},
+		{"GET", "https://s.brand.com/api/v2/domains", "", nil, 403, "forbidden"},
+		{"GET", "https://s.example.com/api/v2/domains", "", map[string]string{"Authorization": "Bearer acme-key"}, 403, "forbidden"},
+		{"GET", "https://s.example.com/api/v2/domains/go.team-a.io", "", nil, 404, "not_found"},
+	}
+
+	for _, test := range problems {
+		w := send(test.method, test.target, test.body, test.header)
+		if w.Code != test.status {
+			t.Errorf("Expected status code %v for %v %v, received: %v", test.status, test.method, test.target, w.Code)
+		}
+
+		var p struct {
+			Code string `json:"code"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &p)
+
+		if p.Code != test.code {
+			t.Errorf("Expected code %v for %v %v, received %v", test.code, test.method, test.target, p.Code)
+		}
+	}
+
+	w = send("GET", "https://s.example.com/api/v2/domains", "", nil)
+	if w.Code != 200 || !bytes.Contains(w.Body.Bytes(), []byte(`"host":"s.brand.com"`)) {
+		t.Errorf("Expected list with s.brand.com, received %v: %v", w.Code, w.Body.String())
+	}
+
+	w = send("DELETE", "https://s.example.com/api/v2/domains/s.brand.com", "", nil)
+	if w.Code != 204 {
+		t.Errorf("Expected status code 204, received: %v", w.Code)
+	}
+
+	w = send("GET", "https://s.brand.com/api/urls/cranki", "", nil)
+	if location := w.Header().Get("Location"); location != "https://google.com" {
+		t.Errorf("Expected the default workspace after deleting the domain, received %v", location)
+	}
+}
@@ -0,0 +1,349 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/service"
+	"github.com/georgiv/url-shortener/server/workspace"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// DefaultMaxBodySize is the maximum size in bytes of the
+	// request body used when Options.MaxBodySize is not set
+	DefaultMaxBodySize = 64 * 1024
+
+	// DefaultMaxURLLength is the maximum length of the
+	// registered URL used when Options.MaxURLLength is not set
+	DefaultMaxURLLength = service.DefaultMaxURLLength
+
+	// DefaultNotActiveStatus is the status code of the response
+	// for links which have not been activated yet used when
+	// Options.NotActiveStatus is not set
+	DefaultNotActiveStatus = http.StatusForbidden
+
+	// DefaultRedirectStatus is the status code of the redirects
+	// used when Options.RedirectStatus is not set
+	DefaultRedirectStatus = http.StatusPermanentRedirect
+
+	// DefaultRedirectMaxAge is the maximum age of cached
+	// permanent redirects used when Options.RedirectMaxAge is
+	// not set
+	DefaultRedirectMaxAge = 24 * time.Hour
+)
+
+// Options represents the tunable parameters of the handler
+// returned by NewHandler
+type Options struct {
+	// PathPrefix is prepended to all registered routes, so
+	// the handler can be mounted under a sub-path of an
+	// existing gateway, e.g. "/shortener". Empty value means
+	// the routes are registered as they are (/api/urls)
+	PathPrefix string
+
+	// MaxBodySize is the maximum size in bytes of the request
+	// body. Larger bodies are rejected with 413. In case 0 or
+	// negative value is set, DefaultMaxBodySize is used
+	MaxBodySize int64
+
+	// MaxURLLength is the maximum length of the registered URL.
+	// Longer URLs are rejected with 400. In case 0 or negative
+	// value is set, DefaultMaxURLLength is used
+	MaxURLLength int
+
+	// CORS is the cross-origin policy applied to all routes
+	CORS CORSOptions
+
+	// PublicURL is the scheme and host used for building the
+	// absolute short URLs, e.g. "https://s.example.com". In case
+	// it is empty, they are derived from the incoming request
+	PublicURL string
+
+	// SwaggerUI is the directory with the swagger-ui.css and
+	// swagger-ui-bundle.js assets of the swagger-ui-dist package.
+	// In case it is set, Swagger UI page rendering the OpenAPI
+	// document served at /api/openapi.json is enabled at /api/docs
+	// and the assets are served from the same origin under it
+	SwaggerUI string
+
+	// LinkService applies the rules for registering and resolving
+	// links. Frontends sharing the same instance share the limits
+	// of failed password attempts. In case it is nil, one is
+	// created on top of the DB worker honouring MaxURLLength and
+	// rejecting urls of the host of PublicURL
+	LinkService *service.Service
+
+	// NotActiveStatus is the status code of the problem sent for
+	// links which have not been activated yet. In case it is not
+	// 4xx or 5xx status, DefaultNotActiveStatus is used
+	NotActiveStatus int
+
+	// NotActiveURL is the page to which the links which have not
+	// been activated yet redirect temporarily (302), e.g. a
+	// teaser of the campaign. In case it is empty, the problem
+	// with NotActiveStatus is sent instead
+	NotActiveURL string
+
+	// RedirectStatus is the status code of the redirects of links
+	// which do not set their own. In case it is not 301, 302, 307
+	// or 308, DefaultRedirectStatus is used
+	RedirectStatus int
+
+	// RedirectMaxAge is the maximum age of cached permanent
+	// redirects, after which retargeted links take effect. In
+	// case 0 or negative value is set, DefaultRedirectMaxAge is
+	// used
+	RedirectMaxAge time.Duration
+
+	// TrustedDomains are the domains, along with their subdomains,
+	// to which browsers are redirected right away. In case it is
+	// not empty, browsers following links to other domains are
+	// shown the preview page first, as for links with preview
+	TrustedDomains []string
+
+	// PreviewKey signs the continue button of the preview page,
+	// which skips the forced preview for a short time. Instances
+	// serving the same links should share it. In case it is
+	// empty, random key is generated, so the button works only on
+	// the instance which served the page
+	PreviewKey string
+
+	// Workspaces are the tenants of the deployment. Requests are
+	// assigned to a workspace by their API key, sent as bearer
+	// authorization, or by their host, and see only its links.
+	// Managing the links of workspaces with API keys requires one
+	// of them. In case it is nil, all links belong to the default
+	// workspace and the API keys are ignored
+	Workspaces *workspace.Registry
+}
+
+// NewHandler creates and returns http.Handler exposing the
+// REST API described in the Server interface. It does not
+// own the lifecycle of the provided DB worker, so the caller
+// is responsible for shutting it down.
+// Params:
+//   - dbWorker: storage layer used for registering and
+//     looking up URL aliases
+//   - options: tunable parameters of the handler
+//   - logger: logger used for reporting errors. In case nil
+//     is passed, a logger writing to the standard error with
+//     the standard flags is used
+func NewHandler(dbWorker db.Worker, options Options, logger *log.Logger) http.Handler {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if options.MaxBodySize <= 0 {
+		options.MaxBodySize = DefaultMaxBodySize
+	}
+
+	if options.MaxURLLength <= 0 {
+		options.MaxURLLength = DefaultMaxURLLength
+	}
+
+	if options.NotActiveStatus < 400 || options.NotActiveStatus > 599 {
+		options.NotActiveStatus = DefaultNotActiveStatus
+	}
+
+	if !service.ValidRedirectStatus(options.RedirectStatus) {
+		options.RedirectStatus = DefaultRedirectStatus
+	}
+
+	if options.RedirectMaxAge <= 0 {
+		options.RedirectMaxAge = DefaultRedirectMaxAge
+	}
+
+	openAPI, err := newOpenAPIDocument(options.PathPrefix)
+	if err != nil {
+		log.Panicf("Bad OpenAPI document: %v", err)
+	}
+
+	if options.LinkService == nil {
+		serviceOptions := service.Options{MaxURLLength: options.MaxURLLength, Logger: logger}
+		if options.PublicURL != "" {
+			serviceOptions.OwnHosts = []string{options.PublicURL}
+		}
+		options.LinkService = service.New(dbWorker, serviceOptions)
+	}
+
+	previewKey := []byte(options.PreviewKey)
+	if len(previewKey) == 0 {
+		previewKey = make([]byte, 32)
+		if _, err := rand.Read(previewKey); err != nil {
+			log.Panicf("Error while generating preview key: %v", err)
+		}
+	}
+
+	handler := &api{
+		linkService: options.LinkService,
+		options:     options,
+		logger:      logger,
+		openAPI:     openAPI,
+		previewKey:  previewKey,
+	}
+
+	r := mux.NewRouter()
+	s := r.PathPrefix(options.PathPrefix + "/api").Subrouter()
+	s.Use(handler.withWorkspace)
+	s.HandleFunc("/urls/{id}+", handler.previewURL).Methods("GET", "HEAD")
+	s.HandleFunc("/urls/{id}+", handler.unlockURL).Methods("POST")
+	s.HandleFunc("/urls/{id}", handler.getURL).Methods("GET", "HEAD")
+	s.HandleFunc("/urls/{id}", handler.unlockURL).Methods("POST")
+	s.HandleFunc("/urls/{id}/qr", handler.getQR).Methods("GET")
+	s.HandleFunc("/urls", handler.managed(handler.addURL)).Methods("POST")
+	s.HandleFunc("/v2/links/{id}", handler.managed(handler.getLink)).Methods("GET")
+	s.HandleFunc("/v2/links/{id}", handler.managed(handler.updateLink)).Methods("PATCH")
+	s.HandleFunc("/v2/links/{id}", handler.managed(handler.deleteLink)).Methods("DELETE")
+	s.HandleFunc("/v2/links/{id}/stats", handler.managed(handler.getStats)).Methods("GET")
+	s.HandleFunc("/v2/links", handler.managed(handler.listLinks)).Methods("GET")
+	s.HandleFunc("/v2/links", handler.managed(handler.createLink)).Methods("POST")
+	s.HandleFunc("/v2/domains/{host}", handler.deployment(handler.getDomain)).Methods("GET")
+	s.HandleFunc("/v2/domains/{host}", handler.deployment(handler.deleteDomain)).Methods("DELETE")
+	s.HandleFunc("/v2/domains", handler.deployment(handler.listDomains)).Methods("GET")
+	s.HandleFunc("/v2/domains", handler.deployment(handler.createDomain)).Methods("POST")
+	s.HandleFunc("/openapi.json", handler.getOpenAPI).Methods("GET")
+	if options.SwaggerUI != "" {
+		s.HandleFunc("/docs", handler.getDocs).Methods("GET")
+		s.HandleFunc("/docs/{asset}", handler.getDocsAsset).Methods("GET")
+	}
+	r.NotFoundHandler = http.HandlerFunc(handler.notFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(handler.methodNotAllowed)
+	handler.router = r
+	handler.chain = withCORS(r, options.CORS)
+
+	return handler
+}
+
+type api struct {
+	linkService *service.Service
+	options     Options
+	logger      *log.Logger
+	router      *mux.Router
+	chain       http.Handler
+	openAPI     []byte
+	previewKey  []byte
+}
+
+type payload struct {
+	ID        string     `json:"id"`
+	URL       string     `json:"url"`
+	Tags      []string   `json:"tags,omitempty"`
+	Owner     string     `json:"owner,omitempty"`
+	Password  string     `json:"password,omitempty"`
+	MaxClicks int64      `json:"max_clicks,omitempty"`
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	Targets map[string]string `json:"targets,omitempty"`
+
+	Variants       []variantPayload `json:"variants,omitempty"`
+	StickyVariants bool             `json:"sticky_variants,omitempty"`
+
+	ForwardQuery  bool              `json:"forward_query,omitempty"`
+	QueryOverride bool              `json:"query_override,omitempty"`
+	UTM           map[string]string `json:"utm,omitempty"`
+
+	RedirectStatus int  `json:"redirect_status,omitempty"`
+	Preview        bool `json:"preview,omitempty"`
+}
+
+func (handler *api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler.chain.ServeHTTP(w, r)
+}
+
+func (handler *api) getURL(w http.ResponseWriter, r *http.Request) {
+	handler.resolve(w, r, r.Header.Get(passwordHeader), 0, r.URL.Query().Get(previewParam) == "1")
+}
+
+func (handler *api) addURL(w http.ResponseWriter, r *http.Request) {
+	var b payload
+	if !handler.decode(w, r, &b) {
+		return
+	}
+
+	link, ok := handler.create(w, r, b)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("location", handler.shortURL(r, link.ID))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (handler *api) decode(w http.ResponseWriter, r *http.Request, v interface{}) (ok bool) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, handler.options.MaxBodySize+1))
+	if err != nil {
+		handler.writeInternalError(w, r, "Error while reading data: %v", err)
+		return
+	}
+
+	if int64(len(body)) > handler.options.MaxBodySize {
+		handler.writeProblem(w, r, problem{
+			Status: http.StatusRequestEntityTooLarge,
+			Code:   codeBodyTooLarge,
+			Detail: fmt.Sprintf("Request body exceeds the limit of %v bytes", handler.options.MaxBodySize),
+		})
+		return
+	}
+
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		handler.writeProblem(w, r, problem{
+			Status: http.StatusBadRequest,
+			Code:   codeInvalidJSON,
+			Detail: fmt.Sprintf("Request body is not valid JSON: %v", err),
+		})
+		return
+	}
+
+	ok = true
+
+	return
+}
+
+func (handler *api) create(w http.ResponseWriter, r *http.Request, b payload) (link *db.Link, ok bool) {
+	req := service.Request{
+		ID:        b.ID,
+		URL:       b.URL,
+		Tags:      b.Tags,
+		Owner:     b.Owner,
+		Password:  b.Password,
+		MaxClicks: b.MaxClicks,
+		Targets:   b.Targets,
+
+		Variants:       toVariants(b.Variants),
+		StickyVariants: b.StickyVariants,
+
+		ForwardQuery:  b.ForwardQuery,
+		QueryOverride: b.QueryOverride,
+		UTM:           b.UTM,
+
+		RedirectStatus: b.RedirectStatus,
+		Preview:        b.Preview,
+	}
+	if b.NotBefore != nil {
+		req.NotBefore = *b.NotBefore
+	}
+	if b.ExpiresAt != nil {
+		req.ExpiresAt = *b.ExpiresAt
+	}
+
+	link, err := handler.links(r).Create(req)
+	if err != nil {
+		handler.writeServiceError(w, r, b, err, "Error while registering id %v for url %v: %v", b.ID, b.URL, err)
+		return
+	}
+
+	ok = true
+
+	return
+}
@@ -0,0 +1,436 @@
+package web_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/web"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+func TestNewHandlerGet(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "https://google.com")
+
+	handler := web.NewHandler(dbWorker, web.Options{}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/urls/cranki", nil))
+
+	if w.Code != 308 {
+		t.Errorf("Expected status code 308, received: %v", w.Code)
+	}
+
+	location := w.Header().Get("Location")
+	if location != "https://google.com" {
+		t.Errorf("Expected https://google.com, received %v", location)
+	}
+}
+
+func TestNewHandlerGetNonExistingEntry(t *testing.T) {
+	handler := web.NewHandler(testdata.NewMemoryWorker(), web.Options{}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/urls/cranki", nil))
+
+	if w.Code != 404 {
+		t.Errorf("Expected status code 404, received: %v", w.Code)
+	}
+}
+
+func TestNewHandlerGetMaxClicks(t *testing.T) {
+	handler := web.NewHandler(testdata.NewMemoryWorker(), web.Options{}, nil)
+
+	w := httptest.NewRecorder()
+	body := `{"id": "cranki", "url": "https://google.com", "max_clicks": 2}`
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/v2/links", bytes.NewBufferString(body)))
+
+	if w.Code != 201 {
+		t.Fatalf("Expected status code 201, received: %v", w.Code)
+	}
+
+	for _, expected := range []int{308, 308, 410, 410} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/urls/cranki", nil))
+
+		if w.Code != expected {
+			t.Errorf("Expected status code %v, received: %v", expected, w.Code)
+		}
+	}
+
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"code":"link_exhausted"`)) {
+		t.Errorf("Expected link_exhausted, received %v", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/links/cranki", nil))
+
+	var l struct {
+		ClickCount      int64  `json:"click_count"`
+		MaxClicks       int64  `json:"max_clicks"`
+		RemainingClicks *int64 `json:"remaining_clicks"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &l)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if l.ClickCount != 2 || l.MaxClicks != 2 || l.RemainingClicks == nil || *l.RemainingClicks != 0 {
+		t.Errorf("Expected exhausted link with 2 clicks, received %+v", l)
+	}
+}
+
+func TestNewHandlerGetTargets(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Create(&db.Link{ID: "appins", URL: "https://example.com/app", Targets: map[string]string{
+		"ios":     "https://apps.apple.com/app/id1",
+		"android": "https://play.google.com/store/apps/details?id=app",
+	}})
+	dbWorker.Register("cranki", "https://google.com")
+
+	handler := web.NewHandler(dbWorker, web.Options{}, nil)
+
+	tests := []struct {
+		id        string
+		userAgent string
+		location  string
+		vary      string
+	}{
+		{"appins", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)", "https://apps.apple.com/app/id1", "User-Agent"},
+		{"appins", "Mozilla/5.0 (Linux; Android 14; Pixel 8)", "https://play.google.com/store/apps/details?id=app", "User-Agent"},
+		{"appins", "Mozilla/5.0 (Windows NT 10.0; Win64; x64)", "https://example.com/app", "User-Agent"},
+		{"appins", "", "https://example.com/app", "User-Agent"},
+		{"cranki", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)", "https://google.com", ""},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/api/urls/"+test.id, nil)
+		r.Header.Set("User-Agent", test.userAgent)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != 308 {
+			t.Errorf("%v: expected status code 308, received: %v", test.userAgent, w.Code)
+		}
+
+		if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("%v: expected location %q, received %q", test.userAgent, test.location, location)
+		}
+
+		if vary := w.Header().Get("Vary"); vary != test.vary {
+			t.Errorf("%v: expected Vary %q, received %q", test.userAgent, test.vary, vary)
+		}
+	}
+}
+
+func TestNewHandlerGetVariants(t *testing.T) {
+	handler := web.NewHandler(testdata.NewMemoryWorker(), web.Options{}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/v2/links", bytes.NewBufferString(`{"id": "splits", "url": "https://example.com",
+		"variants": [{"url": "https://example.com/a"}, {"url": "https://example.com/b", "weight": 3}], "sticky_variants": true}`)))
+	if w.Code != 201 {
+		t.Fatalf("Expected status code 201, received: %v %v", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/urls/splits", nil))
+
+	location := w.Header().Get("Location")
+	if location != "https://example.com/a" && location != "https://example.com/b" {
+		t.Fatalf("Expected location of variant, received %q", location)
+	}
+
+	if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "no-store" {
+		t.Errorf("Expected Cache-Control no-store, received %q", cacheControl)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "link_variant" || cookies[0].Path != "/api/urls/splits" || location != "https://example.com/"+cookies[0].Value {
+		t.Fatalf("Expected variant cookie matching %v, received %v", location, cookies)
+	}
+
+	for i := 0; i < 10; i++ {
+		r := httptest.NewRequest("GET", "/api/urls/splits", nil)
+		r.AddCookie(cookies[0])
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Header().Get("Location") != location {
+			t.Fatalf("Expected sticky location %v, received %v", location, w.Header().Get("Location"))
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/links/splits/stats", nil))
+
+	var stats struct {
+		ClickCount int64 `json:"click_count"`
+		Variants   []struct {
+			Name       string `json:"name"`
+			ClickCount int64  `json:"click_count"`
+		} `json:"variants"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &stats)
+
+	if stats.ClickCount != 11 || len(stats.Variants) != 2 || stats.Variants[0].ClickCount+stats.Variants[1].ClickCount != 11 {
+		t.Errorf("Expected 11 clicks broken down by 2 variants, received %+v", stats)
+	}
+}
+
+func TestNewHandlerGetQuery(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Create(&db.Link{ID: "cranki", URL: "https://google.com/search?hl=en#results", ForwardQuery: true,
+		UTM: map[string]string{"utm_source": "shortener"}})
+	dbWorker.Register("plains", "https://bing.com/?q=a%20b")
+
+	handler := web.NewHandler(dbWorker, web.Options{}, nil)
+
+	tests := []struct {
+		target   string
+		location string
+	}{
+		{"/api/urls/cranki", "https://google.com/search?hl=en&utm_source=shortener#results"},
+		{"/api/urls/cranki?q=go+lang&hl=de", "https://google.com/search?hl=en&q=go+lang&utm_source=shortener#results"},
+		{"/api/urls/cranki?utm_source=mail&x=%2F%3F", "https://google.com/search?hl=en&utm_source=mail&x=%2F%3F#results"},
+		{"/api/urls/plains?q=c", "https://bing.com/?q=a%20b"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", test.target, nil))
+
+		if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("%v: expected location %q, received %q", test.target, test.location, location)
+		}
+	}
+}
+
+func TestNewHandlerRedirectStatus(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "https://google.com")
+	dbWorker.Create(&db.Link{ID: "moved", URL: "https://bing.com", RedirectStatus: 301})
+	dbWorker.Create(&db.Link{ID: "temp", URL: "https://yahoo.com", RedirectStatus: 307})
+	dbWorker.Create(&db.Link{ID: "soon", URL: "https://duckduckgo.com", ExpiresAt: time.Now().Add(time.Hour)})
+	dbWorker.Create(&db.Link{ID: "once", URL: "https://ecosia.org", MaxClicks: 1})
+
+	tests := []struct {
+		options      web.Options
+		id           string
+		status       int
+		cacheControl string
+	}{
+		{web.Options{}, "cranki", 308, "public, max-age=86400"},
+		{web.Options{RedirectMaxAge: time.Minute}, "cranki", 308, "public, max-age=60"},
+		{web.Options{RedirectStatus: 302}, "cranki", 302, "no-cache"},
+		{web.Options{RedirectStatus: 303}, "cranki", 308, "public, max-age=86400"},
+		{web.Options{RedirectStatus: 302}, "moved", 301, "public, max-age=86400"},
+		{web.Options{}, "temp", 307, "no-cache"},
+		{web.Options{}, "once", 308, "no-store"},
+	}
+
+	for _, test := range tests {
+		handler := web.NewHandler(dbWorker, test.options, nil)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("HEAD", "/api/urls/"+test.id, nil))
+
+		if w.Code != test.status {
+			t.Errorf("%v: expected status code %v, received: %v", test.id, test.status, w.Code)
+		}
+
+		if cacheControl := w.Header().Get("Cache-Control"); cacheControl != test.cacheControl {
+			t.Errorf("%v: expected Cache-Control %q, received %q", test.id, test.cacheControl, cacheControl)
+		}
+	}
+
+	handler := web.NewHandler(dbWorker, web.Options{}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/urls/soon", nil))
+
+	var maxAge int
+	fmt.Sscanf(w.Header().Get("Cache-Control"), "public, max-age=%d", &maxAge)
+	if maxAge <= 3500 || maxAge > 3600 {
+		t.Errorf("Expected max age bounded by the expiration, received %q", w.Header().Get("Cache-Control"))
+	}
+
+	for id, clicks := range map[string]int64{"cranki": 0, "once": 0, "soon": 1} {
+		link, _ := dbWorker.Get(id)
+		if link.ClickCount != clicks {
+			t.Errorf("%v: expected %v clicks, received %v", id, clicks, link.ClickCount)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/urls/once", nil))
+	if w.Code != 308 {
+		t.Errorf("Expected HEAD requests not to exhaust the link, received: %v", w.Code)
+	}
+}
+
+func TestNewHandlerGetActivationWindow(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Create(&db.Link{ID: "launch", URL: "https://google.com", NotBefore: time.Now().Add(time.Hour)})
+	dbWorker.Create(&db.Link{ID: "closed", URL: "https://bing.com", ExpiresAt: time.Now().Add(-time.Hour)})
+
+	tests := []struct {
+		options  web.Options
+		id       string
+		status   int
+		location string
+	}{
+		{web.Options{}, "launch", 403, ""},
+		{web.Options{NotActiveStatus: 404}, "launch", 404, ""},
+		{web.Options{NotActiveURL: "https://example.com/soon"}, "launch", 302, "https://example.com/soon"},
+		{web.Options{}, "closed", 410, ""},
+	}
+
+	for _, test := range tests {
+		handler := web.NewHandler(dbWorker, test.options, nil)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/urls/"+test.id, nil))
+
+		if w.Code != test.status {
+			t.Errorf("%v: expected status code %v, received: %v", test.id, test.status, w.Code)
+		}
+
+		if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("%v: expected location %q, received %q", test.id, test.location, location)
+		}
+
+		if test.id == "launch" && w.Header().Get("Retry-After") == "" {
+			t.Errorf("%v: expected Retry-After header", test.id)
+		}
+	}
+}
+
+func TestNewHandlerPost(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+
+	handler := web.NewHandler(dbWorker, web.Options{}, nil)
+
+	jsonBody, err := json.Marshal(map[string]string{
+		"id":  "cranki",
+		"url": "http://testurl.com",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/urls", bytes.NewBuffer(jsonBody)))
+
+	if w.Code != 201 {
+		t.Errorf("Expected status code 201, received: %v", w.Code)
+	}
+
+	_, url, err := dbWorker.Find("id_to_url", "cranki")
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if url != "http://testurl.com" {
+		t.Errorf("Expected http://testurl.com, received %v", url)
+	}
+}
+
+func TestNewHandlerPostBlocked(t *testing.T) {
+	handler := web.NewHandler(testdata.NewMemoryWorker(), web.Options{}, nil)
+
+	for _, target := range []string{"/api/urls", "/api/v2/links"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", target, bytes.NewBufferString(`{"url": "http://169.254.169.254/latest/meta-data"}`)))
+
+		if w.Code != 422 {
+			t.Errorf("%v: expected status code 422, received: %v", target, w.Code)
+		}
+
+		if !strings.Contains(w.Body.String(), `"code":"url_blocked"`) {
+			t.Errorf("%v: expected url_blocked problem, received %v", target, w.Body.String())
+		}
+	}
+}
+
+func TestNewHandlerPostSelfLink(t *testing.T) {
+	handler := web.NewHandler(testdata.NewMemoryWorker(), web.Options{PublicURL: "https://s.example.com"}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/urls", bytes.NewBufferString(`{"url": "https://s.example.com/api/urls/cranki"}`)))
+
+	if w.Code != 422 {
+		t.Errorf("Expected status code 422, received: %v", w.Code)
+	}
+
+	if !strings.Contains(w.Body.String(), `"code":"url_self_reference"`) {
+		t.Errorf("Expected url_self_reference problem, received %v", w.Body.String())
+	}
+}
+
+func TestNewHandlerPathPrefix(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "https://google.com")
+
+	handler := web.NewHandler(dbWorker, web.Options{PathPrefix: "/shortener"}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/shortener/api/urls/cranki", nil))
+
+	if w.Code != 308 {
+		t.Errorf("Expected status code 308, received: %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/urls/cranki", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code 404, received: %v", w.Code)
+	}
+}
+
+func TestNewHandlerPostBodyTooLarge(t *testing.T) {
+	handler := web.NewHandler(testdata.NewMemoryWorker(), web.Options{MaxBodySize: 32}, nil)
+
+	jsonBody, err := json.Marshal(map[string]string{
+		"id":  "cranki",
+		"url": "http://testurl.com/a/rather/long/path",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/urls", bytes.NewBuffer(jsonBody)))
+
+	if w.Code != 413 {
+		t.Errorf("Expected status code 413, received: %v", w.Code)
+	}
+}
+
+func TestNewHandlerPostURLTooLong(t *testing.T) {
+	handler := web.NewHandler(testdata.NewMemoryWorker(), web.Options{MaxURLLength: 16}, nil)
+
+	jsonBody, err := json.Marshal(map[string]string{
+		"id":  "cranki",
+		"url": "http://testurl.com/a/rather/long/path",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/urls", bytes.NewBuffer(jsonBody)))
+
+	if w.Code != 400 {
+		t.Errorf("Expected status code 400, received: %v", w.Code)
+	}
+}
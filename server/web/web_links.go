@@ -0,0 +1,382 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/service"
+	"github.com/gorilla/mux"
+)
+
+// linkResource is the representation of a link in /api/v2
+type linkResource struct {
+	ID         string    `json:"id"`
+	ShortURL   string    `json:"short_url"`
+	URL        string    `json:"url"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	ClickCount int64     `json:"click_count"`
+	Tags       []string  `json:"tags"`
+	Owner      string    `json:"owner"`
+	Protected  bool      `json:"protected"`
+
+	// MaxClicks and RemainingClicks are present only for links
+	// with maximum clicks
+	MaxClicks       int64  `json:"max_clicks,omitempty"`
+	RemainingClicks *int64 `json:"remaining_clicks,omitempty"`
+
+	// NotBefore is present only for links with scheduled
+	// activation
+	NotBefore *time.Time `json:"not_before,omitempty"`
+
+	// Targets is present only for links with platform targets
+	Targets map[string]string `json:"targets,omitempty"`
+
+	// Variants and StickyVariants are present only for links
+	// with variants
+	Variants       []variantResource `json:"variants,omitempty"`
+	StickyVariants bool              `json:"sticky_variants,omitempty"`
+
+	// Query settings are present only when they are set
+	ForwardQuery  bool              `json:"forward_query,omitempty"`
+	QueryOverride bool              `json:"query_override,omitempty"`
+	UTM           map[string]string `json:"utm,omitempty"`
+
+	// RedirectStatus is present only for links with own status
+	RedirectStatus int `json:"redirect_status,omitempty"`
+
+	// Preview is present only for links forcing the preview page
+	Preview bool `json:"preview,omitempty"`
+
+	// Check is present only for links whose destination has
+	// been checked
+	Check *checkResource `json:"check,omitempty"`
+}
+
+// checkResource is the representation of the last health check
+// of the destination of a link in /api/v2. Status 0 means the
+// destination could not be reached
+type checkResource struct {
+	Status    int       `json:"status"`
+	Healthy   bool      `json:"healthy"`
+	LatencyMS int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// linkList is the representation of a page of links in /api/v2
+type linkList struct {
+	Links  []linkResource `json:"links"`
+	Offset int            `json:"offset"`
+	Limit  int            `json:"limit"`
+}
+
+// linkUpdate is the payload for updating a link in /api/v2
+type linkUpdate struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// linkStats is the representation of the link statistics in /api/v2
+type linkStats struct {
+	ID         string    `json:"id"`
+	ClickCount int64     `json:"click_count"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+
+	// Variants break the click count down by variant and are
+	// present only for links with variants
+	Variants []variantResource `json:"variants,omitempty"`
+}
+
+func (handler *api) getLink(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	link, err := handler.links(r).DB().Get(id)
+	if err != nil {
+		handler.writeInternalError(w, r, "Error while retrieving data for id %v: %v", id, err)
+		return
+	}
+
+	if link == nil {
+		handler.writeProblem(w, r, problem{
+			Status: http.StatusNotFound,
+			Code:   codeNotFound,
+			Detail: fmt.Sprintf("ID %v does not exist", id),
+			ID:     id,
+		})
+		return
+	}
+
+	handler.writeLink(w, r, http.StatusOK, link)
+}
+
+func (handler *api) createLink(w http.ResponseWriter, r *http.Request) {
+	var b payload
+	if !handler.decode(w, r, &b) {
+		return
+	}
+
+	link, ok := handler.create(w, r, b)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("location", fmt.Sprintf("%v/api/v2/links/%v", handler.options.PathPrefix, link.ID))
+	handler.writeLink(w, r, http.StatusCreated, link)
+}
+
+func (handler *api) updateLink(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var update linkUpdate
+	if !handler.decode(w, r, &update) {
+		return
+	}
+
+	if update.ExpiresAt == nil {
+		handler.writeValidationProblem(w, r, payload{ID: id}, []service.FieldError{{
+			Field:  "expires_at",
+			Code:   service.CodeExpirationInvalid,
+			Detail: "Expiration time is required",
+		}})
+		return
+	}
+
+	link, err := handler.links(r).Extend(id, *update.ExpiresAt)
+	if err != nil {
+		handler.writeServiceError(w, r, payload{ID: id}, err, "Error while extending id %v: %v", id, err)
+		return
+	}
+
+	if link == nil {
+		handler.writeProblem(w, r, problem{
+			Status: http.StatusNotFound,
+			Code:   codeNotFound,
+			Detail: fmt.Sprintf("ID %v does not exist", id),
+			ID:     id,
+		})
+		return
+	}
+
+	handler.writeLink(w, r, http.StatusOK, link)
+}
+
+func (handler *api) deleteLink(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	deleted, err := handler.links(r).DB().Delete(id)
+	if err != nil {
+		handler.writeInternalError(w, r, "Error while deleting id %v: %v", id, err)
+		return
+	}
+
+	if !deleted {
+		handler.writeProblem(w, r, problem{
+			Status: http.StatusNotFound,
+			Code:   codeNotFound,
+			Detail: fmt.Sprintf("ID %v does not exist", id),
+			ID:     id,
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (handler *api) listLinks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := db.ListFilter{
+		Owner: query.Get("owner"),
+		Tag:   query.Get("tag"),
+		Limit: service.DefaultListLimit,
+	}
+
+	var errs []service.FieldError
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > service.MaxListLimit {
+			errs = append(errs, service.FieldError{
+				Field:  "limit",
+				Code:   codeInvalidParameter,
+				Detail: fmt.Sprintf("Invalid limit: %v. It should be integer between 1 and %v", v, service.MaxListLimit),
+			})
+		}
+		filter.Limit = limit
+	}
+
+	if v := query.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			errs = append(errs, service.FieldError{
+				Field:  "offset",
+				Code:   codeInvalidParameter,
+				Detail: fmt.Sprintf("Invalid offset: %v. It should be non-negative integer", v),
+			})
+		}
+		filter.Offset = offset
+	}
+
+	if len(errs) != 0 {
+		handler.writeValidationProblem(w, r, payload{}, errs)
+		return
+	}
+
+	links, err := handler.links(r).List(filter)
+	if err != nil {
+		handler.writeServiceError(w, r, payload{}, err, "Error while listing links: %v", err)
+		return
+	}
+
+	list := linkList{
+		Links:  make([]linkResource, 0, len(links)),
+		Offset: filter.Offset,
+		Limit:  filter.Limit,
+	}
+	for _, link := range links {
+		list.Links = append(list.Links, handler.resource(r, link))
+	}
+
+	handler.writeJSON(w, r, http.StatusOK, list)
+}
+
+func (handler *api) getStats(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	link, err := handler.links(r).DB().Get(id)
+	if err != nil {
+		handler.writeInternalError(w, r, "Error while retrieving data for id %v: %v", id, err)
+		return
+	}
+
+	if link == nil {
+		handler.writeProblem(w, r, problem{
+			Status: http.StatusNotFound,
+			Code:   codeNotFound,
+			Detail: fmt.Sprintf("ID %v does not exist", id),
+			ID:     id,
+		})
+		return
+	}
+
+	handler.writeJSON(w, r, http.StatusOK, linkStats{
+		ID:         link.ID,
+		ClickCount: link.ClickCount,
+		CreatedAt:  link.CreatedAt.UTC(),
+		ExpiresAt:  link.ExpiresAt.UTC(),
+		Variants:   variantResources(link.Variants),
+	})
+}
+
+func (handler *api) writeLink(w http.ResponseWriter, r *http.Request, status int, link *db.Link) {
+	handler.writeJSON(w, r, status, handler.resource(r, link))
+}
+
+func (handler *api) writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	vJSON, err := json.Marshal(v)
+	if err != nil {
+		handler.writeInternalError(w, r, "Bad JSON format: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(vJSON)
+}
+
+func (handler *api) resource(r *http.Request, link *db.Link) linkResource {
+	tags := link.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	resource := linkResource{
+		ID:         link.ID,
+		ShortURL:   handler.shortURL(r, link.ID),
+		URL:        link.URL,
+		CreatedAt:  link.CreatedAt.UTC(),
+		ExpiresAt:  link.ExpiresAt.UTC(),
+		ClickCount: link.ClickCount,
+		Tags:       tags,
+		Owner:      link.Owner,
+		Protected:  link.PasswordHash != "",
+		Targets:    link.Targets,
+
+		Variants:       variantResources(link.Variants),
+		StickyVariants: link.StickyVariants,
+
+		ForwardQuery:  link.ForwardQuery,
+		QueryOverride: link.QueryOverride,
+		UTM:           link.UTM,
+
+		RedirectStatus: link.RedirectStatus,
+		Preview:        link.Preview,
+	}
+
+	if !link.NotBefore.IsZero() {
+		notBefore := link.NotBefore.UTC()
+		resource.NotBefore = &notBefore
+	}
+
+	if link.MaxClicks > 0 {
+		remaining := link.MaxClicks - link.ClickCount
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		resource.MaxClicks = link.MaxClicks
+		resource.RemainingClicks = &remaining
+	}
+
+	if !link.LastCheck.CheckedAt.IsZero() {
+		resource.Check = &checkResource{
+			Status:    link.LastCheck.Status,
+			Healthy:   link.LastCheck.Healthy(),
+			LatencyMS: link.LastCheck.Latency.Milliseconds(),
+			CheckedAt: link.LastCheck.CheckedAt.UTC(),
+		}
+	}
+
+	return resource
+}
+
+// shortURL returns the absolute short url of the link
+func (handler *api) shortURL(r *http.Request, id string) string {
+	return fmt.Sprintf("%v%v/api/urls/%v", handler.publicURL(r), handler.options.PathPrefix, id)
+}
+
+// publicURL returns the scheme and host of the short urls. The
+// links of workspaces with own hosts and of branded domains are
+// served by their host with the scheme of the public url
+func (handler *api) publicURL(r *http.Request) string {
+	if host := servingHost(r); host != "" {
+		return fmt.Sprintf("%v://%v", handler.scheme(r), host)
+	}
+
+	if handler.options.PublicURL != "" {
+		return strings.TrimSuffix(handler.options.PublicURL, "/")
+	}
+
+	return fmt.Sprintf("%v://%v", handler.scheme(r), r.Host)
+}
+
+// scheme returns the scheme of the short urls, the one of the
+// public url or of the request in case it is empty
+func (handler *api) scheme(r *http.Request) string {
+	if u, err := url.Parse(handler.options.PublicURL); err == nil && u.Scheme != "" {
+		return u.Scheme
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+
+	return scheme
+}
@@ -0,0 +1,167 @@
+package web_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/web"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+type link struct {
+	ID         string    `json:"id"`
+	ShortURL   string    `json:"short_url"`
+	URL        string    `json:"url"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	ClickCount int64     `json:"click_count"`
+	Tags       []string  `json:"tags"`
+	Owner      string    `json:"owner"`
+}
+
+func TestCreateLink(t *testing.T) {
+	handler := web.NewHandler(testdata.NewMemoryWorker(), web.Options{PublicURL: "https://s.example.com"}, nil)
+
+	body := `{"id": "cranki", "url": "http://testurl.com", "tags": ["docs", "q3"], "owner": "cranki"}`
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/v2/links", bytes.NewBufferString(body)))
+
+	if w.Code != 201 {
+		t.Fatalf("Expected status code 201, received: %v", w.Code)
+	}
+
+	location := w.Header().Get("Location")
+	if location != "/api/v2/links/cranki" {
+		t.Errorf("Expected /api/v2/links/cranki, received %v", location)
+	}
+
+	var l link
+	err := json.Unmarshal(w.Body.Bytes(), &l)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if l.ShortURL != "https://s.example.com/api/urls/cranki" {
+		t.Errorf("Expected https://s.example.com/api/urls/cranki, received %v", l.ShortURL)
+	}
+	if l.URL != "http://testurl.com" {
+		t.Errorf("Expected http://testurl.com, received %v", l.URL)
+	}
+	if len(l.Tags) != 2 || l.Tags[0] != "docs" || l.Tags[1] != "q3" {
+		t.Errorf("Expected [docs q3], received %v", l.Tags)
+	}
+	if l.Owner != "cranki" {
+		t.Errorf("Expected cranki, received %v", l.Owner)
+	}
+	if !l.ExpiresAt.After(l.CreatedAt) {
+		t.Errorf("Expected expires_at after created_at, received %v and %v", l.ExpiresAt, l.CreatedAt)
+	}
+}
+
+func TestCreateLinkInvalidTag(t *testing.T) {
+	handler := web.NewHandler(testdata.NewMemoryWorker(), web.Options{}, nil)
+
+	body := `{"url": "http://testurl.com", "tags": ["a,b"]}`
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/v2/links", bytes.NewBufferString(body)))
+
+	if w.Code != 400 {
+		t.Errorf("Expected status code 400, received: %v", w.Code)
+	}
+
+	p := decodeProblem(t, w)
+	if p.Code != "tag_invalid" {
+		t.Errorf("Expected tag_invalid, received %v", p.Code)
+	}
+}
+
+func TestGetLink(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "https://google.com")
+
+	handler := web.NewHandler(dbWorker, web.Options{}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/urls/cranki", nil))
+
+	if w.Code != 308 {
+		t.Errorf("Expected status code 308, received: %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://s.example.com/api/v2/links/cranki", nil))
+
+	if w.Code != 200 {
+		t.Fatalf("Expected status code 200, received: %v", w.Code)
+	}
+
+	var l link
+	err := json.Unmarshal(w.Body.Bytes(), &l)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if l.ClickCount != 1 {
+		t.Errorf("Expected 1, received %v", l.ClickCount)
+	}
+	if l.ShortURL != "http://s.example.com/api/urls/cranki" {
+		t.Errorf("Expected http://s.example.com/api/urls/cranki, received %v", l.ShortURL)
+	}
+	if l.Tags == nil {
+		t.Errorf("Expected empty tags, received nil")
+	}
+}
+
+func TestGetLinkCheck(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "https://google.com")
+
+	handler := web.NewHandler(dbWorker, web.Options{}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/links/cranki", nil))
+
+	if bytes.Contains(w.Body.Bytes(), []byte(`"check"`)) {
+		t.Errorf("Expected no check of unchecked link, received %v", w.Body.String())
+	}
+
+	checkedAt := time.Date(2019, 8, 1, 10, 0, 0, 0, time.UTC)
+	dbWorker.RecordCheck("cranki", db.Check{Status: 404, Latency: 120 * time.Millisecond, CheckedAt: checkedAt})
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/links/cranki", nil))
+
+	var l struct {
+		Check struct {
+			Status    int       `json:"status"`
+			Healthy   bool      `json:"healthy"`
+			LatencyMS int64     `json:"latency_ms"`
+			CheckedAt time.Time `json:"checked_at"`
+		} `json:"check"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &l)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if l.Check.Status != 404 || l.Check.Healthy || l.Check.LatencyMS != 120 || !l.Check.CheckedAt.Equal(checkedAt) {
+		t.Errorf("Unexpected check: %+v", l.Check)
+	}
+}
+
+func TestGetLinkNonExistingEntry(t *testing.T) {
+	handler := web.NewHandler(testdata.NewMemoryWorker(), web.Options{}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/links/cranki", nil))
+
+	if w.Code != 404 {
+		t.Errorf("Expected status code 404, received: %v", w.Code)
+	}
+}
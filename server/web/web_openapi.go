@@ -0,0 +1,2042 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gorilla/mux"
+)
+
+// openAPISpec is OpenAPI 3 description of every route registered
+// by NewHandler. It is served at /api/openapi.json with servers
+// pointing to the configured path prefix
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "URL shortener",
+    "description": "REST API for registering and accessing URL aliases",
+    "version": "2.0.0"
+  },
+  "paths": {
+    "/api/urls": {
+      "post": {
+        "summary": "Register URL alias (v1)",
+        "operationId": "addURL",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/LinkRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "Alias registered",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Absolute short url of the alias on the domain of the request"
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "403": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "409": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "413": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "422": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        },
+        "security": [
+          {},
+          {
+            "apiKey": []
+          }
+        ]
+      }
+    },
+    "/api/urls/{id}": {
+      "get": {
+        "summary": "Redirect to the URL registered under the alias (v1)",
+        "operationId": "getURL",
+        "parameters": [
+          {
+            "$ref": "#/components/parameters/ID"
+          },
+          {
+            "name": "X-Link-Password",
+            "in": "header",
+            "required": false,
+            "schema": {
+              "type": "string"
+            },
+            "description": "Password of protected link"
+          },
+          {
+            "name": "preview",
+            "in": "query",
+            "required": false,
+            "schema": {
+              "type": "string"
+            },
+            "description": "1 requests the preview page instead of the redirect, the short-lived token set by the continue button of the preview page skips the preview page forced for the link"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Preview page for ?preview=1, and for browsers following links with preview or links to domains which are not trusted by the server",
+            "content": {
+              "text/html": {
+                "schema": {
+                  "type": "string"
+                }
+              }
+            }
+          },
+          "301": {
+            "description": "Permanent redirect of links with redirect_status 301",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Registered URL or platform target"
+              },
+              "Vary": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "User-Agent for links with platform targets"
+              },
+              "Set-Cookie": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "link_variant cookie with the assigned variant for links with sticky variants"
+              },
+              "Cache-Control": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "public with max-age bounded by the expiration of the link for permanent redirects, no-cache for temporary redirects, no-store for protected links and links with max_clicks or variants"
+              }
+            }
+          },
+          "302": {
+            "description": "Temporary redirect of links with redirect_status 302, or to the configured page for links which have not been activated yet",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Configured page"
+              },
+              "Retry-After": {
+                "schema": {
+                  "type": "integer"
+                },
+                "description": "Seconds until the activation"
+              },
+              "Vary": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "User-Agent for links with platform targets"
+              },
+              "Set-Cookie": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "link_variant cookie with the assigned variant for links with sticky variants"
+              },
+              "Cache-Control": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "public with max-age bounded by the expiration of the link for permanent redirects, no-cache for temporary redirects, no-store for protected links and links with max_clicks or variants"
+              }
+            }
+          },
+          "307": {
+            "description": "Temporary redirect of links with redirect_status 307",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Registered URL or platform target"
+              },
+              "Vary": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "User-Agent for links with platform targets"
+              },
+              "Set-Cookie": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "link_variant cookie with the assigned variant for links with sticky variants"
+              },
+              "Cache-Control": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "public with max-age bounded by the expiration of the link for permanent redirects, no-cache for temporary redirects, no-store for protected links and links with max_clicks or variants"
+              }
+            }
+          },
+          "308": {
+            "description": "Redirect with the default status of the server or the status of the link",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Registered URL or platform target"
+              },
+              "Vary": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "User-Agent for links with platform targets"
+              },
+              "Set-Cookie": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "link_variant cookie with the assigned variant for links with sticky variants"
+              },
+              "Cache-Control": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "public with max-age bounded by the expiration of the link for permanent redirects, no-cache for temporary redirects, no-store for protected links and links with max_clicks or variants"
+              }
+            }
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "403": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "404": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "410": {
+            "$ref": "#/components/responses/Gone"
+          },
+          "429": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        },
+        "description": "Protected links require the password in the X-Link-Password header. Browsers sending Accept: text/html receive unlock form instead of the 401, 403 and 429 problems. Links which have not been activated yet are rejected with the configured status (403 by default) or redirect to the configured page, expired and exhausted links are rejected with 410. Links with platform targets redirect iOS, Android and desktop clients to the respective targets and all other clients to the registered URL. Links with variants redirect the other clients to variant picked by the weights, keeping the variant from the link_variant cookie for links with sticky variants. Links with forward_query merge the query of the request into the query of the target, keeping the target parameters on conflict unless query_override is set, and the utm parameters are appended when neither sets them. The fragment of the target is kept. The status of the redirect is the default of the server, 308 unless configured, or the redirect_status of the link. Browsers sending Accept: text/html are shown the preview page with the destination instead of the redirect for links with preview and, when the server is configured with trusted domains, for destinations outside of them. The continue button of the page leads back with signed token in the preview parameter, which skips the preview for 10 minutes. The preview query parameter is not forwarded."
+      },
+      "head": {
+        "summary": "Inspect the redirect of the alias without counting click (v1)",
+        "operationId": "headURL",
+        "parameters": [
+          {
+            "$ref": "#/components/parameters/ID"
+          },
+          {
+            "name": "X-Link-Password",
+            "in": "header",
+            "required": false,
+            "schema": {
+              "type": "string"
+            },
+            "description": "Password of protected link"
+          }
+        ],
+        "responses": {
+          "301": {
+            "description": "Permanent redirect of links with redirect_status 301",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Registered URL or platform target"
+              },
+              "Vary": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "User-Agent for links with platform targets"
+              },
+              "Set-Cookie": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "link_variant cookie with the assigned variant for links with sticky variants"
+              },
+              "Cache-Control": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "public with max-age bounded by the expiration of the link for permanent redirects, no-cache for temporary redirects, no-store for protected links and links with max_clicks or variants"
+              }
+            }
+          },
+          "302": {
+            "description": "Temporary redirect of links with redirect_status 302, or to the configured page for links which have not been activated yet",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Configured page"
+              },
+              "Retry-After": {
+                "schema": {
+                  "type": "integer"
+                },
+                "description": "Seconds until the activation"
+              },
+              "Vary": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "User-Agent for links with platform targets"
+              },
+              "Set-Cookie": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "link_variant cookie with the assigned variant for links with sticky variants"
+              },
+              "Cache-Control": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "public with max-age bounded by the expiration of the link for permanent redirects, no-cache for temporary redirects, no-store for protected links and links with max_clicks or variants"
+              }
+            }
+          },
+          "307": {
+            "description": "Temporary redirect of links with redirect_status 307",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Registered URL or platform target"
+              },
+              "Vary": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "User-Agent for links with platform targets"
+              },
+              "Set-Cookie": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "link_variant cookie with the assigned variant for links with sticky variants"
+              },
+              "Cache-Control": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "public with max-age bounded by the expiration of the link for permanent redirects, no-cache for temporary redirects, no-store for protected links and links with max_clicks or variants"
+              }
+            }
+          },
+          "308": {
+            "description": "Redirect with the default status of the server or the status of the link",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Registered URL or platform target"
+              },
+              "Vary": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "User-Agent for links with platform targets"
+              },
+              "Set-Cookie": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "link_variant cookie with the assigned variant for links with sticky variants"
+              },
+              "Cache-Control": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "public with max-age bounded by the expiration of the link for permanent redirects, no-cache for temporary redirects, no-store for protected links and links with max_clicks or variants"
+              }
+            }
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "403": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "404": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "410": {
+            "$ref": "#/components/responses/Gone"
+          },
+          "429": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        },
+        "description": "Sends the same status and headers as GET without body. It is not counted as click and does not assign variant."
+      },
+      "post": {
+        "summary": "Unlock protected link and redirect to its URL (v1)",
+        "description": "Target of the unlock form served by GET for protected links. Failed attempts are rate limited per link.",
+        "operationId": "unlockURL",
+        "parameters": [
+          {
+            "$ref": "#/components/parameters/ID"
+          }
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/x-www-form-urlencoded": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "password"
+                ],
+                "properties": {
+                  "password": {
+                    "type": "string"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "302": {
+            "description": "Temporary redirect to the configured page for links which have not been activated yet",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Configured page"
+              },
+              "Retry-After": {
+                "schema": {
+                  "type": "integer"
+                },
+                "description": "Seconds until the activation"
+              }
+            }
+          },
+          "303": {
+            "description": "Redirect to the registered URL",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Registered URL"
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "403": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "404": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "410": {
+            "$ref": "#/components/responses/Gone"
+          },
+          "429": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        }
+      }
+    },
+    "/api/urls/{id}+": {
+      "get": {
+        "summary": "Preview the destination of the alias without counting click (v1)",
+        "operationId": "previewURL",
+        "parameters": [
+          {
+            "$ref": "#/components/parameters/ID"
+          },
+          {
+            "name": "X-Link-Password",
+            "in": "header",
+            "required": false,
+            "schema": {
+              "type": "string"
+            },
+            "description": "Password of protected link"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Preview page",
+            "content": {
+              "text/html": {
+                "schema": {
+                  "type": "string"
+                }
+              }
+            }
+          },
+          "302": {
+            "description": "Temporary redirect to the configured page for links which have not been activated yet",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Configured page"
+              },
+              "Retry-After": {
+                "schema": {
+                  "type": "integer"
+                },
+                "description": "Seconds until the activation"
+              }
+            }
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "403": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "404": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "410": {
+            "$ref": "#/components/responses/Gone"
+          },
+          "429": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        },
+        "description": "Page showing the destination of the link, its domain and creation date, with continue button leading to the redirect. Protected links require the password as for the redirect. The password posted from the unlock form redirects right away."
+      },
+      "head": {
+        "summary": "Inspect the preview page of the alias (v1)",
+        "operationId": "headPreviewURL",
+        "parameters": [
+          {
+            "$ref": "#/components/parameters/ID"
+          },
+          {
+            "name": "X-Link-Password",
+            "in": "header",
+            "required": false,
+            "schema": {
+              "type": "string"
+            },
+            "description": "Password of protected link"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Preview page"
+          },
+          "302": {
+            "description": "Temporary redirect to the configured page for links which have not been activated yet",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Configured page"
+              },
+              "Retry-After": {
+                "schema": {
+                  "type": "integer"
+                },
+                "description": "Seconds until the activation"
+              }
+            }
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "403": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "404": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "410": {
+            "$ref": "#/components/responses/Gone"
+          },
+          "429": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        },
+        "description": "Same as GET, without the page."
+      },
+      "post": {
+        "summary": "Unlock protected link from its preview and redirect to its URL (v1)",
+        "description": "Target of the unlock form served by the preview of protected links. Failed attempts are rate limited per link.",
+        "operationId": "unlockPreviewURL",
+        "parameters": [
+          {
+            "$ref": "#/components/parameters/ID"
+          }
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/x-www-form-urlencoded": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "password"
+                ],
+                "properties": {
+                  "password": {
+                    "type": "string"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "302": {
+            "description": "Temporary redirect to the configured page for links which have not been activated yet",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Configured page"
+              },
+              "Retry-After": {
+                "schema": {
+                  "type": "integer"
+                },
+                "description": "Seconds until the activation"
+              }
+            }
+          },
+          "303": {
+            "description": "Redirect to the registered URL",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Registered URL"
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "403": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "404": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "410": {
+            "$ref": "#/components/responses/Gone"
+          },
+          "429": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        }
+      }
+    },
+    "/api/urls/{id}/qr": {
+      "get": {
+        "summary": "QR code of the short URL",
+        "operationId": "getQR",
+        "description": "Renders QR code encoding the absolute short URL of the link. The image is the same for the same link and parameters, so it is sent with ETag and can be cached. Requests with matching If-None-Match receive 304. Expired links are rejected with 410.",
+        "parameters": [
+          {
+            "$ref": "#/components/parameters/ID"
+          },
+          {
+            "name": "format",
+            "in": "query",
+            "required": false,
+            "schema": {
+              "type": "string",
+              "enum": [
+                "png",
+                "svg"
+              ],
+              "default": "png"
+            },
+            "description": "Image format"
+          },
+          {
+            "name": "size",
+            "in": "query",
+            "required": false,
+            "schema": {
+              "type": "integer",
+              "minimum": 32,
+              "maximum": 2048,
+              "default": 256
+            },
+            "description": "Width and height of the image in pixels. Codes with more modules than pixels are rendered larger"
+          },
+          {
+            "name": "level",
+            "in": "query",
+            "required": false,
+            "schema": {
+              "type": "string",
+              "enum": [
+                "L",
+                "M",
+                "Q",
+                "H"
+              ],
+              "default": "M"
+            },
+            "description": "Error correction level, recovering about 7%, 15%, 25% and 30% of the code"
+          },
+          {
+            "name": "margin",
+            "in": "query",
+            "required": false,
+            "schema": {
+              "type": "integer",
+              "minimum": 0,
+              "maximum": 32,
+              "default": 4
+            },
+            "description": "Width of the quiet zone around the code in modules"
+          },
+          {
+            "name": "If-None-Match",
+            "in": "header",
+            "required": false,
+            "schema": {
+              "type": "string"
+            },
+            "description": "ETag of cached image"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "QR code",
+            "headers": {
+              "ETag": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Entity tag of the image"
+              },
+              "Cache-Control": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "public with max-age of one day"
+              }
+            },
+            "content": {
+              "image/png": {
+                "schema": {
+                  "type": "string",
+                  "format": "binary"
+                }
+              },
+              "image/svg+xml": {
+                "schema": {
+                  "type": "string"
+                }
+              }
+            }
+          },
+          "304": {
+            "description": "The cached image is up to date",
+            "headers": {
+              "ETag": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Entity tag of the image"
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "404": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "410": {
+            "$ref": "#/components/responses/Gone"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        }
+      }
+    },
+    "/api/v2/links": {
+      "post": {
+        "summary": "Create link",
+        "operationId": "createLink",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/LinkRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "Link created",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Path of the link resource"
+              }
+            },
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Link"
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "403": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "409": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "413": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "422": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        },
+        "security": [
+          {},
+          {
+            "apiKey": []
+          }
+        ]
+      },
+      "get": {
+        "summary": "List links",
+        "operationId": "listLinks",
+        "parameters": [
+          {
+            "name": "owner",
+            "in": "query",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "tag",
+            "in": "query",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "limit",
+            "in": "query",
+            "schema": {
+              "type": "integer",
+              "minimum": 1,
+              "maximum": 1000,
+              "default": 50
+            }
+          },
+          {
+            "name": "offset",
+            "in": "query",
+            "schema": {
+              "type": "integer",
+              "minimum": 0,
+              "default": 0
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Page of links",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/LinkList"
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        },
+        "security": [
+          {},
+          {
+            "apiKey": []
+          }
+        ]
+      }
+    },
+    "/api/v2/links/{id}": {
+      "get": {
+        "summary": "Get link",
+        "operationId": "getLink",
+        "parameters": [
+          {
+            "$ref": "#/components/parameters/ID"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Link resource",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Link"
+                }
+              }
+            }
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "404": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        },
+        "security": [
+          {},
+          {
+            "apiKey": []
+          }
+        ]
+      },
+      "patch": {
+        "summary": "Extend link expiration",
+        "operationId": "updateLink",
+        "parameters": [
+          {
+            "$ref": "#/components/parameters/ID"
+          }
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/LinkUpdate"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "Updated link",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Link"
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "404": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "413": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        },
+        "security": [
+          {},
+          {
+            "apiKey": []
+          }
+        ]
+      },
+      "delete": {
+        "summary": "Delete link",
+        "operationId": "deleteLink",
+        "parameters": [
+          {
+            "$ref": "#/components/parameters/ID"
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "Link deleted"
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "404": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        },
+        "security": [
+          {},
+          {
+            "apiKey": []
+          }
+        ]
+      }
+    },
+    "/api/v2/links/{id}/stats": {
+      "get": {
+        "summary": "Get link statistics",
+        "operationId": "getStats",
+        "parameters": [
+          {
+            "$ref": "#/components/parameters/ID"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Link statistics",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/LinkStats"
+                }
+              }
+            }
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "404": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        },
+        "security": [
+          {},
+          {
+            "apiKey": []
+          }
+        ]
+      }
+    },
+    "/api/v2/domains": {
+      "post": {
+        "summary": "Register branded domain",
+        "operationId": "createDomain",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/DomainRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "Domain registered",
+            "headers": {
+              "Location": {
+                "schema": {
+                  "type": "string"
+                },
+                "description": "Path of the domain resource"
+              }
+            },
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Domain"
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "403": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "409": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "413": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        },
+        "security": [
+          {},
+          {
+            "apiKey": []
+          }
+        ]
+      },
+      "get": {
+        "summary": "List branded domains",
+        "operationId": "listDomains",
+        "responses": {
+          "200": {
+            "description": "All domains",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/DomainList"
+                }
+              }
+            }
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "403": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        },
+        "security": [
+          {},
+          {
+            "apiKey": []
+          }
+        ]
+      }
+    },
+    "/api/v2/domains/{host}": {
+      "get": {
+        "summary": "Get branded domain",
+        "operationId": "getDomain",
+        "parameters": [
+          {
+            "$ref": "#/components/parameters/Host"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Domain resource",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Domain"
+                }
+              }
+            }
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "403": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "404": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        },
+        "security": [
+          {},
+          {
+            "apiKey": []
+          }
+        ]
+      },
+      "delete": {
+        "summary": "Delete branded domain, keeping its links",
+        "operationId": "deleteDomain",
+        "parameters": [
+          {
+            "$ref": "#/components/parameters/Host"
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "Domain deleted"
+          },
+          "401": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "403": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "404": {
+            "$ref": "#/components/responses/Problem"
+          },
+          "500": {
+            "$ref": "#/components/responses/Problem"
+          }
+        },
+        "security": [
+          {},
+          {
+            "apiKey": []
+          }
+        ]
+      }
+    },
+    "/api/openapi.json": {
+      "get": {
+        "summary": "OpenAPI description of the API",
+        "operationId": "getOpenAPI",
+        "responses": {
+          "200": {
+            "description": "OpenAPI document",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/docs/{asset}": {
+      "get": {
+        "summary": "Asset of the Swagger UI page, registered only when enabled",
+        "operationId": "getDocsAsset",
+        "parameters": [
+          {
+            "name": "asset",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "enum": ["swagger-ui.css", "swagger-ui-bundle.js"]
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Asset of the swagger-ui-dist package"
+          },
+          "404": {
+            "$ref": "#/components/responses/Problem"
+          }
+        }
+      }
+    },
+    "/api/docs": {
+      "get": {
+        "summary": "Swagger UI page, registered only when enabled",
+        "operationId": "getDocs",
+        "responses": {
+          "200": {
+            "description": "HTML page",
+            "content": {
+              "text/html": {
+                "schema": {
+                  "type": "string"
+                }
+              }
+            }
+          }
+        }
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "ID": {
+        "name": "id",
+        "in": "path",
+        "required": true,
+        "schema": {
+          "type": "string"
+        }
+      },
+      "Host": {
+        "name": "host",
+        "in": "path",
+        "required": true,
+        "schema": {
+          "type": "string"
+        }
+      }
+    },
+    "responses": {
+      "Problem": {
+        "description": "RFC 7807 problem details",
+        "content": {
+          "application/problem+json": {
+            "schema": {
+              "$ref": "#/components/schemas/Problem"
+            }
+          }
+        }
+      },
+      "Gone": {
+        "description": "The link has reached its maximum clicks or expired. Expired links are kept for the retention period set by expired_retention in the database config (7 days by default), afterwards they are not found (404)",
+        "content": {
+          "application/problem+json": {
+            "schema": {
+              "$ref": "#/components/schemas/Problem"
+            }
+          }
+        }
+      }
+    },
+    "schemas": {
+      "LinkRequest": {
+        "type": "object",
+        "required": [
+          "url"
+        ],
+        "properties": {
+          "id": {
+            "type": "string",
+            "description": "Alias of exactly 6 alphanumeric characters, underscores or dashes. Generated when omitted"
+          },
+          "url": {
+            "type": "string",
+            "description": "URL to shorten"
+          },
+          "tags": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            },
+            "maxItems": 16
+          },
+          "owner": {
+            "type": "string"
+          },
+          "password": {
+            "type": "string",
+            "maxLength": 72,
+            "writeOnly": true,
+            "description": "Password required for resolving the link, stored hashed"
+          },
+          "max_clicks": {
+            "type": "integer",
+            "minimum": 0,
+            "description": "Number of redirects after which the link is exhausted (410), unlimited when 0 or missing"
+          },
+          "not_before": {
+            "type": "string",
+            "format": "date-time",
+            "description": "Time when the link gets active, immediately when missing"
+          },
+          "expires_at": {
+            "type": "string",
+            "format": "date-time",
+            "description": "Time when the link expires, the default period counted from the activation when missing"
+          },
+          "targets": {
+            "type": "object",
+            "description": "Urls which the platforms are redirected to instead of url. The platform is classified from the User-Agent header",
+            "properties": {
+              "ios": {
+                "type": "string",
+                "format": "uri"
+              },
+              "android": {
+                "type": "string",
+                "format": "uri"
+              },
+              "desktop": {
+                "type": "string",
+                "format": "uri"
+              }
+            },
+            "additionalProperties": false
+          },
+          "variants": {
+            "type": "array",
+            "minItems": 2,
+            "maxItems": 10,
+            "items": {
+              "$ref": "#/components/schemas/VariantRequest"
+            },
+            "description": "Weighted destinations which the traffic is split across instead of url"
+          },
+          "sticky_variants": {
+            "type": "boolean",
+            "description": "Keep visitors on the variant they were first redirected to with cookie"
+          },
+          "forward_query": {
+            "type": "boolean",
+            "description": "Merge the query of the short link into the query of the target"
+          },
+          "query_override": {
+            "type": "boolean",
+            "description": "Forwarded parameters replace the parameters of the target with the same key, otherwise the target wins"
+          },
+          "utm": {
+            "type": "object",
+            "description": "Default UTM parameters appended to the target unless the target or the query of the short link sets them",
+            "properties": {
+              "utm_source": {
+                "type": "string",
+                "minLength": 1,
+                "maxLength": 255
+              },
+              "utm_medium": {
+                "type": "string",
+                "minLength": 1,
+                "maxLength": 255
+              },
+              "utm_campaign": {
+                "type": "string",
+                "minLength": 1,
+                "maxLength": 255
+              },
+              "utm_term": {
+                "type": "string",
+                "minLength": 1,
+                "maxLength": 255
+              },
+              "utm_content": {
+                "type": "string",
+                "minLength": 1,
+                "maxLength": 255
+              }
+            },
+            "additionalProperties": false
+          },
+          "redirect_status": {
+            "type": "integer",
+            "enum": [
+              301,
+              302,
+              307,
+              308
+            ],
+            "description": "Status code of the redirect, the default of the server (308 unless configured) when not set"
+          },
+          "preview": {
+            "type": "boolean",
+            "description": "Always show the preview page with the destination to browsers before redirecting"
+          }
+        }
+      },
+      "LinkUpdate": {
+        "type": "object",
+        "required": [
+          "expires_at"
+        ],
+        "properties": {
+          "expires_at": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "Link": {
+        "type": "object",
+        "required": [
+          "id",
+          "short_url",
+          "url",
+          "created_at",
+          "expires_at",
+          "click_count",
+          "tags",
+          "owner",
+          "protected"
+        ],
+        "properties": {
+          "id": {
+            "type": "string"
+          },
+          "short_url": {
+            "type": "string"
+          },
+          "url": {
+            "type": "string"
+          },
+          "created_at": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "expires_at": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "click_count": {
+            "type": "integer"
+          },
+          "tags": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            }
+          },
+          "owner": {
+            "type": "string"
+          },
+          "protected": {
+            "type": "boolean",
+            "description": "Whether the link requires password"
+          },
+          "max_clicks": {
+            "type": "integer",
+            "description": "Present only for links with maximum clicks"
+          },
+          "remaining_clicks": {
+            "type": "integer",
+            "description": "Present only for links with maximum clicks"
+          },
+          "not_before": {
+            "type": "string",
+            "format": "date-time",
+            "description": "Present only for links with scheduled activation"
+          },
+          "targets": {
+            "type": "object",
+            "description": "Platform targets, present only for links with targets",
+            "properties": {
+              "ios": {
+                "type": "string",
+                "format": "uri"
+              },
+              "android": {
+                "type": "string",
+                "format": "uri"
+              },
+              "desktop": {
+                "type": "string",
+                "format": "uri"
+              }
+            },
+            "additionalProperties": false
+          },
+          "variants": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Variant"
+            },
+            "description": "Variants along with their click counts, present only for links with variants"
+          },
+          "sticky_variants": {
+            "type": "boolean"
+          },
+          "forward_query": {
+            "type": "boolean",
+            "description": "Merge the query of the short link into the query of the target"
+          },
+          "query_override": {
+            "type": "boolean",
+            "description": "Forwarded parameters replace the parameters of the target with the same key, otherwise the target wins"
+          },
+          "utm": {
+            "type": "object",
+            "description": "Default UTM parameters appended to the target unless the target or the query of the short link sets them",
+            "properties": {
+              "utm_source": {
+                "type": "string",
+                "minLength": 1,
+                "maxLength": 255
+              },
+              "utm_medium": {
+                "type": "string",
+                "minLength": 1,
+                "maxLength": 255
+              },
+              "utm_campaign": {
+                "type": "string",
+                "minLength": 1,
+                "maxLength": 255
+              },
+              "utm_term": {
+                "type": "string",
+                "minLength": 1,
+                "maxLength": 255
+              },
+              "utm_content": {
+                "type": "string",
+                "minLength": 1,
+                "maxLength": 255
+              }
+            },
+            "additionalProperties": false
+          },
+          "redirect_status": {
+            "type": "integer",
+            "description": "Status code of the redirect, present only for links with own status"
+          },
+          "preview": {
+            "type": "boolean",
+            "description": "Browsers are always shown the preview page, present only when set"
+          },
+          "check": {
+            "$ref": "#/components/schemas/Check"
+          }
+        }
+      },
+      "LinkList": {
+        "type": "object",
+        "required": [
+          "links",
+          "offset",
+          "limit"
+        ],
+        "properties": {
+          "links": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Link"
+            }
+          },
+          "offset": {
+            "type": "integer"
+          },
+          "limit": {
+            "type": "integer"
+          }
+        }
+      },
+      "LinkStats": {
+        "type": "object",
+        "required": [
+          "id",
+          "click_count",
+          "created_at",
+          "expires_at"
+        ],
+        "properties": {
+          "id": {
+            "type": "string"
+          },
+          "click_count": {
+            "type": "integer"
+          },
+          "created_at": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "expires_at": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "variants": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Variant"
+            },
+            "description": "Click count broken down by variant, present only for links with variants"
+          }
+        }
+      },
+      "Problem": {
+        "type": "object",
+        "required": [
+          "type",
+          "title",
+          "status",
+          "code"
+        ],
+        "properties": {
+          "type": {
+            "type": "string"
+          },
+          "title": {
+            "type": "string"
+          },
+          "status": {
+            "type": "integer"
+          },
+          "detail": {
+            "type": "string"
+          },
+          "instance": {
+            "type": "string"
+          },
+          "code": {
+            "type": "string",
+            "enum": [
+              "invalid_json",
+              "body_too_large",
+              "validation_failed",
+              "invalid_url",
+              "url_too_long",
+              "alias_invalid",
+              "tag_invalid",
+              "owner_invalid",
+              "expiration_invalid",
+              "target_invalid",
+              "variant_invalid",
+              "utm_invalid",
+              "redirect_status_invalid",
+              "url_blocked",
+              "url_self_reference",
+              "alias_taken",
+              "url_taken",
+              "quota_exceeded",
+              "invalid_parameter",
+              "not_found",
+              "method_not_allowed",
+              "internal_error",
+              "password_invalid",
+              "password_required",
+              "password_incorrect",
+              "too_many_attempts",
+              "max_clicks_invalid",
+              "link_exhausted",
+              "not_before_invalid",
+              "link_not_active",
+              "link_expired",
+              "api_key_required",
+              "api_key_invalid",
+              "host_invalid",
+              "not_found_page_invalid",
+              "domain_taken",
+              "forbidden"
+            ]
+          },
+          "id": {
+            "type": "string"
+          },
+          "url": {
+            "type": "string"
+          },
+          "errors": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/FieldError"
+            }
+          }
+        }
+      },
+      "FieldError": {
+        "type": "object",
+        "required": [
+          "field",
+          "code",
+          "detail"
+        ],
+        "properties": {
+          "field": {
+            "type": "string"
+          },
+          "code": {
+            "type": "string"
+          },
+          "detail": {
+            "type": "string"
+          }
+        }
+      },
+      "VariantRequest": {
+        "type": "object",
+        "required": [
+          "url"
+        ],
+        "properties": {
+          "name": {
+            "type": "string",
+            "maxLength": 32,
+            "pattern": "^[A-Za-z0-9_-]+$",
+            "description": "Name of the variant, a, b, c... by position when empty"
+          },
+          "url": {
+            "type": "string",
+            "format": "uri"
+          },
+          "weight": {
+            "type": "integer",
+            "minimum": 1,
+            "maximum": 1000,
+            "description": "Share of the traffic relative to the other variants, 1 when not set"
+          }
+        }
+      },
+      "Variant": {
+        "type": "object",
+        "required": [
+          "name",
+          "url",
+          "weight",
+          "click_count"
+        ],
+        "properties": {
+          "name": {
+            "type": "string"
+          },
+          "url": {
+            "type": "string",
+            "format": "uri"
+          },
+          "weight": {
+            "type": "integer"
+          },
+          "click_count": {
+            "type": "integer"
+          }
+        }
+      },
+      "Check": {
+        "type": "object",
+        "description": "Last health check of the destination, present only for links whose destination has been checked",
+        "required": [
+          "status",
+          "healthy",
+          "latency_ms",
+          "checked_at"
+        ],
+        "properties": {
+          "status": {
+            "type": "integer",
+            "description": "Status code of the destination, 0 when it could not be reached"
+          },
+          "healthy": {
+            "type": "boolean",
+            "description": "Whether the status is successful or redirect one"
+          },
+          "latency_ms": {
+            "type": "integer"
+          },
+          "checked_at": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "Domain": {
+        "type": "object",
+        "required": [
+          "host",
+          "url",
+          "created_at"
+        ],
+        "properties": {
+          "host": {
+            "type": "string"
+          },
+          "url": {
+            "type": "string",
+            "description": "Scheme and host of the short urls of the domain"
+          },
+          "created_at": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "not_found_page": {
+            "type": "string",
+            "description": "HTML page sent to browsers for ids which do not exist on the domain, present only when set"
+          }
+        }
+      },
+      "DomainRequest": {
+        "type": "object",
+        "required": [
+          "host"
+        ],
+        "properties": {
+          "host": {
+            "type": "string",
+            "maxLength": 64
+          },
+          "not_found_page": {
+            "type": "string",
+            "maxLength": 32768
+          }
+        }
+      },
+      "DomainList": {
+        "type": "object",
+        "required": [
+          "domains"
+        ],
+        "properties": {
+          "domains": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Domain"
+            }
+          }
+        }
+      }
+    },
+    "securitySchemes": {
+      "apiKey": {
+        "type": "http",
+        "scheme": "bearer",
+        "description": "API key of the workspace, required for managing the links of workspaces with API keys"
+      }
+    }
+  }
+}`
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>URL shortener API</title>
+  <link rel="stylesheet" href="%[1]v/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="%[1]v/swagger-ui-bundle.js"></script>
+  <script>
+    window.onload = function() {
+      SwaggerUIBundle({url: %[2]q, dom_id: "#swagger-ui"});
+    };
+  </script>
+</body>
+</html>
+`
+
+func newOpenAPIDocument(pathPrefix string) (document []byte, err error) {
+	var spec map[string]interface{}
+	err = json.Unmarshal([]byte(openAPISpec), &spec)
+	if err != nil {
+		return
+	}
+
+	server := pathPrefix
+	if server == "" {
+		server = "/"
+	}
+	spec["servers"] = []map[string]string{{"url": server}}
+
+	document, err = json.Marshal(spec)
+	return
+}
+
+func (handler *api) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(handler.openAPI)
+}
+
+func (handler *api) getDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, swaggerUIPage, handler.options.PathPrefix+"/api/docs", handler.options.PathPrefix+"/api/openapi.json")
+}
+
+// swaggerUIAssets are the files of the swagger-ui-dist package
+// referenced by the Swagger UI page
+var swaggerUIAssets = map[string]bool{
+	"swagger-ui.css":       true,
+	"swagger-ui-bundle.js": true,
+}
+
+// getDocsAsset serves the assets of the Swagger UI page from the
+// configured directory, so that no third-party script runs on the
+// origin of the service
+func (handler *api) getDocsAsset(w http.ResponseWriter, r *http.Request) {
+	asset := mux.Vars(r)["asset"]
+	if !swaggerUIAssets[asset] {
+		handler.writeProblem(w, r, problem{
+			Status: http.StatusNotFound,
+			Code:   codeNotFound,
+			Detail: fmt.Sprintf("Resource %v does not exist", r.URL.Path),
+		})
+		return
+	}
+
+	http.ServeFile(w, r, filepath.Join(handler.options.SwaggerUI, asset))
+}
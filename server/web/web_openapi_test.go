@@ -0,0 +1,360 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/georgiv/url-shortener/testdata"
+	"github.com/gorilla/mux"
+)
+
+func loadSpec(t *testing.T) (spec map[string]interface{}) {
+	err := json.Unmarshal([]byte(openAPISpec), &spec)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	return
+}
+
+func specOperations(spec map[string]interface{}) (operations []string) {
+	for path, item := range spec["paths"].(map[string]interface{}) {
+		for method := range item.(map[string]interface{}) {
+			operations = append(operations, fmt.Sprintf("%v %v", strings.ToUpper(method), path))
+		}
+	}
+
+	sort.Strings(operations)
+
+	return
+}
+
+func routeOperations(t *testing.T, router *mux.Router) (operations []string) {
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		for _, method := range methods {
+			operations = append(operations, fmt.Sprintf("%v %v", method, path))
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	sort.Strings(operations)
+
+	return
+}
+
+// validateSchema checks value against the subset of JSON schema used
+// by the OpenAPI document: $ref, type, required, properties,
+// items, enum and date-time format
+func validateSchema(spec map[string]interface{}, schema map[string]interface{}, value interface{}, path string) (errs []string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+		return validateSchema(spec, schemas[name].(map[string]interface{}), value, path)
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%v: expected object, received %T", path, value)}
+		}
+
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := object[name.(string)]; !ok {
+					errs = append(errs, fmt.Sprintf("%v: missing required property %v", path, name))
+				}
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, v := range object {
+			property, ok := properties[name]
+			if !ok {
+				if properties != nil {
+					errs = append(errs, fmt.Sprintf("%v: unexpected property %v", path, name))
+				}
+				continue
+			}
+			errs = append(errs, validateSchema(spec, property.(map[string]interface{}), v, path+"."+name)...)
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%v: expected array, received %T", path, value)}
+		}
+
+		for i, v := range array {
+			errs = append(errs, validateSchema(spec, schema["items"].(map[string]interface{}), v, fmt.Sprintf("%v[%v]", path, i))...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%v: expected string, received %T", path, value)}
+		}
+
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				errs = append(errs, fmt.Sprintf("%v: expected date-time, received %v", path, s))
+			}
+		}
+
+		if enum, ok := schema["enum"].([]interface{}); ok {
+			found := false
+			for _, e := range enum {
+				found = found || e == s
+			}
+			if !found {
+				errs = append(errs, fmt.Sprintf("%v: %v is not in %v", path, s, enum))
+			}
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != float64(int64(n)) {
+			return []string{fmt.Sprintf("%v: expected integer, received %v", path, value)}
+		}
+	}
+
+	return
+}
+
+func responseSchema(t *testing.T, spec map[string]interface{}, path string, method string, status int) map[string]interface{} {
+	operation := spec["paths"].(map[string]interface{})[path].(map[string]interface{})[method].(map[string]interface{})
+
+	response, ok := operation["responses"].(map[string]interface{})[fmt.Sprintf("%v", status)].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Missing response %v for %v %v", status, method, path)
+	}
+
+	if ref, ok := response["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/responses/")
+		response = spec["components"].(map[string]interface{})["responses"].(map[string]interface{})[name].(map[string]interface{})
+	}
+
+	for _, media := range response["content"].(map[string]interface{}) {
+		return media.(map[string]interface{})["schema"].(map[string]interface{})
+	}
+
+	t.Fatalf("Missing content of response %v for %v %v", status, method, path)
+	return nil
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	spec := loadSpec(t)
+
+	handler := NewHandler(testdata.NewMemoryWorker(), Options{SwaggerUI: t.TempDir()}, nil).(*api)
+
+	expected := specOperations(spec)
+	received := routeOperations(t, handler.router)
+
+	if strings.Join(expected, "\n") != strings.Join(received, "\n") {
+		t.Errorf("Expected routes\n%v\nreceived\n%v", strings.Join(expected, "\n"), strings.Join(received, "\n"))
+	}
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	handler := NewHandler(testdata.NewMemoryWorker(), Options{PathPrefix: "/shortener", SwaggerUI: t.TempDir()}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/shortener/api/openapi.json", nil))
+
+	if w.Code != 200 {
+		t.Fatalf("Expected status code 200, received: %v", w.Code)
+	}
+
+	var document struct {
+		OpenAPI string `json:"openapi"`
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &document)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if document.OpenAPI != "3.0.3" {
+		t.Errorf("Expected 3.0.3, received %v", document.OpenAPI)
+	}
+	if len(document.Servers) != 1 || document.Servers[0].URL != "/shortener" {
+		t.Errorf("Expected /shortener server, received %v", document.Servers)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/shortener/api/docs", nil))
+
+	if w.Code != 200 {
+		t.Errorf("Expected status code 200, received: %v", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"/shortener/api/openapi.json"`) {
+		t.Errorf("Expected reference to /shortener/api/openapi.json, received %v", w.Body.String())
+	}
+}
+
+func TestOpenAPIDocsAssets(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "swagger-ui-bundle.js"), []byte("bundle"), 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	handler := NewHandler(testdata.NewMemoryWorker(), Options{PathPrefix: "/shortener", SwaggerUI: dir}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/shortener/api/docs", nil))
+
+	if strings.Contains(w.Body.String(), "://") {
+		t.Errorf("Expected assets of the same origin, received %v", w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `src="/shortener/api/docs/swagger-ui-bundle.js"`) {
+		t.Errorf("Expected reference to /shortener/api/docs/swagger-ui-bundle.js, received %v", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/shortener/api/docs/swagger-ui-bundle.js", nil))
+
+	if w.Code != 200 {
+		t.Errorf("Expected status code 200, received: %v", w.Code)
+	}
+	if w.Body.String() != "bundle" {
+		t.Errorf("Expected bundle, received %v", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/shortener/api/docs/secret.txt", nil))
+
+	if w.Code != 404 {
+		t.Errorf("Expected status code 404, received: %v", w.Code)
+	}
+}
+
+func TestOpenAPISamples(t *testing.T) {
+	spec := loadSpec(t)
+
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("tester", "https://google.com")
+
+	handler := NewHandler(dbWorker, Options{}, nil)
+
+	samples := []struct {
+		method string
+		target string
+		path   string
+		body   string
+		status int
+	}{
+		{"POST", "/api/v2/links", "/api/v2/links", `{"id": "cranki", "url": "http://testurl.com", "tags": ["docs"], "owner": "cranki"}`, 201},
+		{"POST", "/api/v2/links", "/api/v2/links", `{"id": "cranki", "url": "http://anothertesturl.com"}`, 409},
+		{"POST", "/api/v2/links", "/api/v2/links", `{"id": "crank ", "url": "testurl"}`, 400},
+		{"POST", "/api/urls", "/api/urls", `bad json`, 400},
+		{"POST", "/api/urls", "/api/urls", `{"url": "javascript:alert(1)"}`, 422},
+		{"POST", "/api/v2/links", "/api/v2/links", `{"url": "http://10.0.0.1", "targets": {"ios": "http://localhost"}}`, 422},
+		{"GET", "/api/v2/links/cranki", "/api/v2/links/{id}", "", 200},
+		{"GET", "/api/v2/links/unknown", "/api/v2/links/{id}", "", 404},
+		{"GET", "/api/urls/unknown", "/api/urls/{id}", "", 404},
+		{"POST", "/api/v2/links", "/api/v2/links", `{"id": "secret", "url": "http://secret.com", "password": "s3cr3t"}`, 201},
+		{"GET", "/api/urls/secret", "/api/urls/{id}", "", 401},
+		{"POST", "/api/v2/links", "/api/v2/links", `{"id": "single", "url": "http://single.com", "max_clicks": 1}`, 201},
+		{"GET", "/api/urls/single", "/api/urls/{id}", "", 308},
+		{"GET", "/api/urls/single", "/api/urls/{id}", "", 410},
+		{"GET", "/api/v2/links/single", "/api/v2/links/{id}", "", 200},
+		{"POST", "/api/v2/links", "/api/v2/links", `{"id": "launch", "url": "http://launch.com", "not_before": "2999-01-01T00:00:00Z"}`, 201},
+		{"GET", "/api/urls/launch", "/api/urls/{id}", "", 403},
+		{"GET", "/api/v2/links/launch", "/api/v2/links/{id}", "", 200},
+		{"POST", "/api/v2/links", "/api/v2/links", `{"id": "appins", "url": "http://app.com", "targets": {"ios": "https://apps.apple.com/app/id1", "android": "https://play.google.com/store/apps/details?id=app"}}`, 201},
+		{"POST", "/api/v2/links", "/api/v2/links", `{"url": "http://app.com/web", "targets": {"windows": "http://app.com/win"}}`, 400},
+		{"GET", "/api/v2/links/appins", "/api/v2/links/{id}", "", 200},
+		{"GET", "/api/urls/appins", "/api/urls/{id}", "", 308},
+		{"POST", "/api/v2/links", "/api/v2/links", `{"id": "splits", "url": "http://split.com", "variants": [{"name": "a", "url": "http://split.com/a", "weight": 70}, {"url": "http://split.com/b"}], "sticky_variants": true}`, 201},
+		{"POST", "/api/v2/links", "/api/v2/links", `{"url": "http://split.com/c", "variants": [{"url": "http://split.com/c"}]}`, 400},
+		{"GET", "/api/urls/splits", "/api/urls/{id}", "", 308},
+		{"GET", "/api/v2/links/splits/stats", "/api/v2/links/{id}/stats", "", 200},
+		{"POST", "/api/v2/links", "/api/v2/links", `{"id": "utmlnk", "url": "http://utm.com/?a=1#top", "forward_query": true, "query_override": true, "utm": {"utm_source": "docs", "utm_medium": "email"}}`, 201},
+		{"POST", "/api/v2/links", "/api/v2/links", `{"url": "http://utm.com/x", "utm": {"utm_source": ""}}`, 400},
+		{"GET", "/api/v2/links/utmlnk", "/api/v2/links/{id}", "", 200},
+		{"GET", "/api/urls/utmlnk?a=2", "/api/urls/{id}", "", 308},
+		{"POST", "/api/v2/links", "/api/v2/links", `{"id": "tempor", "url": "http://temp.com", "redirect_status": 307}`, 201},
+		{"POST", "/api/v2/links", "/api/v2/links", `{"url": "http://temp.com/x", "redirect_status": 303}`, 400},
+		{"HEAD", "/api/urls/tempor", "/api/urls/{id}", "", 307},
+		{"GET", "/api/urls/tempor", "/api/urls/{id}", "", 307},
+		{"GET", "/api/v2/links/tempor", "/api/v2/links/{id}", "", 200},
+		{"POST", "/api/v2/links", "/api/v2/links", `{"id": "previw", "url": "http://preview.com", "preview": true}`, 201},
+		{"GET", "/api/urls/previw", "/api/urls/{id}", "", 308},
+		{"GET", "/api/urls/unknown+", "/api/urls/{id}+", "", 404},
+		{"GET", "/api/urls/secret+", "/api/urls/{id}+", "", 401},
+		{"GET", "/api/v2/links/previw", "/api/v2/links/{id}", "", 200},
+		{"GET", "/api/urls/previw/qr?size=8&level=X", "/api/urls/{id}/qr", "", 400},
+		{"GET", "/api/urls/unknown/qr", "/api/urls/{id}/qr", "", 404},
+		{"GET", "/api/v2/links?tag=docs", "/api/v2/links", "", 200},
+		{"GET", "/api/v2/links?limit=0", "/api/v2/links", "", 400},
+		{"GET", "/api/v2/links/cranki/stats", "/api/v2/links/{id}/stats", "", 200},
+		{"PATCH", "/api/v2/links/cranki", "/api/v2/links/{id}", `{"expires_at": "2999-01-01T00:00:00Z"}`, 200},
+		{"PATCH", "/api/v2/links/cranki", "/api/v2/links/{id}", `{"expires_at": "2000-01-01T00:00:00Z"}`, 400},
+		{"PATCH", "/api/v2/links/unknown", "/api/v2/links/{id}", `{"expires_at": "2999-01-01T00:00:00Z"}`, 404},
+		{"DELETE", "/api/v2/links/cranki", "/api/v2/links/{id}", "", 204},
+		{"DELETE", "/api/v2/links/cranki", "/api/v2/links/{id}", "", 404},
+	}
+
+	requestSchema := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})["LinkRequest"].(map[string]interface{})
+
+	for _, sample := range samples {
+		name := fmt.Sprintf("%v %v %v", sample.method, sample.target, sample.status)
+
+		var requestBody interface{}
+		if json.Unmarshal([]byte(sample.body), &requestBody) == nil && sample.status != 400 && sample.method == "POST" {
+			for _, e := range validateSchema(spec, requestSchema, requestBody, "request") {
+				t.Errorf("%v: %v", name, e)
+			}
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(sample.method, sample.target, bytes.NewBufferString(sample.body)))
+
+		if w.Code != sample.status {
+			t.Errorf("%v: expected status code %v, received: %v", name, sample.status, w.Code)
+			continue
+		}
+
+		if w.Body.Len() == 0 {
+			continue
+		}
+
+		var responseBody interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &responseBody)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", name, err)
+			continue
+		}
+
+		schema := responseSchema(t, spec, sample.path, strings.ToLower(sample.method), sample.status)
+		for _, e := range validateSchema(spec, schema, responseBody, "response") {
+			t.Errorf("%v: %v", name, e)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/policy"
+)
+
+// previewParam is the query parameter requesting the preview
+// page (1) or carrying the continue token of the preview page,
+// which skips the forced one
+const previewParam = "preview"
+
+// continueTTL is the time for which the continue button of the
+// preview page skips the forced preview
+const continueTTL = 10 * time.Minute
+
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <meta name="robots" content="noindex">
+  <title>Link preview</title>
+</head>
+<body>
+  <main>
+    <p>This link leads to <strong>{{.Domain}}</strong>:</p>
+    <p><code>{{.Destination}}</code></p>
+    <p>Created on <time datetime="{{.CreatedAt.Format "2006-01-02"}}">{{.CreatedAt.Format "2 January 2006"}}</time></p>
+    <p><a href="{{.Continue}}" rel="noreferrer">Continue</a></p>
+  </main>
+</body>
+</html>
+`))
+
+// preview is the data rendered by previewPage
+type preview struct {
+	Destination string
+	Domain      string
+	CreatedAt   time.Time
+	Continue    string
+}
+
+func (handler *api) previewURL(w http.ResponseWriter, r *http.Request) {
+	handler.resolve(w, r, r.Header.Get(passwordHeader), 0, true)
+}
+
+// forcesPreview reports whether browsers are shown the preview
+// page before they are redirected to the target of the link
+func (handler *api) forcesPreview(link *db.Link, target string) bool {
+	return link.Preview || !handler.trusted(target)
+}
+
+// trusted reports whether the host of the target is one of the
+// trusted domains or their subdomain, compared in their punycode
+// form. All targets are trusted when no domains are configured
+func (handler *api) trusted(target string) bool {
+	if len(handler.options.TrustedDomains) == 0 {
+		return true
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	host := policy.CanonicalHost(u.Hostname())
+	for _, domain := range handler.options.TrustedDomains {
+		domain = policy.CanonicalHost(strings.TrimPrefix(domain, "."))
+		if domain == "" {
+			continue
+		}
+
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// continueToken returns the value of previewParam skipping the
+// forced preview of the link on the host until it expires. It
+// is signed with the preview key, so links shared with the
+// value set by hand still show the preview
+func (handler *api) continueToken(host string, id string, now time.Time) string {
+	expires := strconv.FormatInt(now.Add(continueTTL).Unix(), 10)
+	return expires + "." + handler.continueSignature(host, id, expires)
+}
+
+// continued reports whether the request carries valid continue
+// token of the link, issued by the preview page
+func (handler *api) continued(r *http.Request, id string) bool {
+	expires, signature, ok := strings.Cut(r.URL.Query().Get(previewParam), ".")
+	if !ok {
+		return false
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(handler.continueSignature(r.Host, id, expires)))
+}
+
+func (handler *api) continueSignature(host string, id string, expires string) string {
+	mac := hmac.New(sha256.New, handler.previewKey)
+	mac.Write([]byte(strings.ToLower(host) + "\n" + id + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// writePreviewPage sends the page describing the target of the
+// link. The continue button leads to the short link with the
+// same query and the continue token, which skips the forced
+// preview
+func (handler *api) writePreviewPage(w http.ResponseWriter, r *http.Request, id string, link *db.Link, target string) {
+	var domain string
+	if u, err := url.Parse(target); err == nil {
+		domain = u.Hostname()
+	}
+
+	query := r.URL.Query()
+	query.Set(previewParam, handler.continueToken(r.Host, id, time.Now()))
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	previewPage.Execute(w, preview{
+		Destination: target,
+		Domain:      domain,
+		CreatedAt:   link.CreatedAt.UTC(),
+		Continue:    strings.TrimSuffix(r.URL.Path, "+") + "?" + query.Encode(),
+	})
+}
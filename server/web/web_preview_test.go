@@ -0,0 +1,133 @@
+package web_test
+
+import (
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/web"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+func TestPreview(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Create(&db.Link{ID: "cranki", URL: "https://google.com/search?q=go", ForwardQuery: true})
+	dbWorker.Create(&db.Link{ID: "forced", URL: "https://bing.com", Preview: true})
+
+	handler := web.NewHandler(dbWorker, web.Options{}, nil)
+
+	for _, target := range []string{"/api/urls/cranki+?lang=en", "/api/urls/cranki?preview=1&lang=en"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+
+		if w.Code != 200 {
+			t.Fatalf("%v: expected status code 200, received: %v", target, w.Code)
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != "text/html; charset=utf-8" {
+			t.Errorf("%v: expected HTML page, received %v", target, contentType)
+		}
+
+		body := w.Body.String()
+		for _, expected := range []string{"<strong>google.com</strong>", "https://google.com/search?q=go&amp;lang=en", `href="/api/urls/cranki?lang=en&amp;preview=`} {
+			if !strings.Contains(body, expected) {
+				t.Errorf("%v: expected %v in page %v", target, expected, body)
+			}
+		}
+	}
+
+	tests := []struct {
+		target   string
+		accept   string
+		status   int
+		location string
+	}{
+		{"/api/urls/forced", "text/html", 200, ""},
+		{"/api/urls/forced", "application/json", 308, "https://bing.com"},
+		{"/api/urls/forced?preview=0", "text/html", 200, ""},
+		{"/api/urls/cranki?preview=0", "text/html", 308, "https://google.com/search?q=go"},
+		{"/api/urls/unknown+", "text/html", 404, ""},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.target, nil)
+		r.Header.Set("Accept", test.accept)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("%v: expected status code %v, received: %v", test.target, test.status, w.Code)
+		}
+
+		if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("%v: expected location %q, received %q", test.target, test.location, location)
+		}
+	}
+
+	continued := previewContinue(t, handler, "/api/urls/forced")
+	forged := strings.Replace(previewContinue(t, handler, "/api/urls/cranki+"), "cranki", "forced", 1)
+	for target, status := range map[string]int{continued: 308, continued + "0": 200, forged: 200} {
+		r := httptest.NewRequest("GET", target, nil)
+		r.Header.Set("Accept", "text/html")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != status {
+			t.Errorf("%v: expected status code %v, received: %v", target, status, w.Code)
+		}
+	}
+
+	for id, clicks := range map[string]int64{"cranki": 1, "forced": 2} {
+		link, _ := dbWorker.Get(id)
+		if link.ClickCount != clicks {
+			t.Errorf("%v: expected %v clicks, received %v", id, clicks, link.ClickCount)
+		}
+	}
+}
+
+var continueLink = regexp.MustCompile(`href="([^"]+)"`)
+
+// previewContinue returns the target of the continue button of
+// the preview page of the link
+func previewContinue(t *testing.T, handler http.Handler, target string) string {
+	r := httptest.NewRequest("GET", target, nil)
+	r.Header.Set("Accept", "text/html")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	match := continueLink.FindStringSubmatch(w.Body.String())
+	if match == nil {
+		t.Fatalf("%v: expected continue button in page %v", target, w.Body.String())
+	}
+	return html.UnescapeString(match[1])
+}
+
+func TestPreviewTrustedDomains(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "https://docs.example.com/start")
+	dbWorker.Register("trusty", "https://Example.com.")
+	dbWorker.Register("random", "https://notexample.com")
+	dbWorker.Register("bucher", "https://shop.xn--bcher-kva.example/books")
+	dbWorker.Register("puny", "https://bücher.example")
+
+	handler := web.NewHandler(dbWorker, web.Options{TrustedDomains: []string{"example.com", "Bücher.example."}}, nil)
+
+	for id, status := range map[string]int{"cranki": 308, "trusty": 308, "random": 200, "bucher": 308, "puny": 308} {
+		r := httptest.NewRequest("GET", "/api/urls/"+id, nil)
+		r.Header.Set("Accept", "text/html")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != status {
+			t.Errorf("%v: expected status code %v, received: %v", id, status, w.Code)
+		}
+	}
+}
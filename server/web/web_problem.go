@@ -0,0 +1,166 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/georgiv/url-shortener/server/service"
+)
+
+// Machine-readable codes of the problems returned by the API.
+// They are stable and clients may rely on them. Validation
+// and conflict codes are defined in the service package
+const (
+	codeInvalidJSON      = "invalid_json"
+	codeBodyTooLarge     = "body_too_large"
+	codeValidationFailed = "validation_failed"
+	codeInvalidParameter = service.CodeInvalidParameter
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeInternal         = "internal_error"
+)
+
+// problem is RFC 7807 problem details object. Besides the
+// standard members it carries the machine-readable code, the
+// id and url of the affected link and the field-level
+// validation errors
+type problem struct {
+	Type     string               `json:"type"`
+	Title    string               `json:"title"`
+	Status   int                  `json:"status"`
+	Detail   string               `json:"detail,omitempty"`
+	Instance string               `json:"instance,omitempty"`
+	Code     string               `json:"code"`
+	ID       string               `json:"id,omitempty"`
+	URL      string               `json:"url,omitempty"`
+	Errors   []service.FieldError `json:"errors,omitempty"`
+}
+
+func (handler *api) writeProblem(w http.ResponseWriter, r *http.Request, p problem) {
+	p.Type = "about:blank"
+	p.Title = http.StatusText(p.Status)
+	p.Instance = r.URL.Path
+
+	problemJSON, err := json.Marshal(p)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		handler.logger.Printf("Bad JSON format: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	w.Write(problemJSON)
+}
+
+func (handler *api) writeInternalError(w http.ResponseWriter, r *http.Request, format string, v ...interface{}) {
+	handler.logger.Printf(format, v...)
+
+	handler.writeProblem(w, r, problem{
+		Status: http.StatusInternalServerError,
+		Code:   codeInternal,
+		Detail: "The request could not be processed due to an internal error",
+	})
+}
+
+func (handler *api) writeValidationProblem(w http.ResponseWriter, r *http.Request, b payload, errs []service.FieldError) {
+	p := problem{
+		Status: http.StatusBadRequest,
+		Code:   codeValidationFailed,
+		Detail: fmt.Sprintf("Request contains %v invalid fields", len(errs)),
+		ID:     b.ID,
+		URL:    b.URL,
+		Errors: errs,
+	}
+
+	if len(errs) == 1 {
+		p.Code = errs[0].Code
+		p.Detail = errs[0].Detail
+	}
+
+	handler.writeProblem(w, r, p)
+}
+
+// writeServiceError maps the errors returned by the link service
+// to problems. Errors other than validation, policy, conflict,
+// quota and access ones are logged with the provided format and reported as
+// internal errors
+func (handler *api) writeServiceError(w http.ResponseWriter, r *http.Request, b payload, err error, format string, v ...interface{}) {
+	switch e := err.(type) {
+	case *service.ValidationError:
+		handler.writeValidationProblem(w, r, b, e.Errors)
+	case *service.PolicyError:
+		p := problem{
+			Status: http.StatusUnprocessableEntity,
+			Code:   e.Code(),
+			Detail: fmt.Sprintf("Request contains %v rejected urls", len(e.Errors)),
+			ID:     b.ID,
+			URL:    b.URL,
+			Errors: e.Errors,
+		}
+		if len(e.Errors) == 1 {
+			p.Detail = e.Errors[0].Detail
+		}
+
+		handler.writeProblem(w, r, p)
+	case *service.ConflictError:
+		handler.writeProblem(w, r, problem{
+			Status: http.StatusConflict,
+			Code:   e.Code,
+			Detail: e.Detail,
+			ID:     e.ID,
+			URL:    e.URL,
+		})
+	case *service.QuotaError:
+		handler.writeProblem(w, r, problem{
+			Status: http.StatusForbidden,
+			Code:   service.CodeQuotaExceeded,
+			Detail: e.Error(),
+			ID:     b.ID,
+			URL:    b.URL,
+		})
+	case *service.AccessError:
+		status := accessStatus[e.Code]
+		if e.Code == service.CodeLinkNotActive {
+			status = handler.options.NotActiveStatus
+		}
+
+		setRetryAfter(w, e.RetryAfter)
+		handler.writeProblem(w, r, problem{
+			Status: status,
+			Code:   e.Code,
+			Detail: e.Detail,
+			ID:     e.ID,
+		})
+	default:
+		handler.writeInternalError(w, r, format, v...)
+	}
+}
+
+func (handler *api) notFound(w http.ResponseWriter, r *http.Request) {
+	// gorilla/mux reports method mismatch as not found when
+	// routes with other paths are registered after the one
+	// matching the path, so the methods are checked explicitly
+	if len(routeMethods(handler.router, r, corsMethods)) != 0 {
+		handler.methodNotAllowed(w, r)
+		return
+	}
+
+	handler.writeProblem(w, r, problem{
+		Status: http.StatusNotFound,
+		Code:   codeNotFound,
+		Detail: fmt.Sprintf("Resource %v does not exist", r.URL.Path),
+	})
+}
+
+func (handler *api) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", strings.Join(routeMethods(handler.router, r, corsMethods), ", "))
+
+	handler.writeProblem(w, r, problem{
+		Status: http.StatusMethodNotAllowed,
+		Code:   codeMethodNotAllowed,
+		Detail: fmt.Sprintf("Method %v is not supported by resource %v", r.Method, r.URL.Path),
+	})
+}
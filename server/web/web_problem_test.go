@@ -0,0 +1,148 @@
+package web_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/georgiv/url-shortener/server/web"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Code   string `json:"code"`
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Errors []struct {
+		Field string `json:"field"`
+		Code  string `json:"code"`
+	} `json:"errors"`
+}
+
+func decodeProblem(t *testing.T, w *httptest.ResponseRecorder) (p problem) {
+	contentType := w.Header().Get("Content-Type")
+	if contentType != "application/problem+json" {
+		t.Errorf("Expected application/problem+json, received %v", contentType)
+	}
+
+	err := json.Unmarshal(w.Body.Bytes(), &p)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if p.Status != w.Code {
+		t.Errorf("Expected status %v, received %v", w.Code, p.Status)
+	}
+
+	return
+}
+
+func TestProblemBadJSON(t *testing.T) {
+	handler := web.NewHandler(testdata.NewMemoryWorker(), web.Options{}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/urls", bytes.NewBufferString("bad json")))
+
+	if w.Code != 400 {
+		t.Errorf("Expected status code 400, received: %v", w.Code)
+	}
+
+	p := decodeProblem(t, w)
+	if p.Code != "invalid_json" {
+		t.Errorf("Expected invalid_json, received %v", p.Code)
+	}
+}
+
+func TestProblemValidation(t *testing.T) {
+	handler := web.NewHandler(testdata.NewMemoryWorker(), web.Options{}, nil)
+
+	body := `{"id": "crank ", "url": "testurl"}`
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/urls", bytes.NewBufferString(body)))
+
+	if w.Code != 400 {
+		t.Errorf("Expected status code 400, received: %v", w.Code)
+	}
+
+	p := decodeProblem(t, w)
+	if p.Code != "validation_failed" {
+		t.Errorf("Expected validation_failed, received %v", p.Code)
+	}
+
+	if len(p.Errors) != 2 {
+		t.Fatalf("Expected 2 field errors, received %v", len(p.Errors))
+	}
+	if p.Errors[0].Field != "url" || p.Errors[0].Code != "invalid_url" {
+		t.Errorf("Expected url invalid_url, received %v %v", p.Errors[0].Field, p.Errors[0].Code)
+	}
+	if p.Errors[1].Field != "id" || p.Errors[1].Code != "alias_invalid" {
+		t.Errorf("Expected id alias_invalid, received %v %v", p.Errors[1].Field, p.Errors[1].Code)
+	}
+}
+
+func TestProblemAliasTaken(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "https://google.com")
+
+	handler := web.NewHandler(dbWorker, web.Options{}, nil)
+
+	body := `{"id": "cranki", "url": "http://testurl.com"}`
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/urls", bytes.NewBufferString(body)))
+
+	if w.Code != 409 {
+		t.Errorf("Expected status code 409, received: %v", w.Code)
+	}
+
+	p := decodeProblem(t, w)
+	if p.Code != "alias_taken" {
+		t.Errorf("Expected alias_taken, received %v", p.Code)
+	}
+	if p.URL != "https://google.com" {
+		t.Errorf("Expected https://google.com, received %v", p.URL)
+	}
+}
+
+func TestProblemNotFound(t *testing.T) {
+	handler := web.NewHandler(testdata.NewMemoryWorker(), web.Options{}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/urls/cranki", nil))
+
+	if w.Code != 404 {
+		t.Errorf("Expected status code 404, received: %v", w.Code)
+	}
+
+	p := decodeProblem(t, w)
+	if p.Code != "not_found" {
+		t.Errorf("Expected not_found, received %v", p.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/urls", nil))
+
+	if w.Code != 405 {
+		t.Errorf("Expected status code 405, received: %v", w.Code)
+	}
+
+	p = decodeProblem(t, w)
+	if p.Code != "method_not_allowed" {
+		t.Errorf("Expected method_not_allowed, received %v", p.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v2/links", nil))
+
+	if w.Code != 405 {
+		t.Errorf("Expected status code 405, received: %v", w.Code)
+	}
+	if w.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("Expected GET, POST, received %v", w.Header().Get("Allow"))
+	}
+}
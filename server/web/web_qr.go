@@ -0,0 +1,139 @@
+package web
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/georgiv/url-shortener/server/qr"
+	"github.com/georgiv/url-shortener/server/service"
+	"github.com/gorilla/mux"
+)
+
+// qrMaxAge is the max age in seconds of cached QR codes. The
+// codes encode only the short url, so they change only with
+// the public url of the server
+const qrMaxAge = 24 * 60 * 60
+
+func (handler *api) getQR(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	options, errs := qrOptions(r)
+	if len(errs) != 0 {
+		handler.writeValidationProblem(w, r, payload{ID: id}, errs)
+		return
+	}
+
+	link, err := handler.links(r).Get(id)
+	if err != nil {
+		handler.writeServiceError(w, r, payload{ID: id}, err, "Error while retrieving data for id %v: %v", id, err)
+		return
+	}
+
+	if link == nil {
+		handler.writeProblem(w, r, problem{
+			Status: http.StatusNotFound,
+			Code:   codeNotFound,
+			Detail: fmt.Sprintf("ID %v does not exist", id),
+			ID:     id,
+		})
+		return
+	}
+
+	image, err := qr.Encode(handler.shortURL(r, link.ID), options)
+	if err != nil {
+		handler.writeInternalError(w, r, "Error while rendering QR code for id %v: %v", id, err)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(image))
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%v", qrMaxAge))
+
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", qr.ContentType(options.Format))
+	w.Header().Set("Content-Length", strconv.Itoa(len(image)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(image)
+}
+
+// qrOptions parses the format, size, level and margin query
+// parameters, applying the defaults for the missing ones
+func qrOptions(r *http.Request) (options qr.Options, errs []service.FieldError) {
+	query := r.URL.Query()
+
+	options = qr.Options{
+		Format: qr.DefaultFormat,
+		Size:   qr.DefaultSize,
+		Level:  qr.DefaultLevel,
+		Margin: qr.DefaultMargin,
+	}
+
+	if v := query.Get("format"); v != "" {
+		options.Format = qr.Format(strings.ToLower(v))
+		if !qr.ValidFormat(options.Format) {
+			errs = append(errs, service.FieldError{
+				Field:  "format",
+				Code:   codeInvalidParameter,
+				Detail: fmt.Sprintf("Invalid format: %v. Supported formats: %v", v, qr.Formats),
+			})
+		}
+	}
+
+	if v := query.Get("size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < qr.MinSize || size > qr.MaxSize {
+			errs = append(errs, service.FieldError{
+				Field:  "size",
+				Code:   codeInvalidParameter,
+				Detail: fmt.Sprintf("Invalid size: %v. It should be integer between %v and %v", v, qr.MinSize, qr.MaxSize),
+			})
+		}
+		options.Size = size
+	}
+
+	if v := query.Get("level"); v != "" {
+		options.Level = qr.Level(strings.ToUpper(v))
+		if !qr.ValidLevel(options.Level) {
+			errs = append(errs, service.FieldError{
+				Field:  "level",
+				Code:   codeInvalidParameter,
+				Detail: fmt.Sprintf("Invalid level: %v. Supported levels: %v", v, qr.Levels),
+			})
+		}
+	}
+
+	if v := query.Get("margin"); v != "" {
+		margin, err := strconv.Atoi(v)
+		if err != nil || margin < 0 || margin > qr.MaxMargin {
+			errs = append(errs, service.FieldError{
+				Field:  "margin",
+				Code:   codeInvalidParameter,
+				Detail: fmt.Sprintf("Invalid margin: %v. It should be integer between 0 and %v", v, qr.MaxMargin),
+			})
+		}
+		options.Margin = margin
+	}
+
+	return
+}
+
+// matchesETag reports whether the If-None-Match header lists
+// the entity tag. Weak comparison is used, as for GET requests
+func matchesETag(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,111 @@
+package web_test
+
+import (
+	"bytes"
+	"image/png"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/qr"
+	"github.com/georgiv/url-shortener/server/web"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+func TestQR(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "https://google.com")
+
+	handler := web.NewHandler(dbWorker, web.Options{PublicURL: "https://s.example.com"}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/urls/cranki/qr?size=200", nil))
+
+	if w.Code != 200 {
+		t.Fatalf("Expected status code 200, received: %v", w.Code)
+	}
+
+	if contentType := w.Header().Get("Content-Type"); contentType != "image/png" {
+		t.Errorf("Expected image/png, received %v", contentType)
+	}
+
+	expected, _ := qr.Encode("https://s.example.com/api/urls/cranki", qr.Options{Size: 200, Level: qr.LevelMedium, Margin: qr.DefaultMargin})
+	if !bytes.Equal(w.Body.Bytes(), expected) {
+		t.Errorf("Expected QR code of the short url")
+	}
+
+	img, err := png.Decode(w.Body)
+	if err != nil || img.Bounds().Dx() != 200 {
+		t.Errorf("Expected 200x200 PNG, received %v, %v", img, err)
+	}
+
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Header().Get("Cache-Control") != "public, max-age=86400" {
+		t.Errorf("Expected cacheable response, received %v", w.Header())
+	}
+
+	r := httptest.NewRequest("GET", "/api/urls/cranki/qr?size=200", nil)
+	r.Header.Set("If-None-Match", `"other", `+etag)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != 304 || w.Body.Len() != 0 {
+		t.Errorf("Expected status code 304 without body, received: %v", w.Code)
+	}
+
+	r = httptest.NewRequest("GET", "/api/urls/cranki/qr?format=svg&level=h&margin=0", nil)
+	r.Header.Set("If-None-Match", etag)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != 200 {
+		t.Fatalf("Expected status code 200, received: %v", w.Code)
+	}
+
+	if contentType := w.Header().Get("Content-Type"); contentType != "image/svg+xml" {
+		t.Errorf("Expected image/svg+xml, received %v", contentType)
+	}
+
+	if !strings.Contains(w.Body.String(), `width="256"`) || w.Header().Get("ETag") == etag {
+		t.Errorf("Expected SVG of default size with own ETag, received %v", w.Body.String())
+	}
+}
+
+func TestQRExpired(t *testing.T) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Create(&db.Link{ID: "closed", URL: "https://bing.com", ExpiresAt: time.Now().Add(-time.Hour)})
+
+	handler := web.NewHandler(dbWorker, web.Options{}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/urls/closed/qr", nil))
+
+	if w.Code != 410 {
+		t.Fatalf("Expected status code 410, received: %v", w.Code)
+	}
+
+	if !strings.Contains(w.Body.String(), `"link_expired"`) {
+		t.Errorf("Expected link_expired, received %v", w.Body.String())
+	}
+}
+
+func TestQRInvalid(t *testing.T) {
+	handler := web.NewHandler(testdata.NewMemoryWorker(), web.Options{}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/urls/cranki/qr?format=gif&size=big&level=X&margin=-1", nil))
+
+	if w.Code != 400 {
+		t.Fatalf("Expected status code 400, received: %v", w.Code)
+	}
+
+	for _, field := range []string{`"format"`, `"size"`, `"level"`, `"margin"`} {
+		if !strings.Contains(w.Body.String(), field) {
+			t.Errorf("Expected error of %v, received %v", field, w.Body.String())
+		}
+	}
+}
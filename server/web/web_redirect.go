@@ -0,0 +1,48 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+)
+
+// redirectStatus returns the status code the link redirects
+// with, which is the default of the handler unless the link
+// sets its own
+func (handler *api) redirectStatus(link *db.Link) int {
+	if link.RedirectStatus != 0 {
+		return link.RedirectStatus
+	}
+
+	return handler.options.RedirectStatus
+}
+
+// cacheControl returns the Cache-Control header matching the
+// redirect of the link with the provided status. Links which
+// should reach the server with every request (protected ones,
+// ones with maximum clicks or variants and the unlock redirects)
+// are not stored at all. Permanent redirects are cached until
+// the link expires, at most for RedirectMaxAge, so retargeting
+// still takes effect. Temporary redirects are revalidated
+func (handler *api) cacheControl(link *db.Link, status int) string {
+	if status == http.StatusSeeOther || link.PasswordHash != "" || link.MaxClicks > 0 || len(link.Variants) != 0 {
+		return "no-store"
+	}
+
+	if status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect {
+		maxAge := time.Until(link.ExpiresAt)
+		if maxAge > handler.options.RedirectMaxAge {
+			maxAge = handler.options.RedirectMaxAge
+		}
+
+		if maxAge < time.Second {
+			return "no-store"
+		}
+
+		return fmt.Sprintf("public, max-age=%v", int64(maxAge/time.Second))
+	}
+
+	return "no-cache"
+}
@@ -0,0 +1,184 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultReadTimeout is used when RunOptions.ReadTimeout is not set
+	DefaultReadTimeout = 10 * time.Second
+
+	// DefaultReadHeaderTimeout is used when RunOptions.ReadHeaderTimeout
+	// is not set
+	DefaultReadHeaderTimeout = 5 * time.Second
+
+	// DefaultWriteTimeout is used when RunOptions.WriteTimeout is not set
+	DefaultWriteTimeout = 10 * time.Second
+
+	// DefaultIdleTimeout is used when RunOptions.IdleTimeout is not set
+	DefaultIdleTimeout = 60 * time.Second
+)
+
+// RunOptions represents the parameters of the listener
+// started by Run
+type RunOptions struct {
+	// Host where to bind the listener
+	Host string
+
+	// Port on which the listener accepts requests
+	Port int
+
+	// TLSCert and TLSKey are paths to PEM encoded certificate
+	// and private key. When both are set, the listener serves
+	// HTTPS with HTTP/2 enabled and reloads the certificate on
+	// SIGHUP
+	TLSCert string
+	TLSKey  string
+
+	// RedirectPort is the port of additional plain HTTP listener
+	// which redirects all requests to HTTPS. It is started only
+	// when TLS is enabled and the value is positive
+	RedirectPort int
+
+	// HSTSMaxAge is the max-age in seconds sent in the
+	// Strict-Transport-Security header. It is sent only when
+	// TLS is enabled and the value is positive
+	HSTSMaxAge int
+
+	// ReadTimeout, ReadHeaderTimeout, WriteTimeout and IdleTimeout
+	// are passed to the underlying http.Server. In case 0 or
+	// negative value is set, the respective default is used
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+}
+
+// Run serves the provided handler until the context is cancelled
+// or the listener fails. On cancellation the listener is shut down
+// gracefully, waiting up to 5 seconds for the in-flight requests.
+// It is meant for standalone deployments. Services which embed the
+// handler returned by NewHandler should serve it on their own.
+// Params:
+//   - ctx: context controlling the lifetime of the listener
+//   - handler: handler serving the incoming requests
+//   - options: parameters of the listener
+//   - logger: logger used for reporting the listener state. In
+//     case nil is passed, a logger writing to the standard error
+//     with the standard flags is used
+func Run(ctx context.Context, handler http.Handler, options RunOptions, logger *log.Logger) (err error) {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	webWorker := newHTTPServer(fmt.Sprintf("%v:%v", options.Host, options.Port), handler, options)
+	webWorkers := []*http.Server{webWorker}
+
+	errs := make(chan error, 2)
+
+	if options.TLSCert != "" && options.TLSKey != "" {
+		reloader, err := newCertReloader(options.TLSCert, options.TLSKey)
+		if err != nil {
+			return err
+		}
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP)
+		defer signal.Stop(signals)
+
+		done := make(chan struct{})
+		defer close(done)
+
+		go reloader.watch(done, signals, logger)
+
+		webWorker.TLSConfig = newTLSConfig(reloader)
+
+		if options.HSTSMaxAge > 0 {
+			webWorker.Handler = withHSTS(handler, options.HSTSMaxAge)
+		}
+
+		go func() {
+			errs <- webWorker.ListenAndServeTLS("", "")
+		}()
+
+		logger.Printf("Server accepts TLS requests on port %v", options.Port)
+
+		if options.RedirectPort > 0 {
+			redirectWorker := newHTTPServer(fmt.Sprintf("%v:%v", options.Host, options.RedirectPort),
+				redirectToHTTPS(options.Port),
+				options)
+			webWorkers = append(webWorkers, redirectWorker)
+
+			go func() {
+				errs <- redirectWorker.ListenAndServe()
+			}()
+
+			logger.Printf("Server redirects requests to HTTPS on port %v", options.RedirectPort)
+		}
+	} else {
+		go func() {
+			errs <- webWorker.ListenAndServe()
+		}()
+
+		logger.Printf("Server accepts requests on port %v", options.Port)
+	}
+
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+	}
+
+	logger.Println("Shutting down web server...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, w := range webWorkers {
+		shutdownErr := w.Shutdown(shutdownCtx)
+		if shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+
+	if err != nil {
+		return
+	}
+
+	logger.Println("Web server successfully shut down")
+
+	return
+}
+
+func newHTTPServer(addr string, handler http.Handler, options RunOptions) *http.Server {
+	if options.ReadTimeout <= 0 {
+		options.ReadTimeout = DefaultReadTimeout
+	}
+
+	if options.ReadHeaderTimeout <= 0 {
+		options.ReadHeaderTimeout = DefaultReadHeaderTimeout
+	}
+
+	if options.WriteTimeout <= 0 {
+		options.WriteTimeout = DefaultWriteTimeout
+	}
+
+	if options.IdleTimeout <= 0 {
+		options.IdleTimeout = DefaultIdleTimeout
+	}
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       options.ReadTimeout,
+		ReadHeaderTimeout: options.ReadHeaderTimeout,
+		WriteTimeout:      options.WriteTimeout,
+		IdleTimeout:       options.IdleTimeout,
+	}
+}
@@ -0,0 +1,162 @@
+package web_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/web"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+func startRun(t *testing.T, options web.RunOptions) (stop func()) {
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "https://google.com")
+
+	handler := web.NewHandler(dbWorker, web.Options{}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- web.Run(ctx, handler, options, nil)
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+
+	return func() {
+		cancel()
+
+		err := <-done
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func tlsClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	port := testdata.FreePort(t)
+
+	stop := startRun(t, web.RunOptions{Host: "localhost", Port: port})
+	defer stop()
+
+	client := http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(fmt.Sprintf("http://localhost:%v/api/urls/cranki", port))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 308 {
+		t.Errorf("Expected status code 308, received: %v", resp.StatusCode)
+	}
+}
+
+func TestRunTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "url-shortener")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := testdata.WriteSelfSignedCert(t, dir, "first")
+
+	port := testdata.FreePort(t)
+	redirectPort := testdata.FreePort(t)
+
+	stop := startRun(t, web.RunOptions{
+		Host:         "localhost",
+		Port:         port,
+		TLSCert:      certFile,
+		TLSKey:       keyFile,
+		RedirectPort: redirectPort,
+		HSTSMaxAge:   3600,
+	})
+	defer stop()
+
+	client := tlsClient()
+
+	resp, err := client.Get(fmt.Sprintf("https://localhost:%v/api/urls/cranki", port))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 308 {
+		t.Errorf("Expected status code 308, received: %v", resp.StatusCode)
+	}
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Expected HTTP/2, received %v", resp.Proto)
+	}
+
+	hsts := resp.Header.Get("Strict-Transport-Security")
+	if hsts != "max-age=3600; includeSubDomains" {
+		t.Errorf("Expected max-age=3600; includeSubDomains, received %v", hsts)
+	}
+
+	commonName := resp.TLS.PeerCertificates[0].Subject.CommonName
+	if commonName != "first" {
+		t.Errorf("Expected first, received %v", commonName)
+	}
+
+	resp, err = client.Get(fmt.Sprintf("http://localhost:%v/api/urls/cranki?a=b", redirectPort))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 308 {
+		t.Errorf("Expected status code 308, received: %v", resp.StatusCode)
+	}
+
+	expected := fmt.Sprintf("https://localhost:%v/api/urls/cranki?a=b", port)
+	location := resp.Header.Get("Location")
+	if location != expected {
+		t.Errorf("Expected %v, received %v", expected, location)
+	}
+
+	testdata.WriteSelfSignedCert(t, dir, "second")
+
+	err = syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	client = tlsClient()
+
+	resp, err = client.Get(fmt.Sprintf("https://localhost:%v/api/urls/cranki", port))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	commonName = resp.TLS.PeerCertificates[0].Subject.CommonName
+	if commonName != "second" {
+		t.Errorf("Expected second, received %v", commonName)
+	}
+}
@@ -7,23 +7,16 @@ package web
 
 import (
 	"context"
-	"crypto/md5"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
 	"runtime"
 	"syscall"
 	"time"
-	"unicode"
 
 	"github.com/georgiv/url-shortener/server/db"
-	"github.com/gorilla/mux"
 )
 
 // Server exports API for starting and stopping web
@@ -31,24 +24,119 @@ import (
 // and accessing URL aliases.
 type Server interface {
 	// Exposes REST endpoints:
-	//   - /api/urls/{id}: supports GET and OPTIONS methods.
-	//     In case of existing id a permanent redirect (308) is
-	//     sent to the client. In case of non-existing id, not
-	//     found error (404) is sent to the client as JSON payload.
-	//     In case of error, only status 500 is sent to the client
-	//     and the error is logged
+	//   - /api/urls/{id}: supports GET, HEAD, POST and OPTIONS
+	//     methods. In case of existing id a redirect with the
+	//     redirect_status of the link or the configured one (308 by
+	//     default) is sent to the client, along with Cache-Control
+	//     matching it. HEAD replies with the same redirect without
+	//     counting the click. In case of non-existing id, not
+	//     found error (404) is sent to the client. Protected links
+	//     require the password in X-Link-Password header, otherwise
+	//     401 or 403 is sent, or unlock form for browsers. POST
+	//     accepts the password from the form and redirects with
+	//     303. Too many failed attempts are rejected with 429.
+	//     Links which have reached their maximum clicks or whose
+	//     expires_at has passed are rejected with gone error (410).
+	//     Expired links are kept for the retention period of the
+	//     database (7 days by default), afterwards they are not
+	//     found (404).
+	//     Links before their not_before are rejected with the
+	//     configured not active status (403 by default) or
+	//     redirected (302) to the configured page, with Retry-After.
+	//     Links with platform targets redirect iOS, Android and
+	//     desktop clients, classified by User-Agent, to the
+	//     respective targets. Links with variants redirect to
+	//     variant picked by the weights, sticky variants are kept
+	//     in link_variant cookie. Links with forward_query merge
+	//     the query of the request into the target, which wins
+	//     conflicting parameters unless query_override is set,
+	//     and default utm parameters are appended when neither
+	//     sets them. Browsers following links with preview, or
+	//     links outside of the configured trusted domains, receive
+	//     the preview page, whose continue button skips it for
+	//     10 minutes
+	//   - /api/urls/{id}+: supports GET, HEAD, POST and OPTIONS
+	//     methods. GET replies with the preview page (200) showing
+	//     the destination, its domain and the creation date of the
+	//     link along with continue button, the same as GET of
+	//     /api/urls/{id}?preview=1. The page does not count click.
+	//     POST unlocks protected links as for /api/urls/{id}
+	//   - /api/urls/{id}/qr: supports GET method. Replies with QR
+	//     code of the short url (200) as PNG or SVG, chosen by the
+	//     format query parameter, of the size, error correction
+	//     level and margin query parameters. The code is sent with
+	//     ETag and cacheable, matching If-None-Match gets 304.
+	//     Expired links are rejected with gone error (410)
 	//   - /api/urls: supports POST and OPTIONS methods. In case
 	//     of successful registration, the client receives reply for
-	//     created resource (201). In case of invalid payload or
-	//     invalid url in the payload, a bad request (400) error
-	//     is being sent. In case there is already existing entry
-	//     with the same id or url, a conflict error (409) is sent
-	//     to the client
+	//     created resource (201) with the absolute short url on the
+	//     domain of the request in the Location header. In case of malformed JSON, invalid
+	//     url or invalid id in the payload, a bad request (400) error
+	//     is being sent. In case the payload exceeds the size limit,
+	//     too large error (413) is sent. In case there is already
+	//     existing entry with the same id or url, a conflict error
+	//     (409) is sent to the client. In case the url is rejected
+	//     by the url policy for its scheme, private host or denied
+	//     domain, unprocessable entity error (422) is sent
 	//     The incoming payload should be JSON containing id (optional)
 	//     and url (required). In case of missing id, the server will
 	//     generate one automatically consisting of 6 symbols
+	//   - /api/v2/links: supports POST and GET methods. POST accepts
+	//     the same payload as /api/urls extended with tags, owner,
+	//     password, max_clicks, not_before, expires_at, targets,
+	//     variants, sticky_variants, forward_query, query_override,
+	//     utm, redirect_status and preview and replies with the
+	//     created link resource (201). GET replies with page of
+	//     links (200) filtered by the owner and tag query
+	//     parameters and paged by limit and offset
+	//   - /api/v2/links/{id}: supports GET, PATCH and DELETE methods.
+	//     GET replies with the link resource (200) containing id,
+	//     absolute short url, url, created_at, expires_at, click_count,
+	//     tags, owner, the settings of the link and the last health
+	//     check of its destination, once checked. PATCH accepts
+	//     JSON containing new expires_at and replies with the
+	//     updated link resource (200). DELETE removes the link (204)
+	//   - /api/v2/links/{id}/stats: supports GET method. Replies with
+	//     the click statistics of the link (200), broken down by
+	//     variant for links with variants
+	//   - /api/v2/domains: supports POST and GET methods. POST
+	//     registers branded domain from JSON containing host and
+	//     not_found_page (optional) and replies with the created
+	//     domain resource (201), or conflict error (409) for hosts
+	//     already registered or serving the service. GET replies
+	//     with all domains (200)
+	//   - /api/v2/domains/{host}: supports GET and DELETE methods.
+	//     GET replies with the domain resource (200), DELETE removes
+	//     the domain (204) keeping its links
+	//   - /api/openapi.json: supports GET method. Replies with the
+	//     OpenAPI 3 description of all endpoints
 	//  All endpoints support CORS requests.
-	//  The outgoing payload is JSON containing id, url and error.
+	//  With configured workspaces, requests see only the links of
+	//  the workspace selected by their API key, sent as bearer
+	//  authorization, or by their host. Invalid API keys are
+	//  rejected with 401, as well as /api/urls and /api/v2/links
+	//  requests without API key for workspaces which have them.
+	//  Creating links over the quota of the workspace is rejected
+	//  with 403.
+	//  Requests to branded domains see only the links of the
+	//  domain, so short links are resolved by host and id, and
+	//  browsers receive the not found page of the domain for ids
+	//  which do not exist. Domains are managed only in the default
+	//  workspace, other requests are rejected with 403.
+	//  Errors are sent as RFC 7807 application/problem+json payload
+	//  containing machine-readable code (invalid_json, body_too_large,
+	//  invalid_url, url_too_long, alias_invalid, tag_invalid,
+	//  owner_invalid, password_invalid, max_clicks_invalid,
+	//  not_before_invalid, expiration_invalid, target_invalid,
+	//  variant_invalid, utm_invalid, redirect_status_invalid,
+	//  url_blocked, url_self_reference, invalid_parameter,
+	//  alias_taken, url_taken, quota_exceeded, api_key_required,
+	//  api_key_invalid, host_invalid, not_found_page_invalid,
+	//  domain_taken, forbidden, password_required, password_incorrect,
+	//  too_many_attempts, link_exhausted, link_not_active,
+	//  link_expired, not_found, method_not_allowed, internal_error),
+	//  id and url of the affected entry and field-level errors.
+	//  Internal errors (500) are logged along with their cause
 	Handle()
 
 	// Shuts down the underlying DB worker and perform all
@@ -81,7 +169,12 @@ func NewServer(host string, port int, expiration int) (server Server, err error)
 		return
 	}
 
-	server = &web{host: host, port: port, dbWorker: dbWorker}
+	server = &web{
+		host:     host,
+		port:     port,
+		dbWorker: dbWorker,
+		handler:  NewHandler(dbWorker, Options{}, nil),
+	}
 	return
 }
 
@@ -89,23 +182,13 @@ type web struct {
 	host           string
 	port           int
 	dbWorker       db.Worker
-	webWorker      http.Server
+	handler        http.Handler
+	webWorker      *http.Server
 	isShuttingDown bool
 }
 
-type payload struct {
-	ID    string `json:"id"`
-	URL   string `json:"url"`
-	Error string `json:"error"`
-}
-
 func (server *web) Handle() {
-	r := mux.NewRouter()
-	r.HandleFunc("/api/urls/{id}", server.getURL).Methods("GET")
-	r.HandleFunc("/api/urls/{id}", server.handlePreflight).Methods("OPTIONS")
-	r.HandleFunc("/api/urls", server.addURL).Methods("POST")
-	r.HandleFunc("/api/urls", server.handlePreflight).Methods("OPTIONS")
-	server.webWorker = http.Server{Addr: fmt.Sprintf("%v:%v", server.host, server.port), Handler: r}
+	server.webWorker = newHTTPServer(fmt.Sprintf("%v:%v", server.host, server.port), server.handler, RunOptions{})
 
 	go server.stopListener()
 
@@ -132,204 +215,11 @@ func (server *web) Shutdown() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	server.webWorker.Shutdown(ctx)
-
-	log.Println("Web server successfully shut down")
-}
-
-func (server *web) handlePreflight(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	w.WriteHeader(http.StatusOK)
-}
-
-func (server *web) getURL(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	id := mux.Vars(r)["id"]
-	_, url, err := server.dbWorker.Find("id_to_url", id)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Error while retrieving data for id %v: %v", id, err)
-		return
-	}
-
-	if url != "" {
-		w.Header().Set("location", url)
-		w.WriteHeader(http.StatusPermanentRedirect)
-	} else {
-		idErr := payload{
-			ID:    id,
-			Error: fmt.Sprintf("ID %v does not exists", id),
-		}
-
-		idErrJSON, err := json.Marshal(idErr)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("Bad JSON format: %v", err)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		w.Write(idErrJSON)
-	}
-}
-
-func (server *web) addURL(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type")
-	w.Header().Set("Access-Control-Expose-Headers", "Location")
-
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Error while reading data: %v", err)
-		return
-	}
-
-	var b payload
-	err = json.Unmarshal(body, &b)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Bad JSON format: %v", err)
-		return
-	}
-
-	_, err = url.ParseRequestURI(b.URL)
-	if err != nil {
-		urlErr := payload{
-			ID:    b.ID,
-			URL:   "",
-			Error: fmt.Sprintf("Invalid url: %v", b.URL),
-		}
-
-		urlErrJSON, err := json.Marshal(urlErr)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("Bad JSON format: %v", err)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(urlErrJSON)
-		return
-	}
-
-	if len(b.ID) != 0 && len(b.ID) != 6 {
-		urlErr := payload{
-			ID:    b.ID,
-			URL:   b.URL,
-			Error: fmt.Sprintf("Invalid ID length: %v is %v character long. It should be exactly 6 characters long", b.ID, len(b.ID)),
-		}
-
-		urlErrJSON, err := json.Marshal(urlErr)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("Bad JSON format: %v", err)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(urlErrJSON)
-		return
-	}
-
-	for _, s := range b.ID {
-		if !(unicode.IsLetter(s) || (s >= '0' && s <= '9') || s == '_' || s == '-') {
-			urlErr := payload{
-				ID:    b.ID,
-				URL:   b.URL,
-				Error: fmt.Sprintf("ID contains forbidden characters: %v. Allowed characters: alphanumeric characters, underscore and dash", b.ID),
-			}
-
-			urlErrJSON, err := json.Marshal(urlErr)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				log.Printf("Bad JSON format: %v", err)
-				return
-			}
-
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write(urlErrJSON)
-			return
-		}
-	}
-
-	id, _, err := server.dbWorker.Find("url_to_id", b.URL)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Error while retrieving data for url %v: %v", b.URL, err)
-		return
-	}
-
-	if id != "" {
-		idErr := payload{
-			ID:    id,
-			URL:   b.URL,
-			Error: fmt.Sprintf("Url %v already registered under id %v", b.URL, id),
-		}
-
-		idErrJSON, err := json.Marshal(idErr)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("Bad JSON format: %v", err)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
-		w.Write(idErrJSON)
-		return
+	if server.webWorker != nil {
+		server.webWorker.Shutdown(ctx)
 	}
 
-	if b.ID == "" {
-		hasher := md5.New()
-		hasher.Write([]byte(b.URL))
-		hashed := hex.EncodeToString(hasher.Sum(nil))
-		b.ID = hashed[len(hashed)-6:]
-	}
-
-	_, url, err := server.dbWorker.Find("id_to_url", b.ID)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Error while retrieving data for id %v: %v", b.ID, err)
-		return
-	}
-
-	if url != "" {
-		idErr := payload{
-			ID:    b.ID,
-			URL:   url,
-			Error: fmt.Sprintf("ID %v already registered for url %v", b.ID, url),
-		}
-
-		idErrJSON, err := json.Marshal(idErr)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("Bad JSON format: %v", err)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
-		w.Write(idErrJSON)
-		return
-	}
-
-	err = server.dbWorker.Register(b.ID, b.URL)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Error while registering id %v for url %v: %v", b.ID, b.URL, err)
-		return
-	}
-
-	w.Header().Set("location", fmt.Sprintf("/%v", b.ID))
-	w.WriteHeader(http.StatusCreated)
+	log.Println("Web server successfully shut down")
 }
 
 func (server *web) stopListener() {
@@ -340,8 +340,8 @@ func TestHandlePostNoBody(t *testing.T) {
 			}
 
 			status := resp.StatusCode
-			if status != 500 {
-				t.Errorf("Expected status code 500, received: %v", status)
+			if status != 400 {
+				t.Errorf("Expected status code 400, received: %v", status)
 			}
 		}()
 
@@ -380,8 +380,8 @@ func TestHandlePostBadJson(t *testing.T) {
 			}
 
 			status := resp.StatusCode
-			if status != 500 {
-				t.Errorf("Expected status code 500, received: %v", status)
+			if status != 400 {
+				t.Errorf("Expected status code 400, received: %v", status)
 			}
 		}()
 
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+)
+
+type certReloader struct {
+	mu       sync.RWMutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+}
+
+func newCertReloader(certFile string, keyFile string) (reloader *certReloader, err error) {
+	reloader = &certReloader{certFile: certFile, keyFile: keyFile}
+
+	err = reloader.reload()
+	if err != nil {
+		reloader = nil
+		return
+	}
+
+	return
+}
+
+func (reloader *certReloader) reload() (err error) {
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return
+	}
+
+	reloader.mu.Lock()
+	reloader.cert = &cert
+	reloader.mu.Unlock()
+
+	return
+}
+
+func (reloader *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mu.RLock()
+	defer reloader.mu.RUnlock()
+
+	return reloader.cert, nil
+}
+
+func (reloader *certReloader) watch(done <-chan struct{}, signals <-chan os.Signal, logger *log.Logger) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-signals:
+			logger.Println("Reloading TLS certificate...")
+			err := reloader.reload()
+			if err != nil {
+				logger.Printf("Reloading TLS certificate failed: %v", err)
+				continue
+			}
+			logger.Println("TLS certificate successfully reloaded")
+		}
+	}
+}
+
+func newTLSConfig(reloader *certReloader) *tls.Config {
+	return &tls.Config{
+		GetCertificate: reloader.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+}
+
+func withHSTS(handler http.Handler, maxAge int) http.Handler {
+	value := fmt.Sprintf("max-age=%v; includeSubDomains", maxAge)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func redirectToHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		if port != 443 {
+			host = net.JoinHostPort(host, fmt.Sprintf("%v", port))
+		}
+
+		w.Header().Set("location", fmt.Sprintf("https://%v%v", host, r.URL.RequestURI()))
+		w.WriteHeader(http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,194 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/service"
+	"github.com/gorilla/mux"
+)
+
+// passwordHeader carries the password of protected links
+// for API clients
+const passwordHeader = "X-Link-Password"
+
+// accessStatus maps the codes of service.AccessError to the
+// status codes of the responses
+var accessStatus = map[string]int{
+	service.CodePasswordRequired:  http.StatusUnauthorized,
+	service.CodePasswordIncorrect: http.StatusForbidden,
+	service.CodeTooManyAttempts:   http.StatusTooManyRequests,
+	service.CodeLinkExhausted:     http.StatusGone,
+	service.CodeLinkExpired:       http.StatusGone,
+}
+
+// unlockCodes are the codes of service.AccessError for which
+// browsers receive the unlock form
+var unlockCodes = map[string]bool{
+	service.CodePasswordRequired:  true,
+	service.CodePasswordIncorrect: true,
+	service.CodeTooManyAttempts:   true,
+}
+
+var unlockPage = template.Must(template.New("unlock").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <meta name="robots" content="noindex">
+  <title>Protected link</title>
+</head>
+<body>
+  <form method="post">
+    <p>This link is protected by password.</p>
+    {{if .}}<p role="alert">{{.}}</p>{{end}}
+    <label>Password <input type="password" name="password" autocomplete="current-password" required autofocus></label>
+    <button type="submit">Unlock</button>
+  </form>
+</body>
+</html>
+`))
+
+func (handler *api) unlockURL(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, handler.options.MaxBodySize)
+
+	err := r.ParseForm()
+	if err != nil {
+		handler.writeProblem(w, r, problem{
+			Status: http.StatusBadRequest,
+			Code:   codeInvalidParameter,
+			Detail: fmt.Sprintf("Request body is not valid form: %v", err),
+		})
+		return
+	}
+
+	password := r.PostForm.Get("password")
+	if password == "" {
+		password = r.Header.Get(passwordHeader)
+	}
+
+	handler.resolve(w, r, password, http.StatusSeeOther, false)
+}
+
+// resolve redirects to the link registered under the id from
+// the path with the provided status, or with the redirect status
+// of the link in case it is 0. Protected links are unlocked with
+// the password first. The target is chosen by the platform of the
+// User-Agent and the variants of the link, the query of the
+// request is merged by the link settings. In case preview is set
+// or forced for the link, the preview page is sent instead of the
+// redirect. HEAD requests and previews are not counted as clicks
+func (handler *api) resolve(w http.ResponseWriter, r *http.Request, password string, status int, preview bool) {
+	id := mux.Vars(r)["id"]
+	linkService := handler.links(r)
+
+	link, err := linkService.Check(id, password)
+	if err != nil {
+		if e, ok := err.(*service.AccessError); ok {
+			if unlockCodes[e.Code] && wantsHTML(r) {
+				handler.writeUnlockPage(w, e)
+				return
+			}
+
+			if e.Code == service.CodeLinkNotActive && handler.options.NotActiveURL != "" {
+				setRetryAfter(w, e.RetryAfter)
+				http.Redirect(w, r, handler.options.NotActiveURL, http.StatusFound)
+				return
+			}
+		}
+
+		handler.writeServiceError(w, r, payload{ID: id}, err, "Error while retrieving data for id %v: %v", id, err)
+		return
+	}
+
+	if link == nil {
+		if handler.writeNotFoundPage(w, r) {
+			return
+		}
+
+		handler.writeProblem(w, r, problem{
+			Status: http.StatusNotFound,
+			Code:   codeNotFound,
+			Detail: fmt.Sprintf("ID %v does not exist", id),
+			ID:     id,
+		})
+		return
+	}
+
+	// the target depends on the platform, so caches should not
+	// share the redirect across user agents
+	if len(link.Targets) != 0 {
+		w.Header().Add("Vary", "User-Agent")
+	}
+
+	incoming := r.URL.Query()
+	incoming.Del(previewParam)
+
+	target, variant := linkService.Destination(link, r.UserAgent(), assignedVariant(r, link))
+	target = service.MergeQuery(link, target, incoming)
+
+	// browsers and API clients are treated differently
+	forced := handler.forcesPreview(link, target)
+	if forced {
+		w.Header().Add("Vary", "Accept")
+	}
+
+	if preview || forced && wantsHTML(r) && !handler.continued(r, id) {
+		handler.writePreviewPage(w, r, id, link, target)
+		return
+	}
+
+	if r.Method != http.MethodHead {
+		err = linkService.Click(link)
+		if err != nil {
+			handler.writeServiceError(w, r, payload{ID: id}, err, "Error while counting click for id %v: %v", id, err)
+			return
+		}
+
+		linkService.CountVariant(link, variant)
+		setVariantCookie(w, r, link, variant)
+	}
+
+	if status == 0 {
+		status = handler.redirectStatus(link)
+	}
+
+	w.Header().Set("Cache-Control", handler.cacheControl(link, status))
+	w.Header().Set("location", target)
+	w.WriteHeader(status)
+}
+
+func (handler *api) writeUnlockPage(w http.ResponseWriter, err *service.AccessError) {
+	var message string
+	if err.Code != service.CodePasswordRequired {
+		message = err.Detail
+	}
+
+	setRetryAfter(w, err.RetryAfter)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(accessStatus[err.Code])
+
+	unlockPage.Execute(w, message)
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds,
+// rounded up. Zero duration leaves the header unset
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	if wait <= 0 {
+		return
+	}
+
+	seconds := int64((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+}
+
+// wantsHTML reports whether the request comes from a browser
+// rather than an API client
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
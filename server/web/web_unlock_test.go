@@ -0,0 +1,144 @@
+package web_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/service"
+	"github.com/georgiv/url-shortener/server/web"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+func newProtectedHandler(t *testing.T) http.Handler {
+	dbWorker := testdata.NewMemoryWorker()
+	linkService := service.New(dbWorker, service.Options{MaxPasswordAttempts: 2, PasswordLockout: time.Minute})
+	handler := web.NewHandler(dbWorker, web.Options{LinkService: linkService}, nil)
+
+	body := `{"id": "secret", "url": "http://testurl.com", "password": "s3cr3t"}`
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/v2/links", bytes.NewBufferString(body)))
+
+	if w.Code != 201 {
+		t.Fatalf("Expected status code 201, received: %v", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"protected":true`) {
+		t.Errorf("Expected protected link, received %v", w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "s3cr3t") {
+		t.Errorf("Password leaked in %v", w.Body.String())
+	}
+
+	return handler
+}
+
+func getProtected(handler http.Handler, password string, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/api/urls/secret", nil)
+	if password != "" {
+		req.Header.Set("X-Link-Password", password)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestUnlockHeader(t *testing.T) {
+	handler := newProtectedHandler(t)
+
+	w := getProtected(handler, "", "")
+	if w.Code != 401 {
+		t.Fatalf("Expected status code 401, received: %v", w.Code)
+	}
+	if p := decodeProblem(t, w); p.Code != "password_required" {
+		t.Errorf("Expected password_required, received %v", p.Code)
+	}
+
+	w = getProtected(handler, "s3cr3t", "")
+	if w.Code != 308 {
+		t.Fatalf("Expected status code 308, received: %v", w.Code)
+	}
+	if location := w.Header().Get("Location"); location != "http://testurl.com" {
+		t.Errorf("Expected http://testurl.com, received %v", location)
+	}
+}
+
+func TestUnlockRateLimit(t *testing.T) {
+	handler := newProtectedHandler(t)
+
+	for i := 0; i < 2; i++ {
+		w := getProtected(handler, "wrong", "")
+		if w.Code != 403 {
+			t.Fatalf("Expected status code 403, received: %v", w.Code)
+		}
+		if p := decodeProblem(t, w); p.Code != "password_incorrect" {
+			t.Errorf("Expected password_incorrect, received %v", p.Code)
+		}
+	}
+
+	// the correct password is rejected as well until the lockout passes
+	w := getProtected(handler, "s3cr3t", "")
+	if w.Code != 429 {
+		t.Fatalf("Expected status code 429, received: %v", w.Code)
+	}
+	if p := decodeProblem(t, w); p.Code != "too_many_attempts" {
+		t.Errorf("Expected too_many_attempts, received %v", p.Code)
+	}
+	// the lockout window starts at the first failed attempt, so
+	// part of it may have already passed
+	if retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retryAfter < 1 || retryAfter > 60 {
+		t.Errorf("Expected Retry-After between 1 and 60, received %v", w.Header().Get("Retry-After"))
+	}
+}
+
+func TestUnlockForm(t *testing.T) {
+	handler := newProtectedHandler(t)
+
+	w := getProtected(handler, "", "text/html,application/xhtml+xml")
+	if w.Code != 401 {
+		t.Fatalf("Expected status code 401, received: %v", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/html") {
+		t.Errorf("Expected text/html, received %v", contentType)
+	}
+	if !strings.Contains(w.Body.String(), `<form method="post">`) {
+		t.Errorf("Expected unlock form, received %v", w.Body.String())
+	}
+
+	post := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/urls/secret", strings.NewReader(url.Values{"password": {password}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "text/html")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w = post("<wrong>")
+	if w.Code != 403 {
+		t.Fatalf("Expected status code 403, received: %v", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Incorrect password") {
+		t.Errorf("Expected error message, received %v", w.Body.String())
+	}
+
+	w = post("s3cr3t")
+	if w.Code != 303 {
+		t.Fatalf("Expected status code 303, received: %v", w.Code)
+	}
+	if location := w.Header().Get("Location"); location != "http://testurl.com" {
+		t.Errorf("Expected http://testurl.com, received %v", location)
+	}
+}
@@ -0,0 +1,93 @@
+package web
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+)
+
+// variantCookie carries the variant assigned to the visitor of
+// link with sticky variants. It is scoped to the path of the
+// link, so visitors keep separate variant per link
+const variantCookie = "link_variant"
+
+// variantPayload is the representation of a variant in the
+// payload for creating link
+type variantPayload struct {
+	Name   string `json:"name,omitempty"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight,omitempty"`
+}
+
+// variantResource is the representation of a variant in /api/v2
+type variantResource struct {
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	Weight     int    `json:"weight"`
+	ClickCount int64  `json:"click_count"`
+}
+
+func toVariants(variants []variantPayload) []db.Variant {
+	if len(variants) == 0 {
+		return nil
+	}
+
+	converted := make([]db.Variant, 0, len(variants))
+	for _, v := range variants {
+		converted = append(converted, db.Variant{Name: v.Name, URL: v.URL, Weight: v.Weight})
+	}
+
+	return converted
+}
+
+func variantResources(variants []db.Variant) []variantResource {
+	if len(variants) == 0 {
+		return nil
+	}
+
+	resources := make([]variantResource, 0, len(variants))
+	for _, v := range variants {
+		resources = append(resources, variantResource{Name: v.Name, URL: v.URL, Weight: v.Weight, ClickCount: v.ClickCount})
+	}
+
+	return resources
+}
+
+// assignedVariant returns the variant assigned to the visitor of
+// link with sticky variants, empty in case there is none
+func assignedVariant(r *http.Request, link *db.Link) string {
+	if !link.StickyVariants {
+		return ""
+	}
+
+	cookie, err := r.Cookie(variantCookie)
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
+
+// setVariantCookie remembers the variant of the visitor of link
+// with sticky variants until the link expires
+func setVariantCookie(w http.ResponseWriter, r *http.Request, link *db.Link, variant string) {
+	if !link.StickyVariants || variant == "" {
+		return
+	}
+
+	maxAge := int(time.Until(link.ExpiresAt) / time.Second)
+	if maxAge <= 0 {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     variantCookie,
+		Value:    variant,
+		Path:     r.URL.Path,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
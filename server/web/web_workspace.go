@@ -0,0 +1,121 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/georgiv/url-shortener/server/db"
+	"github.com/georgiv/url-shortener/server/service"
+	"github.com/georgiv/url-shortener/server/workspace"
+)
+
+// Codes of the problems sent for requests rejected by their
+// API key
+const (
+	codeAPIKeyRequired = "api_key_required"
+	codeAPIKeyInvalid  = "api_key_invalid"
+)
+
+// scope is the workspace of a request along with the link
+// service managing its links. Requests to branded domains are
+// scoped to the namespace of the domain
+type scope struct {
+	workspace   *workspace.Workspace
+	linkService *service.Service
+	keyed       bool
+
+	// host serves the short links of the scope, empty for the
+	// default workspace
+	host   string
+	domain *db.Domain
+}
+
+type scopeKey struct{}
+
+// withWorkspace resolves the workspace of the request by its
+// API key, sent as bearer authorization, or by its host, which
+// may be branded domain as well. Requests with API key which does
+// not belong to any workspace are rejected with 401
+func (handler *api) withWorkspace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := workspace.BearerKey(r.Header.Get("Authorization"))
+
+		ws, err := handler.options.Workspaces.Resolve(key, r.Host)
+		if err != nil {
+			handler.writeProblem(w, r, problem{
+				Status: http.StatusUnauthorized,
+				Code:   codeAPIKeyInvalid,
+				Detail: "API key does not belong to any workspace",
+			})
+			return
+		}
+
+		s := &scope{
+			workspace:   ws,
+			linkService: handler.linkService.Workspace(ws),
+			keyed:       key != "",
+		}
+		if len(ws.Hosts) != 0 {
+			s.host = ws.Hosts[0]
+		}
+
+		if ws.ID == "" && key == "" {
+			s.linkService, s.domain, err = handler.linkService.Host(r.Host)
+			if err != nil {
+				handler.writeInternalError(w, r, "Error while retrieving domain %v: %v", r.Host, err)
+				return
+			}
+
+			if s.domain != nil {
+				s.host = s.domain.Host
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), scopeKey{}, s)))
+	})
+}
+
+// managed allows managing the links of workspaces with API keys
+// only to requests with one of them. The other requests are
+// rejected with 401
+func (handler *api) managed(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s := requestScope(r); s != nil && s.workspace.RequiresKey() && !s.keyed {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			handler.writeProblem(w, r, problem{
+				Status: http.StatusUnauthorized,
+				Code:   codeAPIKeyRequired,
+				Detail: fmt.Sprintf("Managing the links of workspace %v requires API key", s.workspace.ID),
+			})
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// links returns the link service of the workspace of the request
+func (handler *api) links(r *http.Request) *service.Service {
+	if s := requestScope(r); s != nil {
+		return s.linkService
+	}
+
+	return handler.linkService
+}
+
+// servingHost returns the host serving the short links of the
+// workspace or the branded domain of the request, empty in case
+// it is the default workspace
+func servingHost(r *http.Request) string {
+	if s := requestScope(r); s != nil {
+		return s.host
+	}
+
+	return ""
+}
+
+func requestScope(r *http.Request) *scope {
+	s, _ := r.Context().Value(scopeKey{}).(*scope)
+	return s
+}
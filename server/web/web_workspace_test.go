@@ -0,0 +1,115 @@
+package web_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/georgiv/url-shortener/server/web"
+	"github.com/georgiv/url-shortener/server/workspace"
+	"github.com/georgiv/url-shortener/testdata"
+)
+
+func TestCreateLinkWorkspace(t *testing.T) {
+	registry, err := workspace.New([]workspace.Workspace{{
+		ID:       "acme",
+		Hosts:    []string{"go.acme.com"},
+		APIKeys:  []string{workspace.HashKey("acme-key")},
+		MaxLinks: 1,
+	}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	dbWorker := testdata.NewMemoryWorker()
+	dbWorker.Register("cranki", "https://google.com")
+
+	handler := web.NewHandler(dbWorker, web.Options{PublicURL: "https://s.example.com", Workspaces: registry}, nil)
+
+	send := func(method string, target string, body string, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	w := send("POST", "https://s.example.com/api/v2/links", `{"id": "cranki", "url": "https://bing.com"}`, "acme-key")
+	if w.Code != 201 {
+		t.Fatalf("Expected status code 201, received: %v", w.Code)
+	}
+
+	var l link
+	err = json.Unmarshal(w.Body.Bytes(), &l)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if l.ShortURL != "https://go.acme.com/api/urls/cranki" {
+		t.Errorf("Expected https://go.acme.com/api/urls/cranki, received %v", l.ShortURL)
+	}
+
+	// the short url is followed without the API key
+	w = send("GET", l.ShortURL, "", "")
+	if w.Code != 308 || w.Header().Get("Location") != "https://bing.com" {
+		t.Errorf("Expected redirect of %v to https://bing.com, received: %v %v", l.ShortURL, w.Code, w.Header().Get("Location"))
+	}
+
+	tests := []struct {
+		target   string
+		key      string
+		status   int
+		location string
+	}{
+		{"https://go.acme.com/api/urls/cranki", "", 308, "https://bing.com"},
+		{"https://s.example.com/api/urls/cranki", "", 308, "https://google.com"},
+		{"https://s.example.com/api/urls/cranki", "acme-key", 308, "https://bing.com"},
+		{"https://s.example.com/api/urls/cranki", "other-key", 401, ""},
+	}
+
+	for _, test := range tests {
+		w := send("GET", test.target, "", test.key)
+		if w.Code != test.status {
+			t.Errorf("Expected status code %v for %v, received: %v", test.status, test.target, w.Code)
+		}
+
+		if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("Expected location %v for %v, received %v", test.location, test.target, location)
+		}
+	}
+
+	problems := []struct {
+		method string
+		target string
+		body   string
+		key    string
+		status int
+		code   string
+	}{
+		{"GET", "https://go.acme.com/api/v2/links/cranki", "", "", 401, "api_key_required"},
+		{"POST", "https://go.acme.com/api/urls", `{"url": "https://example.com"}`, "", 401, "api_key_required"},
+		{"GET", "https://go.acme.com/api/v2/links", "", "other-key", 401, "api_key_invalid"},
+		{"POST", "https://go.acme.com/api/v2/links", `{"url": "https://example.com"}`, "acme-key", 403, "quota_exceeded"},
+	}
+
+	for _, test := range problems {
+		w := send(test.method, test.target, test.body, test.key)
+		if w.Code != test.status {
+			t.Errorf("Expected status code %v for %v %v, received: %v", test.status, test.method, test.target, w.Code)
+		}
+
+		var p struct {
+			Code string `json:"code"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &p)
+
+		if p.Code != test.code {
+			t.Errorf("Expected code %v for %v %v, received %v", test.code, test.method, test.target, p.Code)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Package workspace describes the tenants sharing one deployment.
+// Every workspace has its own namespace of link ids and urls, API
+// keys, default expiration and quota. Requests are assigned to a
+// workspace by their API key or, without one, by their host.
+//
+// Copyright 2019 cranki. All rights reserved.
+package workspace
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Workspace represents single tenant. The zero value is the
+// default workspace with empty id, which owns the links created
+// without workspace
+type Workspace struct {
+	// ID scopes the links of the workspace in the database
+	ID string `json:"id"`
+
+	// Hosts serve the short links of the workspace. Requests to
+	// them without API key are assigned to the workspace. At
+	// least one is required, the first one is the host of the
+	// short urls
+	Hosts []string `json:"hosts"`
+
+	// APIKeys are the hex encoded SHA-256 hashes of the API keys
+	// of the workspace, see HashKey. In case it is not empty,
+	// managing the links requires one of the keys
+	APIKeys []string `json:"api_keys"`
+
+	// Expiration is the default expiration period in days of the
+	// links. In case it is 0, the default of the server is used
+	Expiration int `json:"expiration"`
+
+	// MaxLinks is the maximum number of links which have not
+	// expired yet. In case it is 0, the links are unlimited
+	MaxLinks int64 `json:"max_links"`
+}
+
+// RequiresKey reports whether managing the links of the
+// workspace requires API key
+func (workspace *Workspace) RequiresKey() bool {
+	return len(workspace.APIKeys) != 0
+}
+
+// ErrInvalidKey is returned by Registry.Resolve for API keys
+// which do not belong to any workspace
+var ErrInvalidKey = errors.New("Invalid API key")
+
+// Registry holds the workspaces of the deployment. It is safe
+// for concurrent use
+type Registry struct {
+	workspaces []*Workspace
+	byHost     map[string]*Workspace
+	byKey      map[string]*Workspace
+}
+
+var idPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// New validates the workspaces and returns Registry holding
+// them. The ids, the hosts and the API keys should be unique.
+// Every workspace should have host, otherwise its short links
+// could not be followed without API key
+func New(workspaces []Workspace) (*Registry, error) {
+	registry := &Registry{
+		byHost: make(map[string]*Workspace),
+		byKey:  make(map[string]*Workspace),
+	}
+
+	ids := make(map[string]bool, len(workspaces))
+	for i := range workspaces {
+		workspace := workspaces[i]
+
+		if !idPattern.MatchString(workspace.ID) {
+			return nil, fmt.Errorf("Invalid workspace id: %q. It should be 1 to 64 alphanumeric characters, underscores or dashes", workspace.ID)
+		}
+
+		if ids[workspace.ID] {
+			return nil, fmt.Errorf("Duplicate workspace id: %v", workspace.ID)
+		}
+		ids[workspace.ID] = true
+
+		if workspace.Expiration < 0 || workspace.MaxLinks < 0 {
+			return nil, fmt.Errorf("Invalid workspace %v: expiration and max_links should not be negative", workspace.ID)
+		}
+
+		if len(workspace.Hosts) == 0 {
+			return nil, fmt.Errorf("Workspace %v has no hosts: its short links would not be reachable", workspace.ID)
+		}
+
+		for _, host := range workspace.Hosts {
+			host = normalizeHost(host)
+			if other, ok := registry.byHost[host]; ok {
+				return nil, fmt.Errorf("Host %v belongs to workspaces %v and %v", host, other.ID, workspace.ID)
+			}
+			registry.byHost[host] = &workspace
+		}
+
+		workspace.APIKeys = append([]string(nil), workspace.APIKeys...)
+		for j, key := range workspace.APIKeys {
+			key = strings.ToLower(key)
+			if _, err := hex.DecodeString(key); err != nil || len(key) != sha256.Size*2 {
+				return nil, fmt.Errorf("Invalid API key of workspace %v: it should be hex encoded SHA-256 hash", workspace.ID)
+			}
+
+			if other, ok := registry.byKey[key]; ok {
+				return nil, fmt.Errorf("API key belongs to workspaces %v and %v", other.ID, workspace.ID)
+			}
+			registry.byKey[key] = &workspace
+			workspace.APIKeys[j] = key
+		}
+
+		registry.workspaces = append(registry.workspaces, &workspace)
+	}
+
+	return registry, nil
+}
+
+// Load reads the workspaces from JSON file containing array of
+// them and returns Registry holding them
+func Load(path string) (*Registry, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var workspaces []Workspace
+	err = json.Unmarshal(b, &workspaces)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid workspaces file %v: %v", path, err)
+	}
+
+	return New(workspaces)
+}
+
+// Workspaces returns all the workspaces except the default one
+func (registry *Registry) Workspaces() []*Workspace {
+	if registry == nil {
+		return nil
+	}
+
+	return registry.workspaces
+}
+
+// Resolve returns the workspace of the request with the API key
+// and the host. The API key, when present, selects its workspace
+// and ErrInvalidKey is returned in case there is no such. Without
+// API key the workspace serving the host is returned, or the
+// default workspace in case there is none. Nil registry means
+// the deployment has only the default workspace, so the API keys
+// are left to the gateways in front of it and ignored
+func (registry *Registry) Resolve(key string, host string) (*Workspace, error) {
+	if registry == nil {
+		return &Workspace{}, nil
+	}
+
+	if key != "" {
+		workspace, ok := registry.byKey[HashKey(key)]
+		if !ok {
+			return nil, ErrInvalidKey
+		}
+
+		return workspace, nil
+	}
+
+	if workspace, ok := registry.byHost[normalizeHost(host)]; ok {
+		return workspace, nil
+	}
+
+	return &Workspace{}, nil
+}
+
+// HashKey returns the hex encoded SHA-256 hash of the API key,
+// as stored in Workspace.APIKeys
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateKey returns new random API key
+func GenerateKey() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// BearerKey returns the API key of the Authorization header
+// value, empty in case it is not bearer authorization
+func BearerKey(authorization string) string {
+	const prefix = "bearer "
+	if len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return ""
+	}
+
+	return strings.TrimSpace(authorization[len(prefix):])
+}
+
+// normalizeHost returns the lowercase host without the port and
+// the trailing dot
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,95 @@
+package workspace_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/georgiv/url-shortener/server/workspace"
+)
+
+func TestResolve(t *testing.T) {
+	key, err := workspace.GenerateKey()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	registry, err := workspace.New([]workspace.Workspace{
+		{ID: "marketing", Hosts: []string{"go.example.com"}, APIKeys: []string{workspace.HashKey(key)}, Expiration: 30},
+		{ID: "docs", Hosts: []string{"Docs.Example.com"}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		key  string
+		host string
+		id   string
+		err  error
+	}{
+		{key, "docs.example.com", "marketing", nil},
+		{"", "go.example.com:8080", "marketing", nil},
+		{"", "docs.example.com.", "docs", nil},
+		{"", "s.example.com", "", nil},
+		{"other", "go.example.com", "", workspace.ErrInvalidKey},
+	}
+
+	for _, test := range tests {
+		ws, err := registry.Resolve(test.key, test.host)
+		if err != test.err {
+			t.Errorf("%v: expected error %v, received %v", test.host, test.err, err)
+			continue
+		}
+
+		if err == nil && ws.ID != test.id {
+			t.Errorf("%v: expected workspace %q, received %q", test.host, test.id, ws.ID)
+		}
+	}
+
+	var none *workspace.Registry
+	if ws, err := none.Resolve("other", "go.example.com"); err != nil || ws.ID != "" {
+		t.Errorf("Expected default workspace without registry, received %v, %v", ws, err)
+	}
+
+	if workspace.BearerKey("Bearer "+key) != key || workspace.BearerKey("Basic abc") != "" {
+		t.Errorf("Unexpected bearer keys")
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	hash := workspace.HashKey("key")
+
+	for _, workspaces := range [][]workspace.Workspace{
+		{{ID: "", Hosts: []string{"a.com"}}},
+		{{ID: "a b", Hosts: []string{"a.com"}}},
+		{{ID: "docs", Hosts: []string{"a.com"}}, {ID: "docs", Hosts: []string{"b.com"}}},
+		{{ID: "docs", Hosts: []string{"a.com"}}, {ID: "blog", Hosts: []string{"A.com"}}},
+		{{ID: "docs", Hosts: []string{"a.com"}, APIKeys: []string{hash}}, {ID: "blog", Hosts: []string{"b.com"}, APIKeys: []string{hash}}},
+		{{ID: "docs", Hosts: []string{"a.com"}, APIKeys: []string{"key"}}},
+		{{ID: "docs", Hosts: []string{"a.com"}, MaxLinks: -1}},
+		{{ID: "docs", APIKeys: []string{hash}}},
+		{{ID: "docs"}},
+	} {
+		if _, err := workspace.New(workspaces); err == nil {
+			t.Errorf("Expected error for %+v", workspaces)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "workspaces.json")
+	err := ioutil.WriteFile(file, []byte(`[{"id": "docs", "hosts": ["docs.example.com"], "max_links": 10}]`), 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	registry, err := workspace.Load(file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if workspaces := registry.Workspaces(); len(workspaces) != 1 || workspaces[0].MaxLinks != 10 {
+		t.Errorf("Unexpected workspaces: %+v", workspaces)
+	}
+}
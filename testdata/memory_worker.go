@@ -0,0 +1,475 @@
+package testdata
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/georgiv/url-shortener/server/db"
+)
+
+// MemoryWorker is in-memory implementation of db.Worker meant
+// for tests which should not depend on a running database.
+// Links expire after 7 days unless their expiration is set.
+// Expired links are kept until they are deleted
+type MemoryWorker struct {
+	*memoryStore
+
+	// links are the links of the workspace of the worker
+	links      map[string]*db.Link
+	expiration time.Duration
+	scoped     bool
+}
+
+// memoryStore holds the links of all workspaces along with the
+// domains and is shared by the workers scoped to the workspaces
+type memoryStore struct {
+	mu         sync.Mutex
+	closed     bool
+	workspaces map[string]map[string]*db.Link
+	domains    map[string]*db.Domain
+}
+
+// NewMemoryWorker creates and returns empty MemoryWorker
+func NewMemoryWorker() *MemoryWorker {
+	links := make(map[string]*db.Link)
+
+	return &MemoryWorker{
+		memoryStore: &memoryStore{
+			workspaces: map[string]map[string]*db.Link{"": links},
+			domains:    make(map[string]*db.Domain),
+		},
+		links:      links,
+		expiration: 7 * 24 * time.Hour,
+	}
+}
+
+func (worker *MemoryWorker) Find(stmtID string, param string) (id string, url string, err error) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	if worker.closed {
+		err = errors.New("sql: database is closed")
+		return
+	}
+
+	var found *db.Link
+
+	switch stmtID {
+	case "id_to_url":
+		found = worker.links[param]
+	case "url_to_id":
+		for _, link := range worker.links {
+			if normalizedURL(link) == param {
+				found = link
+			}
+		}
+	default:
+		panic(fmt.Sprintf("Missing statement ID: %v", stmtID))
+	}
+
+	if found == nil {
+		return
+	}
+
+	// as in the database, the expired links release their id and
+	// url, while they are kept until Create replaces them
+	if found.State(time.Now()) == db.StateExpired {
+		return
+	}
+
+	id, url = found.ID, found.URL
+
+	return
+}
+
+func (worker *MemoryWorker) Register(id string, url string) (err error) {
+	err = worker.Create(&db.Link{ID: id, URL: url})
+	return
+}
+
+func (worker *MemoryWorker) Get(id string) (link *db.Link, err error) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	if worker.closed {
+		err = errors.New("sql: database is closed")
+		return
+	}
+
+	found, ok := worker.links[id]
+	if !ok {
+		return
+	}
+
+	link = copyLink(found)
+
+	return
+}
+
+func (worker *MemoryWorker) Create(link *db.Link) (err error) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	if worker.closed {
+		return errors.New("sql: database is closed")
+	}
+
+	for _, l := range worker.links {
+		if l.ID == link.ID || l.URL == link.URL || normalizedURL(l) == normalizedURL(link) {
+			if l.State(time.Now()) == db.StateExpired {
+				delete(worker.links, l.ID)
+				continue
+			}
+
+			return fmt.Errorf("Error 1062: Duplicate entry '%v' for key 'PRIMARY'", link.ID)
+		}
+	}
+
+	now := time.Now().Truncate(time.Second)
+	link.CreatedAt = now
+	if link.ExpiresAt.IsZero() {
+		link.ExpiresAt = now.Add(worker.expiration)
+		if link.NotBefore.After(now) {
+			link.ExpiresAt = link.NotBefore.Add(worker.expiration)
+		}
+	}
+
+	worker.links[link.ID] = copyLink(link)
+
+	return
+}
+
+func (worker *MemoryWorker) Click(id string) (clicked bool, err error) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	if worker.closed {
+		err = errors.New("sql: database is closed")
+		return
+	}
+
+	link, ok := worker.links[id]
+	if !ok || link.Exhausted() {
+		return
+	}
+
+	link.ClickCount++
+	clicked = true
+
+	return
+}
+
+func (worker *MemoryWorker) ClickVariant(id string, name string) (clicked bool, err error) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	if worker.closed {
+		err = errors.New("sql: database is closed")
+		return
+	}
+
+	link, ok := worker.links[id]
+	if !ok {
+		return
+	}
+
+	for i := range link.Variants {
+		if link.Variants[i].Name == name {
+			link.Variants[i].ClickCount++
+			clicked = true
+		}
+	}
+
+	return
+}
+
+func (worker *MemoryWorker) Delete(id string) (deleted bool, err error) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	if worker.closed {
+		err = errors.New("sql: database is closed")
+		return
+	}
+
+	_, deleted = worker.links[id]
+	delete(worker.links, id)
+
+	return
+}
+
+func (worker *MemoryWorker) Extend(id string, expiresAt time.Time) (extended bool, err error) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	if worker.closed {
+		err = errors.New("sql: database is closed")
+		return
+	}
+
+	link, ok := worker.links[id]
+	if !ok || link.State(time.Now()) == db.StateExpired {
+		return
+	}
+
+	link.ExpiresAt = expiresAt.Truncate(time.Second)
+	extended = true
+
+	return
+}
+
+func (worker *MemoryWorker) RecordCheck(id string, check db.Check) (recorded bool, err error) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	if worker.closed {
+		err = errors.New("sql: database is closed")
+		return
+	}
+
+	link, ok := worker.links[id]
+	if !ok {
+		return
+	}
+
+	// as in the database, latency is stored in milliseconds and
+	// time in seconds
+	check.Latency = check.Latency.Truncate(time.Millisecond)
+	check.CheckedAt = check.CheckedAt.Truncate(time.Second)
+	link.LastCheck = check
+	recorded = true
+
+	return
+}
+
+func (worker *MemoryWorker) List(filter db.ListFilter) (links []*db.Link, err error) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	if worker.closed {
+		err = errors.New("sql: database is closed")
+		return
+	}
+
+	now := time.Now()
+
+	links = []*db.Link{}
+	for _, link := range worker.links {
+		if link.State(now) == db.StateExpired {
+			continue
+		}
+
+		if filter.Owner != "" && link.Owner != filter.Owner {
+			continue
+		}
+
+		if filter.Tag != "" && !contains(link.Tags, filter.Tag) {
+			continue
+		}
+
+		links = append(links, copyLink(link))
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		if !links[i].CreatedAt.Equal(links[j].CreatedAt) {
+			return links[i].CreatedAt.After(links[j].CreatedAt)
+		}
+		return links[i].ID < links[j].ID
+	})
+
+	if filter.Limit > 0 {
+		if filter.Offset >= len(links) {
+			links = []*db.Link{}
+			return
+		}
+
+		end := filter.Offset + filter.Limit
+		if end > len(links) {
+			end = len(links)
+		}
+		links = links[filter.Offset:end]
+	}
+
+	return
+}
+
+func (worker *MemoryWorker) Count() (count int64, err error) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	if worker.closed {
+		err = errors.New("sql: database is closed")
+		return
+	}
+
+	now := time.Now()
+	for _, link := range worker.links {
+		if link.State(now) != db.StateExpired {
+			count++
+		}
+	}
+
+	return
+}
+
+func (worker *MemoryWorker) Workspace(id string, expiration int) db.Worker {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	links, ok := worker.workspaces[id]
+	if !ok {
+		links = make(map[string]*db.Link)
+		worker.workspaces[id] = links
+	}
+
+	scoped := &MemoryWorker{
+		memoryStore: worker.memoryStore,
+		links:       links,
+		expiration:  worker.expiration,
+		scoped:      true,
+	}
+	if expiration > 0 {
+		scoped.expiration = time.Duration(expiration) * 24 * time.Hour
+	}
+
+	return scoped
+}
+
+func (worker *MemoryWorker) CreateDomain(domain *db.Domain) (err error) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	if worker.closed {
+		err = errors.New("sql: database is closed")
+		return
+	}
+
+	if _, ok := worker.domains[domain.Host]; ok {
+		err = fmt.Errorf("Error 1062: Duplicate entry '%v' for key 'PRIMARY'", domain.Host)
+		return
+	}
+
+	domain.CreatedAt = time.Unix(time.Now().Unix(), 0)
+
+	stored := *domain
+	worker.domains[domain.Host] = &stored
+
+	return
+}
+
+func (worker *MemoryWorker) GetDomain(host string) (domain *db.Domain, err error) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	if worker.closed {
+		err = errors.New("sql: database is closed")
+		return
+	}
+
+	if stored, ok := worker.domains[host]; ok {
+		found := *stored
+		domain = &found
+	}
+
+	return
+}
+
+func (worker *MemoryWorker) ListDomains() (domains []*db.Domain, err error) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	if worker.closed {
+		err = errors.New("sql: database is closed")
+		return
+	}
+
+	for _, stored := range worker.domains {
+		found := *stored
+		domains = append(domains, &found)
+	}
+
+	sort.Slice(domains, func(i, j int) bool {
+		return domains[i].Host < domains[j].Host
+	})
+
+	return
+}
+
+func (worker *MemoryWorker) DeleteDomain(host string) (deleted bool, err error) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	if worker.closed {
+		err = errors.New("sql: database is closed")
+		return
+	}
+
+	_, deleted = worker.domains[host]
+	delete(worker.domains, host)
+
+	return
+}
+
+func (worker *MemoryWorker) Shutdown() {
+	// as in the database, the store is owned by the worker
+	// returned by NewMemoryWorker
+	if worker.scoped {
+		return
+	}
+
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+
+	worker.closed = true
+}
+
+// copyLink copies the link along with its tags, targets, UTM
+// parameters and variants, so the stored links cannot be modified
+// by the callers
+func copyLink(link *db.Link) *db.Link {
+	copied := *link
+	copied.Tags = append([]string{}, link.Tags...)
+
+	if link.Targets != nil {
+		copied.Targets = make(map[string]string, len(link.Targets))
+		for platform, target := range link.Targets {
+			copied.Targets[platform] = target
+		}
+	}
+
+	if link.UTM != nil {
+		copied.UTM = make(map[string]string, len(link.UTM))
+		for key, value := range link.UTM {
+			copied.UTM[key] = value
+		}
+	}
+
+	if link.Variants != nil {
+		copied.Variants = append([]db.Variant{}, link.Variants...)
+	}
+
+	return &copied
+}
+
+// normalizedURL returns the url used for detecting duplicates of
+// the link, which is URL when NormalizedURL is not set
+func normalizedURL(link *db.Link) string {
+	if link.NormalizedURL != "" {
+		return link.NormalizedURL
+	}
+
+	return link.URL
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -73,13 +73,13 @@ func AddEntry(t *testing.T, id string, url string, expiration int) {
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO url(id, original_url, creation_time, expiration_time) VALUES(?, ?, UNIX_TIMESTAMP(), UNIX_TIMESTAMP() + %v)", expiration))
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO url(id, original_url, normalized_url, creation_time, expiration_time) VALUES(?, ?, ?, UNIX_TIMESTAMP(), UNIX_TIMESTAMP() + %v)", expiration))
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(id, url)
+	_, err = stmt.Exec(id, url, url)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -103,15 +103,11 @@ func DeleteAll(t *testing.T) {
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare("TRUNCATE TABLE url")
-	if err != nil {
-		t.Fatalf("Unexpected error: %v", err)
-	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec()
-	if err != nil {
-		t.Fatalf("Unexpected error: %v", err)
+	for _, table := range []string{"url", "url_target", "url_variant"} {
+		_, err = tx.Exec("TRUNCATE TABLE " + table)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
 	}
 
 	err = tx.Commit()